@@ -4,44 +4,90 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/dvfs/storage-node/pkg/api"
 	"github.com/dvfs/storage-node/pkg/config"
+	"github.com/dvfs/storage-node/pkg/idempotency"
+	"github.com/dvfs/storage-node/pkg/policy"
+	"github.com/dvfs/storage-node/pkg/replication"
 	"github.com/dvfs/storage-node/pkg/storage"
+	"github.com/dvfs/storage-node/pkg/utils"
+	"github.com/dvfs/storage-node/pkg/webhook"
 )
 
 func main() {
 	// Load configuration
 	cfg := config.Load()
 
+	// Extend the MIME registry before anything maps types to extensions
+	if cfg.MIMETypesFile != "" {
+		if err := utils.LoadMIMEConfig(cfg.MIMETypesFile); err != nil {
+			log.Fatalf("Failed to load MIME types: %v", err)
+		}
+	}
+
 	// Initialize storage
-	fileStorage, err := storage.NewFileStorage(cfg.StoragePath)
+	fileStorage, err := storage.NewFileStorage(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
 
-	// Initialize API router with instance ID
-	router := api.NewRouter(fileStorage, cfg.InstanceID)
+	// Load the upload policy
+	uploadPolicy, err := policy.NewEngine(cfg.UploadPolicyFile, cfg.UploadPolicyReloadInterval)
+	if err != nil {
+		log.Fatalf("Failed to load upload policy: %v", err)
+	}
+
+	// Open the record of idempotent uploads
+	idempotencyKeys, err := idempotency.NewStore(filepath.Join(cfg.StoragePath, "idempotency"), cfg.IdempotencyTTL)
+	if err != nil {
+		log.Fatalf("Failed to open idempotency records: %v", err)
+	}
+
+	// Start delivering webhooks, resuming any left in the outbox
+	webhooks, err := webhook.NewDispatcher(filepath.Join(cfg.StoragePath, "webhooks"), cfg.WebhooksFile, cfg.WebhookTimeout, cfg.WebhookMaxAttempts)
+	if err != nil {
+		log.Fatalf("Failed to load webhooks: %v", err)
+	}
+
+	// Replicas go to the peers listed in the configuration
+	if cfg.ReplicationFactor < 1 || cfg.ReplicationFactor > len(cfg.Peers)+1 {
+		log.Fatalf("Replication factor %d needs %d peers, %d configured", cfg.ReplicationFactor, cfg.ReplicationFactor-1, len(cfg.Peers))
+	}
+	if len(cfg.Peers) > 0 && cfg.ReplicationSecret == "" {
+		log.Fatalf("REPLICATION_SECRET must be set when REPLICATION_PEERS are configured")
+	}
+	replicator := replication.NewReplicator(cfg.Peers, cfg.ReplicationSecret, cfg.ReplicationTimeout)
 
-	// Setup HTTP server
+	// Initialize API router
+	router := api.NewRouter(fileStorage, uploadPolicy, idempotencyKeys, webhooks, replicator, cfg)
+
+	// Setup HTTP server. Request contexts are cancelled when shutdown
+	// begins so long polls and event streams end instead of holding it up.
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.Port),
 		Handler:      router.Routes(),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		BaseContext:  func(net.Listener) context.Context { return baseCtx },
 	}
+	server.RegisterOnShutdown(cancelRequests)
 
 	// Start server in a goroutine
 	go func() {
 		log.Printf("🚀 Storage node starting on port %d", cfg.Port)
 		log.Printf("🆔 Instance ID: %s", cfg.InstanceID)
 		log.Printf("📁 Storage path: %s", cfg.StoragePath)
+		log.Printf("💽 Data disks: %d (%s placement)", len(cfg.StoragePaths), cfg.Placement)
 		log.Printf("🌐 API endpoints available at: http://localhost:%d/api/v1/files", cfg.Port)
 		log.Printf("❤️  Health check: http://localhost:%d/health", cfg.Port)
 		log.Printf("🔍 Instance info: http://localhost:%d/api/v1/instance", cfg.Port)
@@ -65,5 +111,11 @@ func main() {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	replicator.Close()
+	idempotencyKeys.Close()
+	uploadPolicy.Close()
+	fileStorage.Close()
+	webhooks.Close()
+
 	log.Println("✅ Server exited gracefully")
 }
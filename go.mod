@@ -2,4 +2,7 @@ module github.com/dvfs/storage-node
 
 go 1.21
 
-require github.com/google/uuid v1.4.0
+require (
+	github.com/google/uuid v1.4.0
+	golang.org/x/text v0.14.0
+)
@@ -0,0 +1,148 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/archive"
+	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/storage"
+	"github.com/dvfs/storage-node/pkg/utils"
+)
+
+// maxArchiveEntries caps the number of files in one archive request
+const maxArchiveEntries = 10000
+
+// Handler handles archive-related HTTP requests
+type Handler struct {
+	storage *storage.FileStorage
+}
+
+// NewHandler creates a new archive handler
+func NewHandler(storage *storage.FileStorage) *Handler {
+	return &Handler{
+		storage: storage,
+	}
+}
+
+// CreateArchive handles POST /api/v1/archive. The archive is built on the
+// fly from stored files and streamed to the client without temp files.
+func (h *Handler) CreateArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.ArchiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	format, err := archive.ParseFormat(req.Format)
+	if err != nil {
+		h.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Files) == 0 || len(req.Files) > maxArchiveEntries {
+		h.sendError(w, fmt.Sprintf("between 1 and %d files are required", maxArchiveEntries), http.StatusBadRequest)
+		return
+	}
+
+	// Resolve every entry up front so missing files are reported before
+	// any bytes are streamed
+	names := make(archive.UniqueNames)
+	paths := make([]string, len(req.Files))
+	var missing, blocked []string
+	for i, entry := range req.Files {
+		metadata, err := h.storage.GetMetadata(entry.ID)
+		if err != nil {
+			missing = append(missing, entry.ID)
+			continue
+		}
+		if err := h.storage.Servable(metadata); err != nil {
+			blocked = append(blocked, entry.ID)
+			continue
+		}
+
+		var name string
+		if entry.Path != "" {
+			if name = archive.CleanPath(entry.Path); name == "" {
+				h.sendError(w, "Invalid archive path: "+entry.Path, http.StatusBadRequest)
+				return
+			}
+		} else if name = archive.CleanPath(metadata.OriginalName); name == "" {
+			name = metadata.ID + metadata.Extension
+		}
+		paths[i] = names.Claim(name)
+	}
+	if len(missing) > 0 {
+		h.sendError(w, "Files not found: "+strings.Join(missing, ", "), http.StatusNotFound)
+		return
+	}
+	if len(blocked) > 0 {
+		h.sendError(w, "Files blocked by malware scanning: "+strings.Join(blocked, ", "), http.StatusForbidden)
+		return
+	}
+
+	filename := utils.SanitizeFileName(req.Name)
+	if filename == "" {
+		filename = "archive"
+	}
+	if !strings.HasSuffix(strings.ToLower(filename), archive.Extension(format)) {
+		filename += archive.Extension(format)
+	}
+
+	// Large archives take longer than the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", archive.ContentType(format))
+	w.Header().Set("Content-Disposition", utils.ContentDisposition("attachment", filename))
+	w.WriteHeader(http.StatusOK)
+
+	aw, err := archive.NewWriter(format, w)
+	if err != nil {
+		log.Printf("Failed to start archive: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+	for i, entry := range req.Files {
+		if err := h.addFile(aw, entry.ID, paths[i]); err != nil {
+			// Headers are already sent; abort so the client sees a
+			// truncated download instead of a corrupt archive
+			log.Printf("Failed to add %s to archive: %v", entry.ID, err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		log.Printf("Failed to finish archive: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// addFile streams one stored file into the archive
+func (h *Handler) addFile(aw archive.Writer, fileID, name string) error {
+	reader, metadata, err := h.storage.Open(fileID)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return aw.Add(name, metadata.Size, metadata.UpdatedAt, reader)
+}
+
+// sendError sends an error response
+func (h *Handler) sendError(w http.ResponseWriter, message string, statusCode int) {
+	errorResp := &models.ErrorResponse{
+		Error:   http.StatusText(statusCode),
+		Code:    statusCode,
+		Message: message,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(errorResp)
+}
@@ -0,0 +1,162 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dvfs/storage-node/pkg/config"
+	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/storage"
+)
+
+// newTestHandler creates a handler over a storage in a temporary directory
+func newTestHandler(t *testing.T) (*Handler, *storage.FileStorage) {
+	t.Helper()
+	dir := t.TempDir()
+	fs, err := storage.NewFileStorage(&config.Config{
+		StoragePath:    dir,
+		StoragePaths:   []string{dir},
+		PackVolumeSize: 1 << 20,
+	})
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return NewHandler(fs), fs
+}
+
+// store saves content under name and returns its ID
+func store(t *testing.T, fs *storage.FileStorage, name, content string) string {
+	t.Helper()
+	metadata, err := fs.Store(&models.FileUploadRequest{Content: []byte(content), FileName: name, ContentType: "text/plain"})
+	if err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	return metadata.ID
+}
+
+// createArchive posts body to the archive endpoint
+func createArchive(h *Handler, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.CreateArchive(rec, httptest.NewRequest(http.MethodPost, "/api/v1/archive", strings.NewReader(body)))
+	return rec
+}
+
+// entries returns the "name=content" entries of a ZIP or tar.gz archive,
+// sorted by name
+func entries(t *testing.T, contentType string, data []byte) []string {
+	t.Helper()
+	var files []string
+	switch contentType {
+	case "application/zip":
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("zip.NewReader: %v", err)
+		}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("opening %s: %v", f.Name, err)
+			}
+			content, _ := io.ReadAll(rc)
+			rc.Close()
+			files = append(files, f.Name+"="+string(content))
+		}
+	case "application/gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("gzip.NewReader: %v", err)
+		}
+		tr := tar.NewReader(gz)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("reading tar: %v", err)
+			}
+			content, _ := io.ReadAll(tr)
+			files = append(files, header.Name+"="+string(content))
+		}
+	default:
+		t.Fatalf("unexpected Content-Type %q", contentType)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestCreateArchive(t *testing.T) {
+	h, fs := newTestHandler(t)
+	a := store(t, fs, "notes.txt", "alpha")
+	b := store(t, fs, "notes.txt", "beta")
+	c := store(t, fs, "report.txt", "gamma")
+
+	body := `{"name":"bundle","format":"%s","files":[` +
+		`{"id":"` + a + `"},{"id":"` + b + `"},{"id":"` + c + `","path":"/docs/final.txt"}]}`
+	tests := []struct {
+		format      string
+		contentType string
+		filename    string
+	}{
+		{"", "application/zip", "bundle.zip"},
+		{"tgz", "application/gzip", "bundle.tar.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			rec := createArchive(h, strings.Replace(body, "%s", tt.format, 1))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("archive returned %d: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if got := rec.Header().Get("Content-Disposition"); !strings.Contains(got, `filename="`+tt.filename+`"`) {
+				t.Errorf("Content-Disposition = %q, want filename %s", got, tt.filename)
+			}
+
+			// Duplicate names are numbered and paths are kept relative
+			want := "docs/final.txt=gamma,notes (1).txt=beta,notes.txt=alpha"
+			if got := entries(t, tt.contentType, rec.Body.Bytes()); strings.Join(got, ",") != want {
+				t.Errorf("archive holds %v, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestCreateArchiveRejectsRequests(t *testing.T) {
+	h, fs := newTestHandler(t)
+	id := store(t, fs, "a.txt", "alpha")
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"unknown file", `{"files":[{"id":"` + id + `"},{"id":"3f2b8c1e-0000-4000-8000-000000000000"}]}`, http.StatusNotFound},
+		{"no files", `{"files":[]}`, http.StatusBadRequest},
+		{"unknown format", `{"format":"rar","files":[{"id":"` + id + `"}]}`, http.StatusBadRequest},
+		{"escaping path", `{"files":[{"id":"` + id + `","path":"../a.txt"}]}`, http.StatusBadRequest},
+		{"invalid JSON", `{"files":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := createArchive(h, tt.body)
+			if rec.Code != tt.status {
+				t.Errorf("archive returned %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			// Errors are reported before any archive bytes are sent
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("error response has Content-Type %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package changes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/changes"
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// Page sizes and waiting times for change requests
+const (
+	defaultLimit = 1000
+	maxLimit     = 10000
+
+	defaultWait = 30 * time.Second
+	maxWait     = 5 * time.Minute
+
+	// heartbeatInterval is how often an idle event stream sends a comment
+	// so proxies do not close it
+	heartbeatInterval = 15 * time.Second
+)
+
+// resyncMessage tells a client whose cursor cannot be continued from what
+// to do instead
+const resyncMessage = "Cursor is too old or unknown; resync from /api/v1/inventory and follow changes from the current cursor"
+
+// Handler serves the change log
+type Handler struct {
+	log *changes.Log
+}
+
+// NewHandler creates a new changes handler
+func NewHandler(changeLog *changes.Log) *Handler {
+	return &Handler{
+		log: changeLog,
+	}
+}
+
+// ListChanges handles GET /api/v1/changes?since=N. Changes after the
+// cursor are returned as JSON, waiting up to ?wait= for one to happen.
+// Clients sending Accept: text/event-stream get a Server-Sent Events
+// stream instead, which may resume from Last-Event-ID.
+func (h *Handler) ListChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	since, err := parseCursor(r)
+	if err != nil {
+		h.sendError(w, "Invalid since cursor", http.StatusBadRequest)
+		return
+	}
+	limit := defaultLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxLimit {
+			h.sendError(w, fmt.Sprintf("limit must be between 1 and %d", maxLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		h.streamChanges(w, r, since, limit)
+		return
+	}
+
+	wait := defaultWait
+	if value := r.URL.Query().Get("wait"); value != "" {
+		wait, err = time.ParseDuration(value)
+		if err != nil || wait < 0 || wait > maxWait {
+			h.sendError(w, fmt.Sprintf("wait must be a duration between 0s and %s", maxWait), http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := h.log.Since(since, limit+1)
+	if err == nil && len(events) == 0 && wait > 0 {
+		// Long poll: hold the request until something changes. The write
+		// deadline is pushed out so the server timeout does not cut it off.
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 10*time.Second))
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		h.log.Wait(ctx, since)
+		cancel()
+		events, err = h.log.Since(since, limit+1)
+	}
+	if err != nil {
+		h.sendCursorError(w, err)
+		return
+	}
+
+	response := &models.ChangesResponse{
+		Changes: events,
+		Cursor:  since,
+	}
+	if len(events) > limit {
+		response.Changes = events[:limit]
+		response.More = true
+	}
+	if response.Changes == nil {
+		response.Changes = []changes.Event{}
+	}
+	if n := len(response.Changes); n > 0 {
+		response.Cursor = response.Changes[n-1].Seq
+	}
+	h.sendJSON(w, response, http.StatusOK)
+}
+
+// streamChanges sends changes after since as Server-Sent Events until the
+// client disconnects. Each event carries its sequence number as the id.
+func (h *Handler) streamChanges(w http.ResponseWriter, r *http.Request, since uint64, limit int) {
+	// Check the cursor while a plain error response can still be sent
+	events, err := h.log.Since(since, limit)
+	if err != nil {
+		h.sendCursorError(w, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		for _, event := range events {
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data)
+			since = event.Seq
+		}
+		if len(events) == 0 {
+			fmt.Fprint(w, ": keepalive\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		if len(events) < limit {
+			ctx, cancel := context.WithTimeout(r.Context(), heartbeatInterval)
+			h.log.Wait(ctx, since)
+			cancel()
+		}
+		if r.Context().Err() != nil {
+			return
+		}
+
+		events, err = h.log.Since(since, limit)
+		if err != nil {
+			// The client fell behind retention; tell it to start over
+			data, _ := json.Marshal(&models.ErrorResponse{
+				Error:   http.StatusText(http.StatusGone),
+				Code:    http.StatusGone,
+				Message: resyncMessage,
+			})
+			fmt.Fprintf(w, "event: resync\ndata: %s\n\n", data)
+			rc.Flush()
+			return
+		}
+	}
+}
+
+// parseCursor reads the cursor from ?since= or, for reconnecting event
+// streams, the Last-Event-ID header. A missing cursor starts at the
+// beginning of the log.
+func parseCursor(r *http.Request) (uint64, error) {
+	value := r.URL.Query().Get("since")
+	if value == "" {
+		value = r.Header.Get("Last-Event-ID")
+	}
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// sendCursorError writes the response for a failed read of the log
+func (h *Handler) sendCursorError(w http.ResponseWriter, err error) {
+	if errors.Is(err, changes.ErrCursorExpired) || errors.Is(err, changes.ErrCursorAhead) {
+		w.Header().Set("X-Changes-Cursor", strconv.FormatUint(h.log.Last(), 10))
+		h.sendError(w, resyncMessage, http.StatusGone)
+		return
+	}
+	log.Printf("Failed to read change log: %v", err)
+	h.sendError(w, "Failed to read change log", http.StatusInternalServerError)
+}
+
+// sendJSON sends a JSON response
+func (h *Handler) sendJSON(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(data)
+}
+
+// sendError sends an error response
+func (h *Handler) sendError(w http.ResponseWriter, message string, statusCode int) {
+	errorResp := &models.ErrorResponse{
+		Error:   http.StatusText(statusCode),
+		Code:    statusCode,
+		Message: message,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(errorResp)
+}
@@ -0,0 +1,125 @@
+package files
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dvfs/storage-node/pkg/storage"
+	"github.com/dvfs/storage-node/pkg/webhook"
+)
+
+// AppendFile handles POST /api/v1/files/{id}/append. The raw request body
+// is added to the end of the file. An X-Expected-Offset header (or offset
+// query parameter) makes the append conditional on the current size.
+func (h *Handler) AppendFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileID := h.extractFileID(r.URL.Path)
+	if fileID == "" {
+		h.sendError(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	expectedOffset := int64(-1)
+	offset := r.Header.Get("X-Expected-Offset")
+	if offset == "" {
+		offset = r.URL.Query().Get("offset")
+	}
+	if offset != "" {
+		parsed, err := strconv.ParseInt(offset, 10, 64)
+		if err != nil || parsed < 0 {
+			h.sendError(w, "Invalid expected offset", http.StatusBadRequest)
+			return
+		}
+		expectedOffset = parsed
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.sendError(w, "Failed to read request body", http.StatusInternalServerError)
+		return
+	}
+
+	metadata, err := h.storage.Append(fileID, expectedOffset, r.Header.Get("If-Match"), data, h.checkFile)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrOffsetMismatch):
+			w.Header().Set("X-Next-Offset", strconv.FormatInt(metadata.Size, 10))
+			h.sendError(w, "Expected offset does not match current size "+strconv.FormatInt(metadata.Size, 10), http.StatusPreconditionFailed)
+		default:
+			h.sendAppendError(w, fileID, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", metadata.ETag())
+	w.Header().Set("X-Next-Offset", strconv.FormatInt(metadata.Size, 10))
+	h.notify(webhook.EventUpdate, metadata)
+	h.syncReplicas(metadata)
+	h.sendJSON(w, h.infoResponse(metadata), http.StatusOK)
+}
+
+// SealFile handles POST /api/v1/files/{id}/seal
+func (h *Handler) SealFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileID := h.extractFileID(r.URL.Path)
+	if fileID == "" {
+		h.sendError(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := h.storage.Seal(fileID, r.Header.Get("If-Match"))
+	if err != nil {
+		h.sendAppendError(w, fileID, err)
+		return
+	}
+
+	w.Header().Set("ETag", metadata.ETag())
+	h.notify(webhook.EventUpdate, metadata)
+	h.syncReplicas(metadata)
+	h.sendJSON(w, h.infoResponse(metadata), http.StatusOK)
+}
+
+// sendAppendError maps errors from Append and Seal to responses
+func (h *Handler) sendAppendError(w http.ResponseWriter, fileID string, err error) {
+	if h.sendScanError(w, err) {
+		return
+	}
+	var rejection *policyRejection
+	switch {
+	case errors.As(err, &rejection):
+		h.sendPolicyError(w, rejection)
+	case errors.Is(err, storage.ErrNotAppendable), errors.Is(err, storage.ErrSealed):
+		h.sendError(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, storage.ErrPreconditionFailed):
+		h.sendError(w, "File has been modified", http.StatusPreconditionFailed)
+	case strings.Contains(err.Error(), "not found"):
+		h.sendError(w, "File not found", http.StatusNotFound)
+	default:
+		log.Printf("Failed to append to file %s: %v", fileID, err)
+		h.sendError(w, "Failed to append to file", http.StatusInternalServerError)
+	}
+}
+
+// parseFlag reads a boolean from a query parameter or header
+func parseFlag(r *http.Request, param, header string) (bool, error) {
+	value := r.URL.Query().Get(param)
+	if value == "" {
+		value = r.Header.Get(header)
+	}
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
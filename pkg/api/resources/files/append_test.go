@@ -0,0 +1,145 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// appendRequest sends data to the end of fileID with the given headers
+func appendRequest(h *Handler, fileID, data string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/files/"+fileID+"/append", strings.NewReader(data))
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	h.AppendFile(rec, req)
+	return rec
+}
+
+// download returns the content served for fileID
+func download(t *testing.T, h *Handler, fileID string) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.GetFile(rec, httptest.NewRequest(http.MethodGet, "/api/v1/files/"+fileID, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("download returned %d: %s", rec.Code, rec.Body)
+	}
+	return rec.Body.String()
+}
+
+func TestAppendExpectedOffset(t *testing.T) {
+	h := newTestHandler(t, nil)
+	file := uploadTestFile(t, h, "log.txt", "abc", map[string]string{"X-Appendable": "true"})
+
+	rec := appendRequest(h, file.ID, "def", map[string]string{"X-Expected-Offset": "3"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("append at the current size returned %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("X-Next-Offset"); got != "6" {
+		t.Errorf("X-Next-Offset = %q, want 6", got)
+	}
+
+	// A retry of the same append finds the file already grown
+	rec = appendRequest(h, file.ID, "def", map[string]string{"X-Expected-Offset": "3"})
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("append at a stale offset returned %d, want 412", rec.Code)
+	}
+	if got := rec.Header().Get("X-Next-Offset"); got != "6" {
+		t.Errorf("X-Next-Offset after a mismatch = %q, want 6", got)
+	}
+
+	// The query parameter works like the header
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/files/"+file.ID+"/append?offset=6", strings.NewReader("ghi"))
+	rec = httptest.NewRecorder()
+	h.AppendFile(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("append with ?offset returned %d: %s", rec.Code, rec.Body)
+	}
+
+	for _, offset := range []string{"-1", "six"} {
+		if rec := appendRequest(h, file.ID, "x", map[string]string{"X-Expected-Offset": offset}); rec.Code != http.StatusBadRequest {
+			t.Errorf("append with offset %q returned %d, want 400", offset, rec.Code)
+		}
+	}
+
+	if got := download(t, h, file.ID); got != "abcdefghi" {
+		t.Errorf("file holds %q, want abcdefghi", got)
+	}
+	// The checksum covers everything appended
+	metadata, err := h.storage.GetMetadata(file.ID)
+	if err != nil {
+		t.Fatalf("GetMetadata: %v", err)
+	}
+	sum := sha256.Sum256([]byte("abcdefghi"))
+	if metadata.Size != 9 || metadata.Checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("size %d and checksum %s, want those of the whole content", metadata.Size, metadata.Checksum)
+	}
+}
+
+func TestAppendIfMatch(t *testing.T) {
+	h := newTestHandler(t, nil)
+	file := uploadTestFile(t, h, "log.txt", "abc", map[string]string{"X-Appendable": "true"})
+	etag := currentETag(t, h, file.ID)
+
+	rec := appendRequest(h, file.ID, "def", map[string]string{"If-Match": etag})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("append with the current ETag returned %d: %s", rec.Code, rec.Body)
+	}
+	if next := rec.Header().Get("ETag"); next == "" || next == etag {
+		t.Errorf("append reported ETag %q, want a new one", next)
+	}
+	if rec := appendRequest(h, file.ID, "ghi", map[string]string{"If-Match": etag}); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("append with a stale ETag returned %d, want 412", rec.Code)
+	}
+	if got := download(t, h, file.ID); got != "abcdef" {
+		t.Errorf("file holds %q, want abcdef", got)
+	}
+}
+
+func TestAppendRequiresAppendableFile(t *testing.T) {
+	h := newTestHandler(t, nil)
+	plain := uploadTestFile(t, h, "plain.txt", "abc", nil)
+
+	if rec := appendRequest(h, plain.ID, "def", nil); rec.Code != http.StatusConflict {
+		t.Errorf("append to a plain file returned %d, want 409", rec.Code)
+	}
+	if rec := appendRequest(h, "3f2b8c1e-0000-4000-8000-000000000000", "def", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("append to an unknown file returned %d, want 404", rec.Code)
+	}
+}
+
+func TestSealFile(t *testing.T) {
+	h := newTestHandler(t, nil)
+	file := uploadTestFile(t, h, "log.txt", "abc", map[string]string{"X-Appendable": "true"})
+
+	seal := func(ifMatch string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/files/"+file.ID+"/seal", nil)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.SealFile(rec, req)
+		return rec.Code
+	}
+
+	if code := seal(`"stale"`); code != http.StatusPreconditionFailed {
+		t.Errorf("seal with a stale ETag returned %d, want 412", code)
+	}
+	if code := seal(currentETag(t, h, file.ID)); code != http.StatusOK {
+		t.Fatalf("seal returned %d", code)
+	}
+	if rec := appendRequest(h, file.ID, "def", nil); rec.Code != http.StatusConflict {
+		t.Errorf("append to a sealed file returned %d, want 409", rec.Code)
+	}
+	// Sealing again is harmless
+	if code := seal(""); code != http.StatusOK {
+		t.Errorf("sealing twice returned %d, want 200", code)
+	}
+	if got := download(t, h, file.ID); got != "abc" {
+		t.Errorf("sealed file holds %q, want abc", got)
+	}
+}
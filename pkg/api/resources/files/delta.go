@@ -0,0 +1,183 @@
+package files
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dvfs/storage-node/pkg/delta"
+	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/policy"
+	"github.com/dvfs/storage-node/pkg/storage"
+	"github.com/dvfs/storage-node/pkg/webhook"
+)
+
+// invalidDeltaError marks a delta that cannot be applied to the stored file
+type invalidDeltaError struct {
+	err error
+}
+
+func (e *invalidDeltaError) Error() string {
+	return e.err.Error()
+}
+
+// deltaOverhead allows for the JSON framing of the ops when a delta body is
+// held to the size of the version it may produce
+const deltaOverhead = 1 << 20
+
+// deltaLimit returns the largest new version a delta may build from the
+// file: DELTA_MAX_BYTES, lowered to the largest size the upload policy
+// allows for the file's name, type and tags. byPolicy reports whether the
+// policy set the limit, in which case upload describes the file to it. A
+// zero maxSize means no limit.
+func (h *Handler) deltaLimit(metadata *models.FileMetadata) (maxSize int64, upload policy.Upload, byPolicy bool) {
+	maxSize = max(h.deltaMaxBytes, 0)
+	if h.policy == nil {
+		return maxSize, upload, false
+	}
+	upload = policy.Upload{
+		ContentType: metadata.ContentType,
+		FileName:    metadata.OriginalName,
+		Tags:        metadata.Tags,
+	}
+	if upload.Tags == nil {
+		upload.Tags = map[string]string{}
+	}
+	if limit, limited := h.policy.SizeLimit(upload); limited && (maxSize == 0 || limit < maxSize) {
+		return limit, upload, true
+	}
+	return maxSize, upload, false
+}
+
+// GetSignature handles GET /api/v1/files/{id}/signature?block_size=N
+func (h *Handler) GetSignature(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileID := h.extractFileID(r.URL.Path)
+	if fileID == "" {
+		h.sendError(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	reader, metadata, err := h.storage.Open(fileID)
+	if err != nil {
+		if h.sendScanError(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), "not found") {
+			h.sendError(w, "File not found", http.StatusNotFound)
+		} else {
+			log.Printf("Failed to open file %s: %v", fileID, err)
+			h.sendError(w, "Failed to open file", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer reader.Close()
+
+	blockSize := delta.DefaultBlockSize(metadata.Size)
+	if value := r.URL.Query().Get("block_size"); value != "" {
+		blockSize, err = strconv.Atoi(value)
+		if err != nil || blockSize < delta.MinBlockSize || blockSize > delta.MaxBlockSize {
+			h.sendError(w, "block_size must be between 512 and 1048576", http.StatusBadRequest)
+			return
+		}
+	}
+
+	sig, err := delta.ComputeSignature(io.LimitReader(reader, metadata.Size), blockSize)
+	if err != nil {
+		log.Printf("Failed to compute signature for %s: %v", fileID, err)
+		h.sendError(w, "Failed to compute signature", http.StatusInternalServerError)
+		return
+	}
+	sig.ETag = metadata.ETag()
+
+	w.Header().Set("ETag", metadata.ETag())
+	h.sendJSON(w, sig, http.StatusOK)
+}
+
+// ApplyDelta handles POST /api/v1/files/{id}/delta. The body is a
+// delta.Delta computed against the signature of the current version; the
+// result replaces the file under the same ID. The signature's ETag must be
+// sent in If-Match so a delta is never applied to a different base.
+func (h *Handler) ApplyDelta(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileID := h.extractFileID(r.URL.Path)
+	if fileID == "" {
+		h.sendError(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		h.sendError(w, "If-Match is required to apply a delta", http.StatusPreconditionRequired)
+		return
+	}
+
+	current, err := h.storage.GetMetadata(fileID)
+	if err != nil {
+		h.sendUpdateError(w, fileID, err)
+		return
+	}
+	maxSize, upload, byPolicy := h.deltaLimit(current)
+	tooLarge := func() {
+		if byPolicy {
+			h.sendPolicyError(w, h.sizeRejection(upload, maxSize+1))
+		} else {
+			h.sendError(w, fmt.Sprintf("The new version would exceed %d bytes", maxSize), http.StatusRequestEntityTooLarge)
+		}
+	}
+
+	// Literals are base64 in the body, so a body that large cannot
+	// produce a version within the limit
+	if maxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize+maxSize/3+deltaOverhead)
+	}
+	var d delta.Delta
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		var bodyTooLarge *http.MaxBytesError
+		if errors.As(err, &bodyTooLarge) {
+			tooLarge()
+		} else {
+			h.sendError(w, "Invalid JSON body", http.StatusBadRequest)
+		}
+		return
+	}
+
+	metadata, err := h.storage.Replace(fileID, ifMatch, func(base io.ReaderAt, baseSize int64, w io.Writer) error {
+		tracked := storage.NewTrackingWriter(w)
+		err := delta.Apply(base, baseSize, &d, tracked, maxSize)
+		if err != nil && tracked.Err() == nil && !errors.Is(err, delta.ErrTooLarge) {
+			return &invalidDeltaError{err}
+		}
+		return err
+	}, h.checkFile)
+	if err != nil {
+		var invalid *invalidDeltaError
+		switch {
+		case errors.As(err, &invalid):
+			h.sendError(w, "Invalid delta: "+invalid.Error(), http.StatusBadRequest)
+		case errors.Is(err, delta.ErrTooLarge):
+			tooLarge()
+		default:
+			h.sendUpdateError(w, fileID, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", metadata.ETag())
+	h.notify(webhook.EventUpdate, metadata)
+	h.syncReplicas(metadata)
+	h.sendJSON(w, h.infoResponse(metadata), http.StatusOK)
+}
@@ -0,0 +1,109 @@
+package files
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dvfs/storage-node/pkg/delta"
+)
+
+func TestApplyDelta(t *testing.T) {
+	h := newTestHandler(t, nil)
+	base := strings.Repeat("0123456789abcdef", 100)
+	file := uploadTestFile(t, h, "data.txt", base, nil)
+
+	rec := httptest.NewRecorder()
+	h.GetSignature(rec, httptest.NewRequest(http.MethodGet, "/api/v1/files/"+file.ID+"/signature?block_size=512", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signature returned %d: %s", rec.Code, rec.Body)
+	}
+	var sig delta.Signature
+	if err := json.NewDecoder(rec.Body).Decode(&sig); err != nil {
+		t.Fatalf("decoding signature: %v", err)
+	}
+
+	next := base[:700] + "changed" + base[700:]
+	d, err := delta.ComputeDelta(&sig, strings.NewReader(next))
+	if err != nil {
+		t.Fatalf("ComputeDelta: %v", err)
+	}
+	body, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apply := func(ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/files/"+file.ID+"/delta", bytes.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.ApplyDelta(rec, req)
+		return rec
+	}
+
+	if rec := apply(""); rec.Code != http.StatusPreconditionRequired {
+		t.Errorf("delta without If-Match returned %d, want 428", rec.Code)
+	}
+	if rec := apply(`"stale"`); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("delta against another version returned %d, want 412", rec.Code)
+	}
+	if rec := apply(sig.ETag); rec.Code != http.StatusOK {
+		t.Fatalf("delta returned %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.GetFile(rec, httptest.NewRequest(http.MethodGet, "/api/v1/files/"+file.ID, nil))
+	if rec.Body.String() != next {
+		t.Errorf("file has %d bytes after the delta, want %d", rec.Body.Len(), len(next))
+	}
+
+	// The same delta must not be applied twice
+	if rec := apply(sig.ETag); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("replayed delta returned %d, want 412", rec.Code)
+	}
+}
+
+func TestApplyDeltaSizeLimit(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.deltaMaxBytes = 4096
+	base := strings.Repeat("0123456789abcdef", 64)
+	file := uploadTestFile(t, h, "data.txt", base, nil)
+	etag := currentETag(t, h, file.ID)
+
+	apply := func(d *delta.Delta) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(d)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/files/"+file.ID+"/delta", bytes.NewReader(body))
+		req.Header.Set("If-Match", etag)
+		rec := httptest.NewRecorder()
+		h.ApplyDelta(rec, req)
+		return rec
+	}
+
+	// Copying the base over and over would write far more than it sends
+	amplified := &delta.Delta{BlockSize: delta.MinBlockSize}
+	for i := 0; i < 100; i++ {
+		amplified.Ops = append(amplified.Ops, delta.Op{Type: delta.OpCopy, Block: 0, Count: 2})
+	}
+	if rec := apply(amplified); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("amplifying delta returned %d, want 413: %s", rec.Code, rec.Body)
+	}
+
+	// A body too large to fit the limit is not read to the end
+	literal := &delta.Delta{BlockSize: delta.MinBlockSize, Ops: []delta.Op{{Type: delta.OpLiteral, Data: bytes.Repeat([]byte("x"), 2<<20)}}}
+	if rec := apply(literal); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized delta body returned %d, want 413: %s", rec.Code, rec.Body)
+	}
+
+	if got := currentETag(t, h, file.ID); got != etag {
+		t.Fatal("a refused delta changed the file")
+	}
+	amplified.Ops = amplified.Ops[:4]
+	if rec := apply(amplified); rec.Code != http.StatusOK {
+		t.Errorf("delta at the limit returned %d: %s", rec.Code, rec.Body)
+	}
+}
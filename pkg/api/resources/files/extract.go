@@ -0,0 +1,133 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+
+	"github.com/dvfs/storage-node/pkg/archive"
+	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/policy"
+	"github.com/dvfs/storage-node/pkg/replication"
+	"github.com/dvfs/storage-node/pkg/storage"
+	"github.com/dvfs/storage-node/pkg/utils"
+	"github.com/dvfs/storage-node/pkg/webhook"
+)
+
+// storeError marks extraction failures caused by storage rather than by
+// the archive itself
+type storeError struct {
+	path string
+	err  error
+}
+
+func (e *storeError) Error() string {
+	return e.err.Error()
+}
+
+// extractUpload unpacks an uploaded archive into one stored file per entry,
+// each replicated to copies instances. Either every entry is stored or, on
+// any failure, none are kept anywhere. Entries are scanned before they are
+// stored when the archive itself must be. digests are those of the
+// archive, echoed in the response.
+func (h *Handler) extractUpload(w http.ResponseWriter, format string, content []byte, metadata, tags map[string]string, requireScan bool, copies int, digests map[string]string) {
+	format, err := archive.ParseFormat(format)
+	if err != nil {
+		h.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var files []*models.ExtractedFile
+	var stored []*models.FileMetadata
+	var replicas []*replication.Replication
+	err = archive.Extract(format, content, h.extractLimits, func(entry archive.Entry, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		contentType := utils.GetContentTypeFromExtension(entry.Path)
+		entryScan, rejection := h.checkPolicy(policy.Upload{
+			ContentType:  contentType,
+			DetectedType: utils.DetectContentType(data),
+			FileName:     entry.Path,
+			Size:         int64(len(data)),
+			Tags:         tags,
+		})
+		if rejection != nil {
+			rejection.path = entry.Path
+			return rejection
+		}
+
+		file, err := h.storage.Store(&models.FileUploadRequest{
+			Content:     data,
+			ContentType: contentType,
+			FileName:    path.Base(entry.Path),
+			Metadata:    metadata,
+			Tags:        tags,
+			RequireScan: requireScan || entryScan,
+			Copies:      copies,
+		})
+		if err != nil {
+			return &storeError{path: entry.Path, err: err}
+		}
+		stored = append(stored, file)
+
+		rep, err := h.replicate(file, data)
+		if err != nil {
+			return &storeError{path: entry.Path, err: err}
+		}
+		replicas = append(replicas, rep)
+
+		response := h.uploadResponse(file)
+		response.Replicas = append([]string{h.instanceID}, rep.Instances...)
+		files = append(files, &models.ExtractedFile{
+			Path:               entry.Path,
+			FileUploadResponse: response,
+		})
+		return nil
+	})
+	if err != nil {
+		for _, rep := range replicas {
+			rep.Undo()
+		}
+		for _, file := range stored {
+			if err := h.storage.Delete(file.ID, ""); err != nil {
+				log.Printf("Failed to roll back extracted file %s: %v", file.ID, err)
+			}
+		}
+
+		var storeErr *storeError
+		var rejection *policyRejection
+		switch {
+		case errors.As(err, &rejection):
+			h.sendPolicyError(w, rejection)
+		case errors.As(err, &storeErr) && errors.Is(storeErr.err, storage.ErrContentTypeMismatch):
+			h.sendError(w, storeErr.path+": "+storeErr.err.Error(), http.StatusUnsupportedMediaType)
+		case errors.As(err, &storeErr) && errors.Is(storeErr.err, storage.ErrInfected):
+			h.sendError(w, storeErr.path+": "+storeErr.err.Error(), http.StatusUnprocessableEntity)
+		case errors.As(err, &storeErr) && errors.Is(storeErr.err, replication.ErrQuorumNotReached):
+			h.sendError(w, storeErr.path+": "+storeErr.err.Error(), http.StatusServiceUnavailable)
+		case errors.As(err, &storeErr) && h.sendScanError(w, storeErr.err):
+		case errors.As(err, &storeErr):
+			log.Printf("Failed to store extracted file: %v", storeErr.err)
+			h.sendError(w, "Failed to store file", http.StatusInternalServerError)
+		case errors.Is(err, archive.ErrLimitExceeded):
+			h.sendError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		default:
+			h.sendError(w, fmt.Sprintf("Failed to extract archive: %v", err), http.StatusBadRequest)
+		}
+		return
+	}
+
+	for _, metadata := range stored {
+		h.notify(webhook.EventUpload, metadata)
+	}
+	if files == nil {
+		files = []*models.ExtractedFile{}
+	}
+	h.sendJSON(w, &models.ExtractResponse{Files: files, Count: len(files), Digests: digests}, http.StatusCreated)
+}
@@ -0,0 +1,86 @@
+package files
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// zipArchive builds a zip archive of the given name and content pairs, in
+// order
+func zipArchive(t *testing.T, files ...string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i+1 < len(files); i += 2 {
+		f, err := zw.Create(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(files[i+1]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// extract uploads an archive for extraction and returns the response
+func extract(h *Handler, archive string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/files?extract=zip", strings.NewReader(archive))
+	req.Header.Set("Content-Type", "application/zip")
+	rec := httptest.NewRecorder()
+	h.UploadFile(rec, req)
+	return rec
+}
+
+func TestFailedExtractionRemovesStoredFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive func(*testing.T) string
+		limit   int
+		status  int
+	}{
+		{"unsafe path", func(t *testing.T) string {
+			return zipArchive(t, "a.txt", "alpha", "b.txt", "beta", "../evil.txt", "evil")
+		}, 0, http.StatusBadRequest},
+		{"too many entries", func(t *testing.T) string {
+			return zipArchive(t, "a.txt", "alpha", "b.txt", "beta", "c.txt", "gamma")
+		}, 2, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, nil)
+			h.extractLimits.MaxEntries = tt.limit
+
+			rec := extract(h, tt.archive(t))
+			if rec.Code != tt.status {
+				t.Fatalf("extraction returned %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			stored, err := h.storage.List(nil)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if len(stored) != 0 {
+				t.Errorf("%d files kept after a failed extraction", len(stored))
+			}
+		})
+	}
+}
+
+func TestExtractionListsPaths(t *testing.T) {
+	h := newTestHandler(t, nil)
+	rec := extract(h, zipArchive(t, "docs/a.txt", "alpha", "/abs/b.txt", "beta"))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("extraction returned %d: %s", rec.Code, rec.Body)
+	}
+	body := rec.Body.String()
+	for _, path := range []string{`"docs/a.txt"`, `"abs/b.txt"`} {
+		if !strings.Contains(body, path) {
+			t.Errorf("response %s does not list %s", body, path)
+		}
+	}
+}
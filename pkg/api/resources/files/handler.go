@@ -2,6 +2,7 @@ package files
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -9,21 +10,56 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/dvfs/storage-node/pkg/archive"
+	"github.com/dvfs/storage-node/pkg/config"
+	"github.com/dvfs/storage-node/pkg/idempotency"
+	"github.com/dvfs/storage-node/pkg/integrity"
 	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/policy"
+	"github.com/dvfs/storage-node/pkg/replication"
 	"github.com/dvfs/storage-node/pkg/storage"
 	"github.com/dvfs/storage-node/pkg/utils"
+	"github.com/dvfs/storage-node/pkg/webhook"
 )
 
 // Handler handles file-related HTTP requests
 type Handler struct {
-	storage *storage.FileStorage
+	storage       *storage.FileStorage
+	extractLimits archive.Limits
+	maxPixels     int64
+	deltaMaxBytes int64
+	policy        *policy.Engine
+	idempotency   *idempotency.Store
+	webhooks      *webhook.Dispatcher
+	replicator    *replication.Replicator
+	instanceID    string
+	copies        int
+	quorum        int
 }
 
 // NewHandler creates a new files handler
-func NewHandler(storage *storage.FileStorage) *Handler {
-	return &Handler{
+func NewHandler(storage *storage.FileStorage, uploadPolicy *policy.Engine, idempotencyKeys *idempotency.Store, webhooks *webhook.Dispatcher, replicator *replication.Replicator, cfg *config.Config) *Handler {
+	h := &Handler{
 		storage: storage,
+		extractLimits: archive.Limits{
+			MaxEntries: cfg.ExtractMaxEntries,
+			MaxBytes:   cfg.ExtractMaxBytes,
+			MaxRatio:   cfg.ExtractMaxRatio,
+		},
+		maxPixels:     cfg.ThumbnailMaxPixels,
+		deltaMaxBytes: cfg.DeltaMaxBytes,
+		policy:        uploadPolicy,
+		idempotency:   idempotencyKeys,
+		webhooks:      webhooks,
+		replicator:    replicator,
+		instanceID:    cfg.InstanceID,
+		copies:        cfg.ReplicationFactor,
+		quorum:        cfg.WriteQuorum,
 	}
+	storage.OnStagedExpired(func(metadata *models.FileMetadata) {
+		h.notify(webhook.EventExpire, metadata)
+	})
+	return h
 }
 
 // UploadFile handles POST /api/v1/files
@@ -33,13 +69,55 @@ func (h *Handler) UploadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.upload(w, r, "")
+}
+
+// PutFile handles PUT /api/v1/files/{id}, uploading a file under an ID
+// chosen by the client
+func (h *Handler) PutFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileID := h.extractFileID(r.URL.Path)
+	if !storage.ValidID(fileID) {
+		h.sendError(w, storage.ErrInvalidID.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.upload(w, r, fileID)
+}
+
+// upload stores the file in the request body, under fileID if it is set
+func (h *Handler) upload(w http.ResponseWriter, r *http.Request, fileID string) {
+	// Digests sent by the client are checked against the file content as
+	// it is read, before anything is stored
+	expected, err := integrity.ParseHeaders(r.Header)
+	if err != nil {
+		h.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	digests := integrity.NewHasher()
+
+	// Bodies larger than the upload policy allows are not read in full
+	known, ok := h.limitBody(w, r)
+	if !ok {
+		return
+	}
+	var tooLarge *http.MaxBytesError
+
 	// Parse multipart form if present
 	var content []byte
-	var originalName, contentType string
+	var originalName, contentType, tags string
 
 	if strings.Contains(r.Header.Get("Content-Type"), "multipart/form-data") {
 		// Handle multipart form data
 		err := r.ParseMultipartForm(32 << 20) // 32 MB max
+		if errors.As(err, &tooLarge) {
+			h.sendPolicyError(w, h.sizeRejection(known, tooLarge.Limit+1))
+			return
+		}
 		if err != nil {
 			h.sendError(w, "Failed to parse multipart form", http.StatusBadRequest)
 			return
@@ -52,7 +130,7 @@ func (h *Handler) UploadFile(w http.ResponseWriter, r *http.Request) {
 		}
 		defer file.Close()
 
-		content, err = io.ReadAll(file)
+		content, err = io.ReadAll(io.TeeReader(file, digests))
 		if err != nil {
 			h.sendError(w, "Failed to read file content", http.StatusInternalServerError)
 			return
@@ -60,10 +138,14 @@ func (h *Handler) UploadFile(w http.ResponseWriter, r *http.Request) {
 
 		originalName = header.Filename
 		contentType = header.Header.Get("Content-Type")
+		tags = r.FormValue("tags")
 	} else {
 		// Handle raw file content
-		var err error
-		content, err = io.ReadAll(r.Body)
+		content, err = io.ReadAll(io.TeeReader(r.Body, digests))
+		if errors.As(err, &tooLarge) {
+			h.sendPolicyError(w, h.sizeRejection(known, tooLarge.Limit+1))
+			return
+		}
 		if err != nil {
 			h.sendError(w, "Failed to read request body", http.StatusInternalServerError)
 			return
@@ -80,29 +162,149 @@ func (h *Handler) UploadFile(w http.ResponseWriter, r *http.Request) {
 		if contentType == "" {
 			contentType = utils.GetContentTypeFromExtension(originalName)
 		}
+
+		tags = r.Header.Get("X-Tags")
+	}
+
+	if err := digests.Verify(expected); err != nil {
+		h.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userMetadata, err := parseUserMetadata(r.Header)
+	if err != nil {
+		h.sendError(w, "Invalid metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	tagMap, err := parseTags(tags)
+	if err != nil {
+		h.sendError(w, "Invalid tags: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	appendable, err := parseFlag(r, "appendable", "X-Appendable")
+	if err != nil {
+		h.sendError(w, "Invalid appendable flag", http.StatusBadRequest)
+		return
+	}
+	stage, err := parseFlag(r, "stage", "")
+	if err != nil {
+		h.sendError(w, "Invalid stage flag", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("extract")
+	if format != "" && fileID != "" {
+		h.sendError(w, "Extracted files cannot be given an ID", http.StatusBadRequest)
+		return
+	}
+	if format != "" && stage {
+		h.sendError(w, "Extracted files cannot be staged", http.StatusBadRequest)
+		return
+	}
+
+	// Staged uploads are replicated when they are committed, extracted
+	// files one by one
+	copies, err := h.replicationFactor(r)
+	if err != nil {
+		h.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// A retry carrying the same Idempotency-Key gets the first response
+	fingerprint := uploadFingerprint(r, fileID, content, originalName, contentType, userMetadata, tagMap, appendable, stage, copies)
+	w, finish, handled := h.idempotent(w, r, fingerprint)
+	if handled {
+		return
+	}
+	defer finish()
+
+	// Check the upload policy before anything is written
+	requireScan, rejection := h.checkPolicy(policy.Upload{
+		ContentType:  contentType,
+		DetectedType: utils.DetectContentType(content),
+		FileName:     originalName,
+		Size:         int64(len(content)),
+		Tags:         tagMap,
+	})
+	if rejection != nil {
+		h.sendPolicyError(w, rejection)
+		return
+	}
+
+	// Unpack archives into individual files when asked to
+	if format != "" {
+		if appendable {
+			h.sendError(w, "Extracted files cannot be appendable", http.StatusBadRequest)
+			return
+		}
+		h.extractUpload(w, format, content, userMetadata, tagMap, requireScan, copies, digests.Sums())
+		return
+	}
+
+	req := &models.FileUploadRequest{
+		ID:          fileID,
+		Content:     content,
+		ContentType: contentType,
+		FileName:    originalName,
+		Metadata:    userMetadata,
+		Tags:        tagMap,
+		Appendable:  appendable,
+		RequireScan: requireScan,
+		Copies:      copies,
+	}
+
+	// Staged files stay invisible until they are committed
+	if stage {
+		staged, err := h.storage.Stage(req)
+		if err != nil {
+			h.sendStoreError(w, err)
+			return
+		}
+		response := h.uploadResponse(staged.Metadata)
+		response.Digests = digests.Sums()
+		response.StagingToken = staged.Token
+		response.StagingExpiresAt = &staged.ExpiresAt
+		h.sendJSON(w, response, http.StatusAccepted)
+		return
 	}
 
 	// Store the file
-	metadata, err := h.storage.Store(content, originalName, contentType)
+	metadata, err := h.storage.Store(req)
 	if err != nil {
-		log.Printf("Failed to store file: %v", err)
-		h.sendError(w, "Failed to store file", http.StatusInternalServerError)
+		h.sendStoreError(w, err)
 		return
 	}
 
-	// Create response
-	response := &models.FileUploadResponse{
-		ID:           metadata.ID,
-		OriginalName: metadata.OriginalName,
-		ContentType:  metadata.ContentType,
-		Size:         metadata.Size,
-		Extension:    metadata.Extension,
-		URL:          fmt.Sprintf("/api/v1/files/%s", metadata.ID),
+	// Copy the file to peers; without a quorum the upload is undone
+	replicas, ok := h.replicateNew(w, metadata, content)
+	if !ok {
+		return
 	}
 
+	h.notify(webhook.EventUpload, metadata)
+	response := h.uploadResponse(metadata)
+	response.Digests = digests.Sums()
+	response.Replicas = replicas
 	h.sendJSON(w, response, http.StatusCreated)
 }
 
+// sendStoreError writes the response for a failed upload
+func (h *Handler) sendStoreError(w http.ResponseWriter, err error) {
+	if h.sendScanError(w, err) {
+		return
+	}
+	if errors.Is(err, storage.ErrContentTypeMismatch) {
+		h.sendError(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if errors.Is(err, storage.ErrFileExists) {
+		h.sendError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	log.Printf("Failed to store file: %v", err)
+	h.sendError(w, "Failed to store file", http.StatusInternalServerError)
+}
+
 // GetFile handles GET /api/v1/files/{id}
 func (h *Handler) GetFile(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -116,9 +318,23 @@ func (h *Handler) GetFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// ?download=1 asks browsers to save the file rather than display it
+	download, err := parseFlag(r, "download", "")
+	if err != nil {
+		h.sendError(w, "Invalid download flag", http.StatusBadRequest)
+		return
+	}
+	disposition := "inline"
+	if download {
+		disposition = "attachment"
+	}
+
 	// Get file content and metadata
 	content, metadata, err := h.storage.Retrieve(fileID)
 	if err != nil {
+		if h.sendScanError(w, err) {
+			return
+		}
 		if strings.Contains(err.Error(), "not found") {
 			h.sendError(w, "File not found", http.StatusNotFound)
 		} else {
@@ -131,9 +347,13 @@ func (h *Handler) GetFile(w http.ResponseWriter, r *http.Request) {
 	// Set appropriate headers
 	w.Header().Set("Content-Type", metadata.ContentType)
 	w.Header().Set("Content-Length", strconv.FormatInt(metadata.Size, 10))
-	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", metadata.OriginalName))
+	w.Header().Set("Content-Disposition", utils.ContentDisposition(disposition, metadata.OriginalName))
 	w.Header().Set("X-File-ID", metadata.ID)
 	w.Header().Set("X-Original-Name", metadata.OriginalName)
+	w.Header().Set("ETag", metadata.ETag())
+	if metadata.CacheControl != "" {
+		w.Header().Set("Cache-Control", metadata.CacheControl)
+	}
 
 	// Write file content
 	w.WriteHeader(http.StatusOK)
@@ -165,19 +385,8 @@ func (h *Handler) GetFileInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create response
-	response := &models.FileInfoResponse{
-		ID:           metadata.ID,
-		OriginalName: metadata.OriginalName,
-		ContentType:  metadata.ContentType,
-		Size:         metadata.Size,
-		Extension:    metadata.Extension,
-		CreatedAt:    metadata.CreatedAt,
-		UpdatedAt:    metadata.UpdatedAt,
-		URL:          fmt.Sprintf("/api/v1/files/%s", metadata.ID),
-	}
-
-	h.sendJSON(w, response, http.StatusOK)
+	w.Header().Set("ETag", metadata.ETag())
+	h.sendJSON(w, h.infoResponse(metadata), http.StatusOK)
 }
 
 // DeleteFile handles DELETE /api/v1/files/{id}
@@ -193,10 +402,15 @@ func (h *Handler) DeleteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete the file
-	err := h.storage.Delete(fileID)
+	// Keep the file's information for the webhook sent once it is gone
+	metadata, _ := h.storage.GetMetadata(fileID)
+
+	// Delete the file, honouring an optional If-Match precondition
+	err := h.storage.Delete(fileID, r.Header.Get("If-Match"))
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			h.sendError(w, "File has been modified", http.StatusPreconditionFailed)
+		} else if strings.Contains(err.Error(), "not found") {
 			h.sendError(w, "File not found", http.StatusNotFound)
 		} else {
 			log.Printf("Failed to delete file %s: %v", fileID, err)
@@ -205,6 +419,9 @@ func (h *Handler) DeleteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if metadata != nil {
+		h.notify(webhook.EventDelete, metadata)
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -223,12 +440,62 @@ func (h *Handler) CheckFileExists(w http.ResponseWriter, r *http.Request) {
 
 	// Check if file exists
 	if h.storage.Exists(fileID) {
+		if metadata, err := h.storage.GetMetadata(fileID); err == nil {
+			w.Header().Set("ETag", metadata.ETag())
+		}
 		w.WriteHeader(http.StatusOK)
 	} else {
 		w.WriteHeader(http.StatusNotFound)
 	}
 }
 
+// uploadResponse builds the response for a newly created file
+func (h *Handler) uploadResponse(metadata *models.FileMetadata) *models.FileUploadResponse {
+	return &models.FileUploadResponse{
+		ID:           metadata.ID,
+		OriginalName: metadata.OriginalName,
+		ContentType:  metadata.ContentType,
+		Size:         metadata.Size,
+		Extension:    metadata.Extension,
+		URL:          fmt.Sprintf("/api/v1/files/%s", metadata.ID),
+		Checksum:     metadata.Checksum,
+		Appendable:   metadata.Appendable,
+		ScanStatus:   metadata.ScanStatus,
+	}
+}
+
+// infoResponse builds the public view of a file's metadata
+func (h *Handler) infoResponse(metadata *models.FileMetadata) *models.FileInfoResponse {
+	return &models.FileInfoResponse{
+		ID:           metadata.ID,
+		OriginalName: metadata.OriginalName,
+		ContentType:  metadata.ContentType,
+		Size:         metadata.Size,
+		Extension:    metadata.Extension,
+		CreatedAt:    metadata.CreatedAt,
+		UpdatedAt:    metadata.UpdatedAt,
+		URL:          fmt.Sprintf("/api/v1/files/%s", metadata.ID),
+
+		Tier:           metadata.Tier,
+		LastAccessedAt: metadata.LastAccessedAt,
+
+		Metadata:     metadata.UserMetadata,
+		Tags:         metadata.Tags,
+		CacheControl: metadata.CacheControl,
+
+		Checksum:   metadata.Checksum,
+		Appendable: metadata.Appendable,
+		Sealed:     metadata.Sealed,
+
+		DetectedType: metadata.DetectedType,
+		TypeMismatch: metadata.TypeMismatch,
+		Properties:   metadata.Properties,
+
+		ScanStatus:    metadata.ScanStatus,
+		ScanSignature: metadata.ScanSignature,
+	}
+}
+
 // extractFileID extracts the file ID from the URL path
 func (h *Handler) extractFileID(path string) string {
 	// Expected format: /api/v1/files/{id}
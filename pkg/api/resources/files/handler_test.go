@@ -0,0 +1,186 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/config"
+	"github.com/dvfs/storage-node/pkg/idempotency"
+	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/replication"
+	"github.com/dvfs/storage-node/pkg/storage"
+	"github.com/dvfs/storage-node/pkg/webhook"
+)
+
+// newTestHandler creates a handler over a storage in a temporary directory,
+// with cfg adjusted by configure if it is not nil
+func newTestHandler(t *testing.T, configure func(*config.Config)) *Handler {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &config.Config{
+		StoragePath:        dir,
+		StoragePaths:       []string{dir},
+		PackVolumeSize:     1 << 20,
+		InstanceID:         "test",
+		ReplicationFactor:  1,
+		ThumbnailMaxPixels: 50_000_000,
+		WebhookTimeout:     time.Second,
+		WebhookMaxAttempts: 1,
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	fs, err := storage.NewFileStorage(cfg)
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+
+	keys, err := idempotency.NewStore(filepath.Join(dir, "idempotency"), 0)
+	if err != nil {
+		t.Fatalf("idempotency.NewStore: %v", err)
+	}
+	dispatcher, err := webhook.NewDispatcher(filepath.Join(dir, "webhooks"), "", cfg.WebhookTimeout, cfg.WebhookMaxAttempts)
+	if err != nil {
+		t.Fatalf("NewDispatcher: %v", err)
+	}
+	t.Cleanup(dispatcher.Close)
+
+	replicator := replication.NewReplicator(cfg.Peers, cfg.ReplicationSecret, time.Second)
+	t.Cleanup(replicator.Close)
+
+	return NewHandler(fs, nil, keys, dispatcher, replicator, cfg)
+}
+
+// uploadTestFile uploads content as a raw body and returns its metadata
+func uploadTestFile(t *testing.T, h *Handler, name, content string, headers map[string]string) *models.FileUploadResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/files", strings.NewReader(content))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Filename", name)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	h.UploadFile(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload returned %d: %s", rec.Code, rec.Body)
+	}
+
+	var resp models.FileUploadResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding upload response: %v", err)
+	}
+	return &resp
+}
+
+// currentETag returns the ETag the handler reports for a file
+func currentETag(t *testing.T, h *Handler, fileID string) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.GetFileInfo(rec, httptest.NewRequest(http.MethodGet, "/api/v1/files/"+fileID+"/info", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("info returned %d: %s", rec.Code, rec.Body)
+	}
+	return rec.Header().Get("ETag")
+}
+
+func TestIfMatchPreconditions(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		serve  func(*Handler, http.ResponseWriter, *http.Request)
+		status int
+	}{
+		{"update", http.MethodPatch, "", `{"original_name":"renamed.txt"}`, (*Handler).UpdateFile, http.StatusOK},
+		{"metadata", http.MethodPatch, "/metadata", `{"metadata":{"k":"v"}}`, (*Handler).UpdateFileMetadata, http.StatusOK},
+		{"append", http.MethodPost, "/append", "more", (*Handler).AppendFile, http.StatusOK},
+		{"delete", http.MethodDelete, "", "", (*Handler).DeleteFile, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, nil)
+			file := uploadTestFile(t, h, "data.txt", "content", map[string]string{"X-Appendable": "true"})
+			etag := currentETag(t, h, file.ID)
+			if etag == "" {
+				t.Fatal("no ETag reported")
+			}
+
+			request := func(ifMatch string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(tt.method, "/api/v1/files/"+file.ID+tt.path, strings.NewReader(tt.body))
+				req.Header.Set("If-Match", ifMatch)
+				rec := httptest.NewRecorder()
+				tt.serve(h, rec, req)
+				return rec
+			}
+
+			if rec := request(`"stale"`); rec.Code != http.StatusPreconditionFailed {
+				t.Fatalf("stale If-Match returned %d, want 412: %s", rec.Code, rec.Body)
+			}
+			if got := currentETag(t, h, file.ID); got != etag {
+				t.Fatalf("failed precondition changed the ETag from %s to %s", etag, got)
+			}
+
+			if rec := request(etag); rec.Code != tt.status {
+				t.Fatalf("current If-Match returned %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.method != http.MethodDelete {
+				// The tag that just matched is now stale
+				if rec := request(etag); rec.Code != http.StatusPreconditionFailed {
+					t.Errorf("replayed If-Match returned %d, want 412", rec.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadDigests(t *testing.T) {
+	h := newTestHandler(t, nil)
+	sum := sha256.Sum256([]byte("content"))
+	digest := base64.StdEncoding.EncodeToString(sum[:])
+
+	upload := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/files", strings.NewReader("content"))
+		req.Header.Set("Content-Type", "text/plain")
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		h.UploadFile(rec, req)
+		return rec
+	}
+
+	for name, headers := range map[string]map[string]string{
+		"malformed":   {"Repr-Digest": "sha-256=" + digest},
+		"conflicting": {"Digest": "sha-256=" + digest, "Repr-Digest": "sha-256=:" + base64.StdEncoding.EncodeToString(make([]byte, 32)) + ":"},
+		"mismatch":    {"X-Checksum-CRC32C": "00000000"},
+	} {
+		if rec := upload(headers); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s digest returned %d, want 400: %s", name, rec.Code, rec.Body)
+		}
+	}
+	if stored, err := h.storage.List(nil); err != nil || len(stored) != 0 {
+		t.Fatalf("rejected uploads stored %d files (%v)", len(stored), err)
+	}
+
+	rec := upload(map[string]string{"Repr-Digest": "SHA-256=:" + digest + ":"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload with a matching digest returned %d: %s", rec.Code, rec.Body)
+	}
+	var resp models.FileUploadResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Digests["sha-256"] != digest || len(resp.Digests) != 4 {
+		t.Errorf("upload echoed digests %v, want all four with sha-256 %s", resp.Digests, digest)
+	}
+}
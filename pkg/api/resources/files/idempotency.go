@@ -0,0 +1,120 @@
+package files
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/idempotency"
+)
+
+// uploadFingerprint identifies an upload so a reused Idempotency-Key can be
+// told apart from a genuine retry. Multipart boundaries and header order
+// may differ between attempts, so the parsed request is hashed rather than
+// the raw body.
+func uploadFingerprint(r *http.Request, fileID string, content []byte, name, contentType string, metadata, tags map[string]string, appendable, stage bool, copies int) string {
+	sum := sha256.Sum256(content)
+	fields, _ := json.Marshal(map[string]interface{}{
+		"method":       r.Method,
+		"id":           fileID,
+		"extract":      r.URL.Query().Get("extract"),
+		"content":      hex.EncodeToString(sum[:]),
+		"name":         name,
+		"content_type": contentType,
+		"metadata":     metadata,
+		"tags":         tags,
+		"appendable":   appendable,
+		"stage":        stage,
+		"copies":       copies,
+	})
+	fingerprint := sha256.Sum256(fields)
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// idempotent applies the Idempotency-Key of r, if any. When it returns true
+// the response has already been written: either replayed from the first
+// attempt or an error. Otherwise the caller writes its response to the
+// returned writer and must call finish, which remembers a successful
+// response for later retries.
+func (h *Handler) idempotent(w http.ResponseWriter, r *http.Request, fingerprint string) (http.ResponseWriter, func(), bool) {
+	key := r.Header.Get(idempotency.Header)
+	if key == "" || !h.idempotency.Enabled() {
+		return w, func() {}, false
+	}
+	if err := idempotency.ValidateKey(key); err != nil {
+		h.sendError(w, err.Error(), http.StatusBadRequest)
+		return w, nil, true
+	}
+
+	// Held until finish; a retry arriving meanwhile is told to come back
+	// rather than kept waiting through the whole upload
+	unlock, ok := h.idempotency.Begin(key)
+	if !ok {
+		h.sendError(w, "A request with this Idempotency-Key is in progress", http.StatusConflict)
+		return w, nil, true
+	}
+
+	record, err := h.idempotency.Get(key)
+	if err != nil {
+		unlock()
+		log.Printf("Failed to read idempotency record: %v", err)
+		h.sendError(w, "Failed to check Idempotency-Key", http.StatusInternalServerError)
+		return w, nil, true
+	}
+	if record != nil {
+		unlock()
+		if record.Fingerprint != fingerprint {
+			h.sendError(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+			return w, nil, true
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(record.StatusCode)
+		w.Write(record.Body)
+		return w, nil, true
+	}
+
+	recorder := &responseRecorder{ResponseWriter: w}
+	finish := func() {
+		defer unlock()
+		if recorder.status < 200 || recorder.status > 299 {
+			return
+		}
+		err := h.idempotency.Put(key, &idempotency.Record{
+			Fingerprint: fingerprint,
+			StatusCode:  recorder.status,
+			Body:        bytes.TrimSpace(recorder.body.Bytes()),
+			CreatedAt:   time.Now(),
+		})
+		if err != nil {
+			log.Printf("Failed to save idempotency record: %v", err)
+		}
+	}
+	return recorder, finish, false
+}
+
+// responseRecorder passes a response through while keeping a copy
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(p []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(p)
+	return rr.ResponseWriter.Write(p)
+}
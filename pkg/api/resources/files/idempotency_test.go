@@ -0,0 +1,97 @@
+package files
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/idempotency"
+)
+
+// newIdempotentHandler creates a test handler remembering responses to
+// keyed uploads
+func newIdempotentHandler(t *testing.T) *Handler {
+	t.Helper()
+	h := newTestHandler(t, nil)
+	keys, err := idempotency.NewStore(filepath.Join(t.TempDir(), "idempotency"), time.Hour)
+	if err != nil {
+		t.Fatalf("idempotency.NewStore: %v", err)
+	}
+	t.Cleanup(keys.Close)
+	h.idempotency = keys
+	return h
+}
+
+// keyedPut uploads content under fileID with an Idempotency-Key
+func keyedPut(h *Handler, fileID, key, content string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/files/"+fileID, strings.NewReader(content))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Filename", "notes.txt")
+	req.Header.Set(idempotency.Header, key)
+	rec := httptest.NewRecorder()
+	h.PutFile(rec, req)
+	return rec
+}
+
+func TestIdempotentUpload(t *testing.T) {
+	h := newIdempotentHandler(t)
+	const fileID = "9d3b2f61-0c4e-4a7b-8e15-3f6a2c9d0b47"
+
+	first := keyedPut(h, fileID, "key-1", "content")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first upload returned %d: %s", first.Code, first.Body)
+	}
+
+	// A retry gets the first response rather than a conflict with the
+	// file it created
+	retry := keyedPut(h, fileID, "key-1", "content")
+	if retry.Code != http.StatusCreated || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry returned %d replayed %q, want the replayed 201", retry.Code, retry.Header().Get("Idempotent-Replayed"))
+	}
+	if retry.Body.String() != strings.TrimSpace(first.Body.String()) {
+		t.Errorf("retry body = %s, want %s", retry.Body, first.Body)
+	}
+
+	// The same key for another upload is refused
+	if rec := keyedPut(h, fileID, "key-1", "other content"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key returned %d, want 422: %s", rec.Code, rec.Body)
+	}
+
+	// A new key for the same ID collides with the stored file, and the
+	// failure is not remembered
+	if rec := keyedPut(h, fileID, "key-2", "content"); rec.Code != http.StatusConflict {
+		t.Errorf("upload over an existing ID returned %d, want 409: %s", rec.Code, rec.Body)
+	}
+	if record, err := h.idempotency.Get("key-2"); err != nil || record != nil {
+		t.Errorf("failed upload left record %+v, %v", record, err)
+	}
+}
+
+func TestIdempotentUploadInProgress(t *testing.T) {
+	h := newIdempotentHandler(t)
+	const fileID = "2e8c4a10-7b5d-4f39-a6c2-1d0e9b3f5a84"
+
+	// Another attempt with the key is still running
+	release, ok := h.idempotency.Begin("key")
+	if !ok {
+		t.Fatal("Begin refused an unused key")
+	}
+	if rec := keyedPut(h, fileID, "key", "content"); rec.Code != http.StatusConflict {
+		t.Errorf("concurrent retry returned %d, want 409: %s", rec.Code, rec.Body)
+	}
+	if h.storage.Exists(fileID) {
+		t.Error("concurrent retry stored the file")
+	}
+	// Other keys are not held up meanwhile
+	if rec := keyedPut(h, "6b1f0d2e-4c8a-4e73-9a5b-0f2d7c3e1a96", "other", "content"); rec.Code != http.StatusCreated {
+		t.Errorf("upload with another key returned %d: %s", rec.Code, rec.Body)
+	}
+
+	release()
+	if rec := keyedPut(h, fileID, "key", "content"); rec.Code != http.StatusCreated {
+		t.Errorf("retry after the first attempt returned %d: %s", rec.Code, rec.Body)
+	}
+}
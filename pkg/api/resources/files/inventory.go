@@ -0,0 +1,113 @@
+package files
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/storage"
+	"github.com/dvfs/storage-node/pkg/webhook"
+)
+
+// inventoryCursorHeader carries the since value for the next incremental
+// inventory pull
+const inventoryCursorHeader = "X-Inventory-Cursor"
+
+// Inventory handles GET /api/v1/inventory, streaming the information of
+// every file as newline-delimited JSON. ?since= limits the stream to files
+// changed since an earlier cursor.
+func (h *Handler) Inventory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var since time.Time
+	if value := r.URL.Query().Get("since"); value != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			h.sendError(w, "Invalid since cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Large inventories take longer than the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	// Headers go out with the first file, so a listing that fails before
+	// then still gets an error response
+	cursor := storage.InventoryCursor()
+	var encoder *json.Encoder
+	start := func() {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set(inventoryCursorHeader, cursor.UTC().Format(time.RFC3339Nano))
+		w.WriteHeader(http.StatusOK)
+		encoder = json.NewEncoder(w)
+	}
+
+	err := h.storage.Inventory(since, func(metadata *models.FileMetadata) error {
+		if encoder == nil {
+			start()
+		}
+		return encoder.Encode(h.infoResponse(metadata))
+	})
+	if err != nil {
+		if encoder == nil {
+			log.Printf("Failed to list inventory: %v", err)
+			h.sendError(w, "Failed to list inventory", http.StatusInternalServerError)
+		} else {
+			log.Printf("Inventory stream aborted: %v", err)
+		}
+		return
+	}
+	if encoder == nil {
+		start()
+	}
+}
+
+// Reconcile handles POST /api/v1/reconcile, comparing the files a client
+// believes are live with those stored on this node
+func (h *Handler) Reconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.ReconcileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	// A missing list would make every file an extra, and one without a
+	// snapshot time would take uploads racing with it for extras too
+	if req.DeleteExtras && req.IDs == nil {
+		h.sendError(w, "ids is required to delete extras", http.StatusBadRequest)
+		return
+	}
+	if req.DeleteExtras && req.CreatedBefore == nil {
+		h.sendError(w, "created_before is required to delete extras", http.StatusBadRequest)
+		return
+	}
+
+	var createdBefore time.Time
+	if req.CreatedBefore != nil {
+		createdBefore = *req.CreatedBefore
+	}
+
+	report, deleted, err := h.storage.Reconcile(req.IDs, createdBefore, req.DeleteExtras)
+	if err != nil {
+		log.Printf("Failed to reconcile: %v", err)
+		h.sendError(w, "Failed to reconcile", http.StatusInternalServerError)
+		return
+	}
+
+	for _, metadata := range deleted {
+		h.notify(webhook.EventDelete, metadata)
+	}
+
+	h.sendJSON(w, report, http.StatusOK)
+}
@@ -0,0 +1,88 @@
+package files
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/storage"
+)
+
+// reconcile sends a reconcile request with the given body
+func reconcile(h *Handler, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.Reconcile(rec, httptest.NewRequest(http.MethodPost, "/api/v1/reconcile", strings.NewReader(body)))
+	return rec
+}
+
+func TestReconcileDeletionNeedsListAndSnapshot(t *testing.T) {
+	h := newTestHandler(t, nil)
+	file := uploadTestFile(t, h, "a.txt", "content", nil)
+	snapshot := time.Now().Add(time.Minute).UTC().Format(time.RFC3339Nano)
+
+	for name, body := range map[string]string{
+		"missing ids":            `{"created_before":"` + snapshot + `","delete_extras":true}`,
+		"null ids":               `{"ids":null,"created_before":"` + snapshot + `","delete_extras":true}`,
+		"missing created_before": `{"ids":[],"delete_extras":true}`,
+	} {
+		if rec := reconcile(h, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s returned %d, want 400: %s", name, rec.Code, rec.Body)
+		}
+	}
+	if !h.storage.Exists(file.ID) {
+		t.Fatal("a refused reconciliation deleted a file")
+	}
+
+	// Without deletion, the list alone is enough
+	if rec := reconcile(h, `{}`); rec.Code != http.StatusOK {
+		t.Errorf("report without ids returned %d: %s", rec.Code, rec.Body)
+	}
+
+	// An explicit empty list deletes every file created before the snapshot
+	rec := reconcile(h, `{"ids":[],"created_before":"`+snapshot+`","delete_extras":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reconcile returned %d: %s", rec.Code, rec.Body)
+	}
+	var report storage.ReconcileReport
+	json.NewDecoder(rec.Body).Decode(&report)
+	if len(report.Deleted) != 1 || report.Deleted[0] != file.ID {
+		t.Errorf("deleted %v, want %s", report.Deleted, file.ID)
+	}
+}
+
+func TestInventoryStreamsFiles(t *testing.T) {
+	h := newTestHandler(t, nil)
+	first := uploadTestFile(t, h, "a.txt", "alpha", nil)
+	second := uploadTestFile(t, h, "b.txt", "beta", nil)
+
+	rec := httptest.NewRecorder()
+	h.Inventory(rec, httptest.NewRequest(http.MethodGet, "/api/v1/inventory", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("inventory returned %d with type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec.Header().Get(inventoryCursorHeader) == "" {
+		t.Error("inventory sent no cursor")
+	}
+
+	listed := make(map[string]bool)
+	decoder := json.NewDecoder(rec.Body)
+	for decoder.More() {
+		var info struct {
+			ID       string `json:"id"`
+			Checksum string `json:"checksum"`
+		}
+		if err := decoder.Decode(&info); err != nil {
+			t.Fatalf("decoding inventory line: %v", err)
+		}
+		if info.Checksum == "" {
+			t.Errorf("%s listed without a checksum", info.ID)
+		}
+		listed[info.ID] = true
+	}
+	if len(listed) != 2 || !listed[first.ID] || !listed[second.ID] {
+		t.Errorf("inventory listed %v, want both files", listed)
+	}
+}
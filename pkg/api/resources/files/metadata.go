@@ -0,0 +1,288 @@
+package files
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/storage"
+	"github.com/dvfs/storage-node/pkg/utils"
+	"github.com/dvfs/storage-node/pkg/webhook"
+)
+
+// Limits on client-supplied metadata and tags
+const (
+	userMetadataPrefix     = "X-Meta-"
+	maxMetadataEntries     = 64
+	maxMetadataKeyLength   = 128
+	maxMetadataValueLength = 1024
+)
+
+// UpdateFileMetadata handles PATCH /api/v1/files/{id}/metadata
+func (h *Handler) UpdateFileMetadata(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileID := h.extractFileID(r.URL.Path)
+	if fileID == "" {
+		h.sendError(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	var patch models.MetadataPatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		h.sendError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := h.storage.UpdateMetadata(fileID, r.Header.Get("If-Match"), func(m *models.FileMetadata) error {
+		userMetadata, err := applyPatch(m.UserMetadata, patch.Metadata)
+		if err != nil {
+			return &invalidInputError{fmt.Errorf("metadata: %w", err)}
+		}
+		tags, err := applyPatch(m.Tags, patch.Tags)
+		if err != nil {
+			return &invalidInputError{fmt.Errorf("tags: %w", err)}
+		}
+		m.UserMetadata, m.Tags = userMetadata, tags
+
+		// Tags can decide whether the policy accepts a file
+		if patch.Tags != nil {
+			return h.checkFile(m)
+		}
+		return nil
+	})
+	if err != nil {
+		h.sendUpdateError(w, fileID, err)
+		return
+	}
+
+	w.Header().Set("ETag", metadata.ETag())
+	h.notify(webhook.EventUpdate, metadata)
+	h.syncReplicas(metadata)
+	h.sendJSON(w, h.infoResponse(metadata), http.StatusOK)
+}
+
+// UpdateFile handles PATCH /api/v1/files/{id}. It renames the file or
+// changes how it is served without touching the stored content.
+func (h *Handler) UpdateFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileID := h.extractFileID(r.URL.Path)
+	if fileID == "" {
+		h.sendError(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	var patch models.FilePatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		h.sendError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if patch.OriginalName != nil {
+		name := utils.SanitizeFileName(*patch.OriginalName)
+		if name == "" {
+			h.sendError(w, "original_name must not be empty", http.StatusBadRequest)
+			return
+		}
+		patch.OriginalName = &name
+	}
+	if patch.ContentType != nil {
+		mediaType, params, err := mime.ParseMediaType(*patch.ContentType)
+		if err != nil {
+			h.sendError(w, "Invalid content_type", http.StatusBadRequest)
+			return
+		}
+		contentType := mime.FormatMediaType(mediaType, params)
+		patch.ContentType = &contentType
+	}
+	if patch.CacheControl != nil && strings.ContainsAny(*patch.CacheControl, "\r\n") {
+		h.sendError(w, "Invalid cache_control", http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := h.storage.UpdateMetadata(fileID, r.Header.Get("If-Match"), func(m *models.FileMetadata) error {
+		if patch.OriginalName != nil {
+			m.OriginalName = *patch.OriginalName
+		}
+		if patch.ContentType != nil {
+			m.ContentType = *patch.ContentType
+		}
+		if patch.CacheControl != nil {
+			m.CacheControl = strings.TrimSpace(*patch.CacheControl)
+		}
+		if patch.OriginalName != nil || patch.ContentType != nil {
+			return h.checkFile(m)
+		}
+		return nil
+	})
+	if err != nil {
+		h.sendUpdateError(w, fileID, err)
+		return
+	}
+
+	w.Header().Set("ETag", metadata.ETag())
+	h.notify(webhook.EventUpdate, metadata)
+	h.syncReplicas(metadata)
+	h.sendJSON(w, h.infoResponse(metadata), http.StatusOK)
+}
+
+// ListFiles handles GET /api/v1/files, optionally filtered by one or more
+// tag=key or tag=key=value query parameters
+func (h *Handler) ListFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filters := r.URL.Query()["tag"]
+	all, err := h.storage.List(func(m *models.FileMetadata) bool {
+		for _, filter := range filters {
+			key, value, hasValue := strings.Cut(filter, "=")
+			current, ok := m.Tags[strings.ToLower(key)]
+			if !ok || hasValue && current != value {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to list files: %v", err)
+		h.sendError(w, "Failed to list files", http.StatusInternalServerError)
+		return
+	}
+
+	response := &models.FileListResponse{
+		Files: make([]*models.FileInfoResponse, 0, len(all)),
+		Count: len(all),
+	}
+	for _, metadata := range all {
+		response.Files = append(response.Files, h.infoResponse(metadata))
+	}
+
+	h.sendJSON(w, response, http.StatusOK)
+}
+
+// parseUserMetadata collects X-Meta-* headers into a map keyed by the
+// lower-cased suffix
+func parseUserMetadata(header http.Header) (map[string]string, error) {
+	values := make(map[string]string)
+	for name, v := range header {
+		if len(name) <= len(userMetadataPrefix) || !strings.EqualFold(name[:len(userMetadataPrefix)], userMetadataPrefix) {
+			continue
+		}
+		values[strings.ToLower(name[len(userMetadataPrefix):])] = strings.Join(v, ",")
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	return values, validateMetadata(values)
+}
+
+// parseTags decodes a JSON object of string tags
+func parseTags(raw string) (map[string]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	var tags map[string]string
+	if err := json.Unmarshal([]byte(raw), &tags); err != nil {
+		return nil, errors.New("tags must be a JSON object of strings")
+	}
+
+	normalized := make(map[string]string, len(tags))
+	for key, value := range tags {
+		normalized[strings.ToLower(key)] = value
+	}
+	return normalized, validateMetadata(normalized)
+}
+
+// applyPatch merges a patch into values; nil patch values delete keys
+func applyPatch(values map[string]string, patch map[string]*string) (map[string]string, error) {
+	if len(patch) == 0 {
+		return values, nil
+	}
+
+	merged := make(map[string]string, len(values)+len(patch))
+	for key, value := range values {
+		merged[key] = value
+	}
+	for key, value := range patch {
+		key = strings.ToLower(key)
+		if value == nil {
+			delete(merged, key)
+		} else {
+			merged[key] = *value
+		}
+	}
+
+	if len(merged) == 0 {
+		return nil, nil
+	}
+	return merged, validateMetadata(merged)
+}
+
+// validateMetadata enforces key syntax and size limits
+func validateMetadata(values map[string]string) error {
+	if len(values) > maxMetadataEntries {
+		return fmt.Errorf("at most %d entries are allowed", maxMetadataEntries)
+	}
+	for key, value := range values {
+		if key == "" || len(key) > maxMetadataKeyLength {
+			return fmt.Errorf("key %q must be 1-%d characters", key, maxMetadataKeyLength)
+		}
+		for _, c := range key {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+				return fmt.Errorf("key %q may only contain letters, digits, '-', '_' and '.'", key)
+			}
+		}
+		if len(value) > maxMetadataValueLength {
+			return fmt.Errorf("value of %q exceeds %d bytes", key, maxMetadataValueLength)
+		}
+	}
+	return nil
+}
+
+// invalidInputError marks an update rejected because of client input
+type invalidInputError struct {
+	err error
+}
+
+func (e *invalidInputError) Error() string {
+	return e.err.Error()
+}
+
+// sendUpdateError maps errors from FileStorage.UpdateMetadata to responses
+func (h *Handler) sendUpdateError(w http.ResponseWriter, fileID string, err error) {
+	if h.sendScanError(w, err) {
+		return
+	}
+	var invalid *invalidInputError
+	var rejection *policyRejection
+	switch {
+	case errors.As(err, &rejection):
+		h.sendPolicyError(w, rejection)
+	case errors.Is(err, storage.ErrPreconditionFailed):
+		h.sendError(w, "File has been modified", http.StatusPreconditionFailed)
+	case errors.Is(err, storage.ErrContentTypeMismatch):
+		h.sendError(w, err.Error(), http.StatusUnsupportedMediaType)
+	case strings.Contains(err.Error(), "not found"):
+		h.sendError(w, "File not found", http.StatusNotFound)
+	case errors.As(err, &invalid):
+		h.sendError(w, invalid.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Failed to update file %s: %v", fileID, err)
+		h.sendError(w, "Failed to update file", http.StatusInternalServerError)
+	}
+}
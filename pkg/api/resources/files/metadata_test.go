@@ -0,0 +1,208 @@
+package files
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// listFiles returns the names of the files a listing with query returns
+func listFiles(t *testing.T, h *Handler, query url.Values) []string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ListFiles(rec, httptest.NewRequest(http.MethodGet, "/api/v1/files?"+query.Encode(), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("listing returned %d: %s", rec.Code, rec.Body)
+	}
+	var resp models.FileListResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding listing: %v", err)
+	}
+	if resp.Count != len(resp.Files) {
+		t.Errorf("listing counts %d files but holds %d", resp.Count, len(resp.Files))
+	}
+	names := make([]string, 0, len(resp.Files))
+	for _, file := range resp.Files {
+		names = append(names, file.OriginalName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestListFilesByTag(t *testing.T) {
+	h := newTestHandler(t, nil)
+	uploadTestFile(t, h, "a.txt", "a", map[string]string{"X-Tags": `{"Env":"prod","team":"web"}`})
+	uploadTestFile(t, h, "b.txt", "b", map[string]string{"X-Tags": `{"env":"dev","team":"web"}`})
+	uploadTestFile(t, h, "c.txt", "c", map[string]string{"X-Meta-Env": "prod"})
+
+	tests := []struct {
+		name string
+		tags []string
+		want string
+	}{
+		{"no filter", nil, "a.txt,b.txt,c.txt"},
+		{"key and value", []string{"env=prod"}, "a.txt"},
+		{"key only", []string{"team"}, "a.txt,b.txt"},
+		{"key in any case", []string{"ENV=dev"}, "b.txt"},
+		{"value is case sensitive", []string{"env=PROD"}, ""},
+		{"empty value", []string{"env="}, ""},
+		{"all filters apply", []string{"team=web", "env=dev"}, "b.txt"},
+		{"unknown key", []string{"owner"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := listFiles(t, h, url.Values{"tag": tt.tags})
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("listing = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateFileMetadata(t *testing.T) {
+	h := newTestHandler(t, nil)
+	file := uploadTestFile(t, h, "a.txt", "a", map[string]string{
+		"X-Tags":      `{"env":"dev","team":"web"}`,
+		"X-Meta-Note": "first",
+	})
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.UpdateFileMetadata(rec, httptest.NewRequest(http.MethodPatch, "/api/v1/files/"+file.ID+"/metadata", strings.NewReader(body)))
+		return rec
+	}
+
+	// Keys not named are kept and null removes a key
+	rec := patch(`{"tags":{"Env":"prod","team":null},"metadata":{"owner":"ops"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("patch returned %d: %s", rec.Code, rec.Body)
+	}
+	var info models.FileInfoResponse
+	json.NewDecoder(rec.Body).Decode(&info)
+	if len(info.Tags) != 1 || info.Tags["env"] != "prod" {
+		t.Errorf("tags = %v, want only env=prod", info.Tags)
+	}
+	if len(info.Metadata) != 2 || info.Metadata["note"] != "first" || info.Metadata["owner"] != "ops" {
+		t.Errorf("metadata = %v, want note and owner", info.Metadata)
+	}
+
+	if got := listFiles(t, h, url.Values{"tag": {"env=prod"}}); len(got) != 1 {
+		t.Errorf("listing by the new tag = %v, want the patched file", got)
+	}
+	if got := listFiles(t, h, url.Values{"tag": {"team"}}); len(got) != 0 {
+		t.Errorf("listing by the removed tag = %v, want nothing", got)
+	}
+
+	for _, body := range []string{
+		`{"tags":{"bad key":"v"}}`,
+		`{"metadata":{"k":"` + strings.Repeat("x", maxMetadataValueLength+1) + `"}}`,
+		`not json`,
+	} {
+		if rec := patch(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("patch %.40s returned %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestUploadRejectsInvalidTags(t *testing.T) {
+	h := newTestHandler(t, nil)
+	for name, headers := range map[string]map[string]string{
+		"not an object": {"X-Tags": `["env"]`},
+		"bad key":       {"X-Tags": `{"env/x":"prod"}`},
+		"long value":    {"X-Meta-Note": strings.Repeat("x", maxMetadataValueLength+1)},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/files", strings.NewReader("content"))
+		req.Header.Set("Content-Type", "text/plain")
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		h.UploadFile(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("upload with %s returned %d, want 400", name, rec.Code)
+		}
+	}
+}
+
+func TestUpdateFile(t *testing.T) {
+	h := newTestHandler(t, nil)
+	file := uploadTestFile(t, h, "report.txt", "content", nil)
+	before, err := h.storage.GetMetadata(file.ID)
+	if err != nil {
+		t.Fatalf("GetMetadata: %v", err)
+	}
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.UpdateFile(rec, httptest.NewRequest(http.MethodPatch, "/api/v1/files/"+file.ID, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := patch(`{"original_name":"../notes/final.md","content_type":"Text/Markdown; Charset=UTF-8","cache_control":" max-age=60 "}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("patch returned %d: %s", rec.Code, rec.Body)
+	}
+	var info models.FileInfoResponse
+	json.NewDecoder(rec.Body).Decode(&info)
+	// Names are sanitized like those of uploads
+	if info.ID != file.ID || info.OriginalName != "__notes_final.md" {
+		t.Errorf("patched file is %s named %q, want %s named __notes_final.md", info.ID, info.OriginalName, file.ID)
+	}
+	if info.ContentType != "text/markdown; charset=UTF-8" || info.CacheControl != "max-age=60" {
+		t.Errorf("content type %q and cache control %q, want them normalized", info.ContentType, info.CacheControl)
+	}
+	if !info.UpdatedAt.After(before.UpdatedAt) {
+		t.Errorf("UpdatedAt %v not moved past %v", info.UpdatedAt, before.UpdatedAt)
+	}
+	// The data file keeps the extension it was stored under
+	if info.Extension != before.Extension {
+		t.Errorf("extension changed from %q to %q", before.Extension, info.Extension)
+	}
+
+	// Downloads are served under the new attributes
+	rec = httptest.NewRecorder()
+	h.GetFile(rec, httptest.NewRequest(http.MethodGet, "/api/v1/files/"+file.ID, nil))
+	if rec.Body.String() != "content" {
+		t.Errorf("download after a rename = %q, want the stored content", rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != info.ContentType {
+		t.Errorf("download Content-Type = %q, want %q", got, info.ContentType)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "max-age=60" {
+		t.Errorf("download Cache-Control = %q, want max-age=60", got)
+	}
+	if got := rec.Header().Get("X-Original-Name"); got != info.OriginalName {
+		t.Errorf("download X-Original-Name = %q, want %q", got, info.OriginalName)
+	}
+
+	// Omitted fields are kept and an empty cache control clears it
+	rec = patch(`{"cache_control":""}`)
+	var cleared models.FileInfoResponse
+	json.NewDecoder(rec.Body).Decode(&cleared)
+	if cleared.OriginalName != info.OriginalName || cleared.CacheControl != "" {
+		t.Errorf("after clearing the cache control: name %q, cache control %q", cleared.OriginalName, cleared.CacheControl)
+	}
+
+	for _, body := range []string{
+		`{"original_name":" . "}`,
+		`{"content_type":"not a type"}`,
+		`{"cache_control":"no-store\r\nX-Injected: 1"}`,
+		`not json`,
+	} {
+		if rec := patch(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("patch %q returned %d, want 400", body, rec.Code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.UpdateFile(rec, httptest.NewRequest(http.MethodPatch, "/api/v1/files/3f2b8c1e-0000-4000-8000-000000000000", strings.NewReader(`{"original_name":"x.txt"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("patch of an unknown file returned %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,163 @@
+package files
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/storage"
+	"github.com/dvfs/storage-node/pkg/webhook"
+)
+
+// CopyFile handles POST /api/v1/files/{id}/copy
+func (h *Handler) CopyFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileID := h.extractFileID(r.URL.Path)
+	if fileID == "" {
+		h.sendError(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.CopyRequest
+	if !h.decodeOptionalJSON(w, r, &req) {
+		return
+	}
+	copies, err := h.replicationFactor(r)
+	if err != nil {
+		h.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := h.storage.Compose(
+		[]storage.ByteRange{{FileID: fileID, Length: -1}},
+		&models.FileUploadRequest{FileName: req.OriginalName, ContentType: req.ContentType, Copies: copies},
+		h.checkDerived,
+	)
+	h.sendDerived(w, metadata, err)
+}
+
+// ComposeFiles handles POST /api/v1/compose
+func (h *Handler) ComposeFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.ComposeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	copies, err := h.replicationFactor(r)
+	if err != nil {
+		h.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ranges := make([]storage.ByteRange, 0, len(req.Sources))
+	for _, source := range req.Sources {
+		ranges = append(ranges, storage.ByteRange{FileID: source, Length: -1})
+	}
+
+	metadata, err := h.storage.Compose(ranges, &models.FileUploadRequest{
+		FileName:    req.OriginalName,
+		ContentType: req.ContentType,
+		Copies:      copies,
+	}, h.checkDerived)
+	h.sendDerived(w, metadata, err)
+}
+
+// SliceFile handles POST /api/v1/files/{id}/slice
+func (h *Handler) SliceFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileID := h.extractFileID(r.URL.Path)
+	if fileID == "" {
+		h.sendError(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.SliceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	copies, err := h.replicationFactor(r)
+	if err != nil {
+		h.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rng := storage.ByteRange{FileID: fileID, Offset: req.Offset, Length: -1}
+	if req.Length != nil {
+		rng.Length = *req.Length
+		if rng.Length < 0 {
+			h.sendError(w, "length must not be negative", http.StatusBadRequest)
+			return
+		}
+	}
+
+	metadata, err := h.storage.Compose([]storage.ByteRange{rng}, &models.FileUploadRequest{
+		FileName:    req.OriginalName,
+		ContentType: req.ContentType,
+		Copies:      copies,
+	}, h.checkDerived)
+	h.sendDerived(w, metadata, err)
+}
+
+// decodeOptionalJSON decodes the request body into v, accepting an empty body
+func (h *Handler) decodeOptionalJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		h.sendError(w, "Invalid JSON body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// sendDerived replicates a file created from existing files and writes the
+// response
+func (h *Handler) sendDerived(w http.ResponseWriter, metadata *models.FileMetadata, err error) {
+	if err != nil {
+		if h.sendScanError(w, err) {
+			return
+		}
+		var rejection *policyRejection
+		switch {
+		case errors.As(err, &rejection):
+			h.sendPolicyError(w, rejection)
+		case errors.Is(err, storage.ErrInvalidRange):
+			h.sendError(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		case errors.Is(err, storage.ErrComposeTooLarge):
+			h.sendError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, storage.ErrContentTypeMismatch):
+			h.sendError(w, err.Error(), http.StatusUnsupportedMediaType)
+		case strings.Contains(err.Error(), "not found"):
+			h.sendError(w, err.Error(), http.StatusNotFound)
+		default:
+			log.Printf("Failed to create derived file: %v", err)
+			h.sendError(w, "Failed to create file", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	replicas, ok := h.replicateNew(w, metadata, nil)
+	if !ok {
+		return
+	}
+
+	h.notify(webhook.EventUpload, metadata)
+	response := h.uploadResponse(metadata)
+	response.Replicas = replicas
+	h.sendJSON(w, response, http.StatusCreated)
+}
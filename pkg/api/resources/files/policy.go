@@ -0,0 +1,165 @@
+package files
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/policy"
+	"github.com/dvfs/storage-node/pkg/utils"
+)
+
+// policyRejection is an upload refused by the upload policy
+type policyRejection struct {
+	decision policy.Decision
+	message  string
+	status   int
+	// path names the archive entry that was rejected, if any
+	path string
+}
+
+func (e *policyRejection) Error() string {
+	return e.message
+}
+
+// checkPolicy evaluates an upload against the upload policy. It returns
+// the rejection, or nil and whether the upload must be scanned before it
+// is stored.
+func (h *Handler) checkPolicy(upload policy.Upload) (bool, *policyRejection) {
+	if h.policy == nil {
+		return false, nil
+	}
+
+	decision := h.policy.Evaluate(upload)
+	switch decision.Action {
+	case policy.ActionAllow:
+		return false, nil
+	case policy.ActionRequireScan:
+		if h.storage.Scanning() {
+			return true, nil
+		}
+		return false, &policyRejection{
+			decision: decision,
+			message:  "Upload requires a malware scan but no scanner is configured",
+			status:   http.StatusUnsupportedMediaType,
+		}
+	}
+
+	if decision.SizeLimited {
+		return false, &policyRejection{
+			decision: decision,
+			message:  "Upload size is not allowed by policy",
+			status:   http.StatusRequestEntityTooLarge,
+		}
+	}
+	return false, &policyRejection{
+		decision: decision,
+		message:  "Upload type is not allowed by policy",
+		status:   http.StatusUnsupportedMediaType,
+	}
+}
+
+// multipartOverhead allows for the form framing around the file when a
+// multipart body is held to a policy size limit
+const multipartOverhead = 1 << 20
+
+// limitBody holds an upload to the largest size the upload policy could
+// accept given what is known before its body is read. A declared length
+// over the limit is rejected right away; otherwise the body is capped so
+// reading past the limit fails. It returns the attributes the limit was
+// based on, or false if the request was rejected.
+func (h *Handler) limitBody(w http.ResponseWriter, r *http.Request) (policy.Upload, bool) {
+	var upload policy.Upload
+	if h.policy == nil {
+		return upload, true
+	}
+
+	// A multipart body carries the file's name, type and tags itself
+	multipart := strings.Contains(r.Header.Get("Content-Type"), "multipart/form-data")
+	if !multipart {
+		upload.ContentType = r.Header.Get("Content-Type")
+		upload.FileName = r.Header.Get("X-Filename")
+		if tags, err := parseTags(r.Header.Get("X-Tags")); err == nil {
+			upload.Tags = tags
+			if upload.Tags == nil {
+				upload.Tags = map[string]string{}
+			}
+		}
+	}
+
+	limit, limited := h.policy.SizeLimit(upload)
+	if !limited {
+		return upload, true
+	}
+	if multipart {
+		limit += multipartOverhead
+	}
+	if r.ContentLength > limit {
+		h.sendPolicyError(w, h.sizeRejection(upload, r.ContentLength))
+		return upload, false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	return upload, true
+}
+
+// sizeRejection returns the rejection of an upload of the given size
+func (h *Handler) sizeRejection(upload policy.Upload, size int64) *policyRejection {
+	upload.Size = size
+	if _, rejection := h.checkPolicy(upload); rejection != nil {
+		return rejection
+	}
+	return &policyRejection{
+		decision: policy.Decision{Action: policy.ActionDeny, SizeLimited: true},
+		message:  "Upload size is not allowed by policy",
+		status:   http.StatusRequestEntityTooLarge,
+	}
+}
+
+// checkDerived applies the upload policy to a file about to be created
+// from stored ones, marking it for a scan if the policy asks for one
+func (h *Handler) checkDerived(req *models.FileUploadRequest) error {
+	requireScan, rejection := h.checkPolicy(policy.Upload{
+		ContentType:  req.ContentType,
+		DetectedType: utils.DetectContentType(req.Content),
+		FileName:     req.FileName,
+		Size:         int64(len(req.Content)),
+		Tags:         req.Tags,
+	})
+	if rejection != nil {
+		return rejection
+	}
+	req.RequireScan = requireScan
+	return nil
+}
+
+// checkFile applies the upload policy to the state a stored file is about
+// to be changed to, so that appending, rewriting or relabelling a file
+// cannot produce one the policy refuses. Changed content is scanned again
+// anyway, so a required scan does not need to be requested.
+func (h *Handler) checkFile(metadata *models.FileMetadata) error {
+	_, rejection := h.checkPolicy(policy.Upload{
+		ContentType:  metadata.ContentType,
+		DetectedType: metadata.DetectedType,
+		FileName:     metadata.OriginalName,
+		Size:         metadata.Size,
+		Tags:         metadata.Tags,
+	})
+	if rejection != nil {
+		return rejection
+	}
+	return nil
+}
+
+// sendPolicyError writes a structured policy rejection
+func (h *Handler) sendPolicyError(w http.ResponseWriter, rejection *policyRejection) {
+	h.sendJSON(w, &models.PolicyErrorResponse{
+		ErrorResponse: models.ErrorResponse{
+			Error:   http.StatusText(rejection.status),
+			Code:    rejection.status,
+			Message: rejection.message,
+		},
+		Action: rejection.decision.Action,
+		Rule:   rejection.decision.Rule,
+		Path:   rejection.path,
+	}, rejection.status)
+}
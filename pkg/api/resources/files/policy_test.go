@@ -0,0 +1,225 @@
+package files
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dvfs/storage-node/pkg/delta"
+	"github.com/dvfs/storage-node/pkg/policy"
+)
+
+// testPolicy refuses executables and anything over 100 bytes
+const testPolicy = `{
+	"rules": [
+		{"name": "no-exe", "extensions": ["exe"], "action": "deny"},
+		{"name": "no-msdownload", "content_types": ["application/x-msdownload"], "action": "deny"},
+		{"name": "max-size", "size_above": 100, "action": "deny"}
+	]
+}`
+
+// newPolicyHandler creates a test handler enforcing testPolicy
+func newPolicyHandler(t *testing.T) *Handler {
+	t.Helper()
+	h := newTestHandler(t, nil)
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(testPolicy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := policy.NewEngine(path, 0)
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	h.policy = engine
+	return h
+}
+
+// policyRule returns the rule named in a policy error response
+func policyRule(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp struct {
+		Rule string `json:"rule"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding policy error: %v", err)
+	}
+	return resp.Rule
+}
+
+func TestPolicyAppliesToChangedFiles(t *testing.T) {
+	h := newPolicyHandler(t)
+	text := strings.Repeat("a", 60)
+	first := uploadTestFile(t, h, "a.txt", text, nil)
+	second := uploadTestFile(t, h, "b.txt", text, nil)
+	log := uploadTestFile(t, h, "log.txt", text, map[string]string{"X-Appendable": "true"})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		serve  func(*Handler, http.ResponseWriter, *http.Request)
+		status int
+		rule   string
+	}{
+		{"copy to exe", http.MethodPost, "/api/v1/files/" + first.ID + "/copy", `{"original_name":"a.exe"}`, (*Handler).CopyFile, http.StatusUnsupportedMediaType, "no-exe"},
+		{"copy", http.MethodPost, "/api/v1/files/" + first.ID + "/copy", ``, (*Handler).CopyFile, http.StatusCreated, ""},
+		{"compose too large", http.MethodPost, "/api/v1/compose", `{"sources":["` + first.ID + `","` + second.ID + `"]}`, (*Handler).ComposeFiles, http.StatusRequestEntityTooLarge, "max-size"},
+		{"slice retyped", http.MethodPost, "/api/v1/files/" + first.ID + "/slice", `{"offset":10,"content_type":"application/x-msdownload"}`, (*Handler).SliceFile, http.StatusUnsupportedMediaType, "no-msdownload"},
+		{"append too much", http.MethodPost, "/api/v1/files/" + log.ID + "/append", strings.Repeat("b", 41), (*Handler).AppendFile, http.StatusRequestEntityTooLarge, "max-size"},
+		{"append", http.MethodPost, "/api/v1/files/" + log.ID + "/append", strings.Repeat("b", 40), (*Handler).AppendFile, http.StatusOK, ""},
+		{"rename to exe", http.MethodPatch, "/api/v1/files/" + first.ID, `{"original_name":"a.exe"}`, (*Handler).UpdateFile, http.StatusUnsupportedMediaType, "no-exe"},
+		{"retype", http.MethodPatch, "/api/v1/files/" + first.ID, `{"content_type":"application/x-msdownload"}`, (*Handler).UpdateFile, http.StatusUnsupportedMediaType, "no-msdownload"},
+		{"cache control", http.MethodPatch, "/api/v1/files/" + first.ID, `{"cache_control":"no-store"}`, (*Handler).UpdateFile, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.serve(h, rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if rec.Code != tt.status {
+				t.Fatalf("returned %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.rule != "" {
+				if rule := policyRule(t, rec); rule != tt.rule {
+					t.Errorf("rejected by %q, want %q", rule, tt.rule)
+				}
+			}
+		})
+	}
+
+	// A rejected append leaves the file as it was
+	rec := httptest.NewRecorder()
+	h.GetFileInfo(rec, httptest.NewRequest(http.MethodGet, "/api/v1/files/"+log.ID+"/info", nil))
+	var info struct {
+		Size int64 `json:"size"`
+	}
+	json.NewDecoder(rec.Body).Decode(&info)
+	if info.Size != 100 {
+		t.Errorf("appendable file has %d bytes, want 100", info.Size)
+	}
+}
+
+func TestPolicyAppliesToDelta(t *testing.T) {
+	h := newPolicyHandler(t)
+	file := uploadTestFile(t, h, "a.txt", strings.Repeat("a", 90), nil)
+	etag := currentETag(t, h, file.ID)
+
+	d := &delta.Delta{BlockSize: delta.MinBlockSize, Ops: []delta.Op{{Type: delta.OpLiteral, Data: bytes.Repeat([]byte("b"), 101)}}}
+	body, _ := json.Marshal(d)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/files/"+file.ID+"/delta", bytes.NewReader(body))
+	req.Header.Set("If-Match", etag)
+	rec := httptest.NewRecorder()
+	h.ApplyDelta(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized delta returned %d, want 413: %s", rec.Code, rec.Body)
+	}
+	if got := currentETag(t, h, file.ID); got != etag {
+		t.Error("rejected delta changed the file")
+	}
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	r    io.Reader
+	read int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += n
+	return n, err
+}
+
+func TestPolicyLimitsUploadBody(t *testing.T) {
+	h := newPolicyHandler(t)
+	content := strings.Repeat("x", 10000)
+
+	tests := []struct {
+		name          string
+		contentLength int64
+		maxRead       int
+	}{
+		// A declared length over the limit is refused unread
+		{"declared", int64(len(content)), 0},
+		// An undeclared length is read only up to the limit
+		{"chunked", -1, 4096},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &countingReader{r: strings.NewReader(content)}
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/files", body)
+			req.ContentLength = tt.contentLength
+			req.Header.Set("Content-Type", "text/plain")
+			req.Header.Set("X-Filename", "big.txt")
+			rec := httptest.NewRecorder()
+			h.UploadFile(rec, req)
+
+			if rec.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("returned %d, want 413: %s", rec.Code, rec.Body)
+			}
+			if rule := policyRule(t, rec); rule != "max-size" {
+				t.Errorf("rejected by %q, want max-size", rule)
+			}
+			if body.read > tt.maxRead {
+				t.Errorf("read %d bytes of the body, want at most %d", body.read, tt.maxRead)
+			}
+		})
+	}
+
+	// An executable is refused before its body is read, whatever its size
+	body := &countingReader{r: strings.NewReader("MZ")}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/files", body)
+	req.ContentLength = 2
+	req.Header.Set("X-Filename", "setup.exe")
+	rec := httptest.NewRecorder()
+	h.UploadFile(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType || body.read != 0 {
+		t.Errorf("executable returned %d after reading %d bytes, want 415 unread", rec.Code, body.read)
+	}
+}
+
+func TestPolicyLimitsMultipartBody(t *testing.T) {
+	h := newPolicyHandler(t)
+
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	part, _ := form.CreateFormFile("file", "big.txt")
+	part.Write(bytes.Repeat([]byte("x"), multipartOverhead+1000))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/files", &buf)
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	h.UploadFile(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized multipart upload returned %d, want 413: %s", rec.Code, rec.Body)
+	}
+
+	// Small files still fit in the allowance for the form
+	uploadMultipart := func(size int) int {
+		var buf bytes.Buffer
+		form := multipart.NewWriter(&buf)
+		part, _ := form.CreateFormFile("file", "small.txt")
+		part.Write(bytes.Repeat([]byte("x"), size))
+		form.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/files", &buf)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		rec := httptest.NewRecorder()
+		h.UploadFile(rec, req)
+		return rec.Code
+	}
+	if code := uploadMultipart(100); code != http.StatusCreated {
+		t.Errorf("multipart upload at the limit returned %d", code)
+	}
+	if code := uploadMultipart(101); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("multipart upload over the limit returned %d", code)
+	}
+}
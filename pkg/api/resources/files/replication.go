@@ -0,0 +1,90 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/replication"
+)
+
+// replicationFactor returns the number of copies requested for a new file
+// through X-Replication-Factor, or the configured default
+func (h *Handler) replicationFactor(r *http.Request) (int, error) {
+	value := r.Header.Get(replication.FactorHeader)
+	if value == "" {
+		return h.copies, nil
+	}
+	copies, err := strconv.Atoi(value)
+	if err != nil || copies < 1 || copies > h.replicator.MaxFactor() {
+		return 0, fmt.Errorf("%s must be between 1 and %d", replication.FactorHeader, h.replicator.MaxFactor())
+	}
+	return copies, nil
+}
+
+// writeQuorum returns the number of copies that must be written for an
+// upload of the given number of copies to succeed
+func (h *Handler) writeQuorum(copies int) int {
+	quorum := h.quorum
+	if quorum <= 0 {
+		quorum = copies/2 + 1
+	}
+	if quorum > copies {
+		quorum = copies
+	}
+	return quorum
+}
+
+// replicate copies a file just stored on this node to the peers that
+// should hold its replicas, going by the factor recorded for it. A nil
+// content is read back from storage when there are peers to send it to.
+func (h *Handler) replicate(metadata *models.FileMetadata, content []byte) (*replication.Replication, error) {
+	copies := max(metadata.Copies, 1)
+	if content == nil && copies > 1 {
+		var err error
+		if content, _, err = h.storage.Snapshot(metadata.ID); err != nil {
+			return nil, fmt.Errorf("failed to read file for replication: %w", err)
+		}
+	}
+	return h.replicator.Replicate(metadata, content, copies, h.writeQuorum(copies))
+}
+
+// replicateNew replicates a file just created on this node. Without a
+// write quorum the file is removed again and the error response is sent.
+// It returns every instance holding the file, this one first.
+func (h *Handler) replicateNew(w http.ResponseWriter, metadata *models.FileMetadata, content []byte) ([]string, bool) {
+	rep, err := h.replicate(metadata, content)
+	if err != nil {
+		if err := h.storage.Delete(metadata.ID, metadata.ETag()); err != nil {
+			log.Printf("Failed to roll back unreplicated file %s: %v", metadata.ID, err)
+		}
+		h.sendReplicationError(w, metadata.ID, err)
+		return nil, false
+	}
+	return append([]string{h.instanceID}, rep.Instances...), true
+}
+
+// sendReplicationError writes the response for a file that could not be
+// replicated
+func (h *Handler) sendReplicationError(w http.ResponseWriter, fileID string, err error) {
+	if errors.Is(err, replication.ErrQuorumNotReached) {
+		h.sendError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	log.Printf("Failed to replicate file %s: %v", fileID, err)
+	h.sendError(w, "Failed to replicate file", http.StatusInternalServerError)
+}
+
+// syncReplicas sends the current version of a changed file to the peers
+// holding its replicas, in the background
+func (h *Handler) syncReplicas(metadata *models.FileMetadata) {
+	if metadata.Copies <= 1 {
+		return
+	}
+	h.replicator.Sync(metadata.ID, func() ([]byte, *models.FileMetadata, error) {
+		return h.storage.Snapshot(metadata.ID)
+	})
+}
@@ -0,0 +1,243 @@
+package files
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/api/resources/replicas"
+	"github.com/dvfs/storage-node/pkg/config"
+	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/replication"
+	"github.com/dvfs/storage-node/pkg/storage"
+)
+
+// testPeer is a second node receiving the replicas of a test handler
+type testPeer struct {
+	storage *storage.FileStorage
+	// refuse, if set, makes the peer fail replicas of files with that name
+	refuse string
+}
+
+// newReplicatedHandler creates a test handler keeping two copies of every
+// file, the second on a peer node
+func newReplicatedHandler(t *testing.T) (*Handler, *testPeer) {
+	t.Helper()
+	dir := t.TempDir()
+	fs, err := storage.NewFileStorage(&config.Config{StoragePath: dir, StoragePaths: []string{dir}, PackVolumeSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+
+	peer := &testPeer{storage: fs}
+	handler := replicas.NewHandler(fs, "peer", "s3cret", 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			handler.DeleteReplica(w, r)
+			return
+		}
+		var attributes models.FileUploadRequest
+		encoded, _ := base64.StdEncoding.DecodeString(r.Header.Get(replication.RequestHeader))
+		json.Unmarshal(encoded, &attributes)
+		if peer.refuse != "" && attributes.FileName == peer.refuse {
+			http.Error(w, "refused", http.StatusInternalServerError)
+			return
+		}
+		handler.PutReplica(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	h := newTestHandler(t, func(cfg *config.Config) {
+		cfg.Peers = []string{server.URL}
+		cfg.ReplicationSecret = "s3cret"
+		cfg.ReplicationFactor = 2
+		cfg.StagingTimeout = time.Hour
+	})
+	return h, peer
+}
+
+// content returns what the peer holds for fileID, or "" if it holds nothing
+func (p *testPeer) content(t *testing.T, fileID string) string {
+	t.Helper()
+	content, _, err := p.storage.Retrieve(fileID)
+	if err != nil {
+		return ""
+	}
+	return string(content)
+}
+
+func TestReuploadAfterDelete(t *testing.T) {
+	h, peer := newReplicatedHandler(t)
+
+	const fileID = "5f1c9a0e-3d2b-4c8e-9f61-7a2d4b8c0e13"
+	put := func(content string) int {
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/files/"+fileID, strings.NewReader(content))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-Filename", "notes.txt")
+		rec := httptest.NewRecorder()
+		h.PutFile(rec, req)
+		return rec.Code
+	}
+
+	if code := put("first version"); code != http.StatusCreated {
+		t.Fatalf("first upload returned %d", code)
+	}
+	rec := httptest.NewRecorder()
+	h.DeleteFile(rec, httptest.NewRequest(http.MethodDelete, "/api/v1/files/"+fileID, nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("delete returned %d: %s", rec.Code, rec.Body)
+	}
+
+	// The peer still holds the first version, which the new one replaces
+	if code := put("second version"); code != http.StatusCreated {
+		t.Fatalf("upload after delete returned %d, want 201", code)
+	}
+	if got := peer.content(t, fileID); got != "second version" {
+		t.Errorf("peer holds %q, want the second version", got)
+	}
+}
+
+func TestStagedUploadIsReplicatedOnCommit(t *testing.T) {
+	h, peer := newReplicatedHandler(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/files?stage=true", strings.NewReader("staged"))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	h.UploadFile(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("staged upload returned %d: %s", rec.Code, rec.Body)
+	}
+	var staged models.FileUploadResponse
+	json.NewDecoder(rec.Body).Decode(&staged)
+	if got := peer.content(t, staged.ID); got != "" {
+		t.Fatalf("peer holds %q before the commit", got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.CommitStaged(rec, httptest.NewRequest(http.MethodPost, "/api/v1/staged/"+staged.StagingToken+"/commit", nil))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("commit returned %d: %s", rec.Code, rec.Body)
+	}
+	var committed models.FileUploadResponse
+	json.NewDecoder(rec.Body).Decode(&committed)
+	if len(committed.Replicas) != 2 {
+		t.Errorf("commit reports replicas %v, want two instances", committed.Replicas)
+	}
+	if got := peer.content(t, staged.ID); got != "staged" {
+		t.Errorf("peer holds %q after the commit, want the staged content", got)
+	}
+}
+
+func TestDerivedFilesAreReplicated(t *testing.T) {
+	h, peer := newReplicatedHandler(t)
+	source := uploadTestFile(t, h, "a.txt", "0123456789", nil)
+
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		serve   func(*Handler, http.ResponseWriter, *http.Request)
+		content string
+	}{
+		{"copy", "/api/v1/files/" + source.ID + "/copy", ``, (*Handler).CopyFile, "0123456789"},
+		{"compose", "/api/v1/compose", `{"sources":["` + source.ID + `","` + source.ID + `"]}`, (*Handler).ComposeFiles, "01234567890123456789"},
+		{"slice", "/api/v1/files/" + source.ID + "/slice", `{"offset":2,"length":3}`, (*Handler).SliceFile, "234"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.serve(h, rec, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("returned %d: %s", rec.Code, rec.Body)
+			}
+			var derived models.FileUploadResponse
+			json.NewDecoder(rec.Body).Decode(&derived)
+			if got := peer.content(t, derived.ID); got != tt.content {
+				t.Errorf("peer holds %q, want %q", got, tt.content)
+			}
+		})
+	}
+}
+
+func TestChangesReachReplicas(t *testing.T) {
+	h, peer := newReplicatedHandler(t)
+	file := uploadTestFile(t, h, "log.txt", "first", map[string]string{"X-Appendable": "true"})
+
+	change := func(method, path, body string, serve func(*Handler, http.ResponseWriter, *http.Request)) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		serve(h, rec, httptest.NewRequest(method, "/api/v1/files/"+file.ID+path, strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s %s returned %d: %s", method, path, rec.Code, rec.Body)
+		}
+		// Replicas are brought up to date in the background
+		h.replicator.Close()
+	}
+
+	change(http.MethodPost, "/append", " second", (*Handler).AppendFile)
+	if got := peer.content(t, file.ID); got != "first second" {
+		t.Errorf("peer holds %q after an append", got)
+	}
+
+	change(http.MethodPatch, "", `{"original_name":"renamed.txt","cache_control":"no-store"}`, (*Handler).UpdateFile)
+	change(http.MethodPatch, "/metadata", `{"tags":{"k":"v"}}`, (*Handler).UpdateFileMetadata)
+	change(http.MethodPost, "/seal", "", (*Handler).SealFile)
+
+	metadata, err := peer.storage.GetMetadata(file.ID)
+	if err != nil {
+		t.Fatalf("GetMetadata on the peer: %v", err)
+	}
+	if metadata.OriginalName != "renamed.txt" || metadata.CacheControl != "no-store" || metadata.Tags["k"] != "v" || !metadata.Sealed {
+		t.Errorf("peer metadata = %+v, want the changes made on the origin", metadata)
+	}
+	if want := currentETag(t, h, file.ID); metadata.ReplicaVersion != want {
+		t.Errorf("peer holds version %s, want %s", metadata.ReplicaVersion, want)
+	}
+}
+
+func TestExtractedFilesAreReplicated(t *testing.T) {
+	h, peer := newReplicatedHandler(t)
+
+	rec := extract(h, zipArchive(t, "a.txt", "alpha", "b.txt", "beta"))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("extraction returned %d: %s", rec.Code, rec.Body)
+	}
+	var resp models.ExtractResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	for _, file := range resp.Files {
+		if len(file.Replicas) != 2 {
+			t.Errorf("%s reports replicas %v, want two instances", file.Path, file.Replicas)
+		}
+		if got := peer.content(t, file.ID); got == "" {
+			t.Errorf("peer holds no replica of %s", file.Path)
+		}
+	}
+}
+
+func TestFailedExtractionRemovesReplicas(t *testing.T) {
+	h, peer := newReplicatedHandler(t)
+	// The first file reaches the peer, the second does not
+	peer.refuse = "b.txt"
+
+	rec := extract(h, zipArchive(t, "a.txt", "alpha", "b.txt", "beta"))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("extraction returned %d, want 503: %s", rec.Code, rec.Body)
+	}
+
+	// Rollbacks of replicas finish in the background
+	h.replicator.Close()
+	for name, files := range map[string]*storage.FileStorage{"origin": h.storage, "peer": peer.storage} {
+		stored, err := files.List(nil)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if len(stored) != 0 {
+			t.Errorf("%s keeps %d files after a failed extraction", name, len(stored))
+		}
+	}
+}
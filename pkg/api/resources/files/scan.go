@@ -0,0 +1,29 @@
+package files
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/dvfs/storage-node/pkg/storage"
+)
+
+// sendScanError writes the response for malware scanning errors and
+// reports whether err was one
+func (h *Handler) sendScanError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, storage.ErrInfected):
+		h.sendError(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, storage.ErrQuarantined):
+		h.sendError(w, "File is quarantined", http.StatusForbidden)
+	case errors.Is(err, storage.ErrNotScanned):
+		w.Header().Set("Retry-After", "30")
+		h.sendError(w, "File has not passed a malware scan yet", http.StatusServiceUnavailable)
+	case errors.Is(err, storage.ErrScanFailed):
+		log.Printf("Upload rejected: %v", err)
+		h.sendError(w, "Malware scanner is unavailable", http.StatusServiceUnavailable)
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,183 @@
+package files
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/config"
+	"github.com/dvfs/storage-node/pkg/storage"
+)
+
+// startClamd serves a fake clamd that finds "EICAR" in streamed content
+// and returns its address
+func startClamd(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.ReadFull(conn, make([]byte, len("zINSTREAM\x00")))
+				var content bytes.Buffer
+				var size [4]byte
+				for {
+					if _, err := io.ReadFull(conn, size[:]); err != nil {
+						return
+					}
+					n := binary.BigEndian.Uint32(size[:])
+					if n == 0 {
+						break
+					}
+					io.CopyN(&content, conn, int64(n))
+				}
+				reply := "stream: OK\x00"
+				if bytes.Contains(content.Bytes(), []byte("EICAR")) {
+					reply = "stream: Eicar-Signature FOUND\x00"
+				}
+				conn.Write([]byte(reply))
+			}()
+		}
+	}()
+	return "tcp://" + listener.Addr().String()
+}
+
+// newScanningHandler creates a test handler scanning uploads with the
+// clamd at address
+func newScanningHandler(t *testing.T, address, mode string, blockUnscanned bool) *Handler {
+	t.Helper()
+	return newTestHandler(t, func(cfg *config.Config) {
+		cfg.Scanner = config.ScannerClamd
+		cfg.ClamdAddress = address
+		cfg.ScanMode = mode
+		cfg.ScanTimeout = 5 * time.Second
+		cfg.ScanWorkers = 1
+		cfg.ScanBlockUnscanned = blockUnscanned
+	})
+}
+
+// getFile requests the content of fileID
+func getFile(h *Handler, fileID string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.GetFile(rec, httptest.NewRequest(http.MethodGet, "/api/v1/files/"+fileID, nil))
+	return rec
+}
+
+// scanStatus waits for the background scan of fileID and returns the
+// status it reached
+func scanStatus(t *testing.T, h *Handler, fileID string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		metadata, err := h.storage.GetMetadata(fileID)
+		if err != nil {
+			t.Fatalf("GetMetadata: %v", err)
+		}
+		if metadata.ScanStatus != storage.ScanPending || time.Now().After(deadline) {
+			return metadata.ScanStatus
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestInfectedUploadIsQuarantined(t *testing.T) {
+	h := newScanningHandler(t, startClamd(t), config.ScanModeSync, false)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/files", strings.NewReader("X5O EICAR test"))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	h.UploadFile(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "Eicar-Signature") {
+		t.Fatalf("infected upload returned %d: %s, want 422 naming the signature", rec.Code, rec.Body)
+	}
+
+	// The quarantined file is listed with its verdict but never served
+	all, err := h.storage.List(nil)
+	if err != nil || len(all) != 1 {
+		t.Fatalf("List = %d files, %v, want the quarantined one", len(all), err)
+	}
+	fileID := all[0].ID
+	rec = httptest.NewRecorder()
+	h.GetFileInfo(rec, httptest.NewRequest(http.MethodGet, "/api/v1/files/"+fileID+"/info", nil))
+	if !strings.Contains(rec.Body.String(), `"scan_status":"infected"`) {
+		t.Errorf("info of a quarantined file = %s, want its scan status", rec.Body)
+	}
+	if rec := getFile(h, fileID); rec.Code != http.StatusForbidden {
+		t.Errorf("download of a quarantined file returned %d, want 403", rec.Code)
+	}
+
+	// Deleting it is still allowed
+	rec = httptest.NewRecorder()
+	h.DeleteFile(rec, httptest.NewRequest(http.MethodDelete, "/api/v1/files/"+fileID, nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("delete of a quarantined file returned %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAsyncScanQuarantinesDownloads(t *testing.T) {
+	h := newScanningHandler(t, startClamd(t), config.ScanModeAsync, false)
+
+	infected := uploadTestFile(t, h, "bad.txt", "X5O EICAR test", nil)
+	clean := uploadTestFile(t, h, "good.txt", "clean", nil)
+	if status := scanStatus(t, h, infected.ID); status != storage.ScanInfected {
+		t.Fatalf("infected file has scan status %q", status)
+	}
+	if status := scanStatus(t, h, clean.ID); status != storage.ScanClean {
+		t.Fatalf("clean file has scan status %q", status)
+	}
+
+	if rec := getFile(h, infected.ID); rec.Code != http.StatusForbidden {
+		t.Errorf("download of a quarantined file returned %d, want 403", rec.Code)
+	}
+	if rec := getFile(h, clean.ID); rec.Code != http.StatusOK || rec.Body.String() != "clean" {
+		t.Errorf("download of a clean file returned %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestBlockUnscannedDownloads(t *testing.T) {
+	// Nothing listens here, so scans keep failing
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := "tcp://" + listener.Addr().String()
+	listener.Close()
+
+	h := newScanningHandler(t, address, config.ScanModeAsync, true)
+	file := uploadTestFile(t, h, "a.txt", "content", nil)
+	rec := getFile(h, file.ID)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("download of an unscanned file returned %d with Retry-After %q, want 503 with a retry time", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// Without blocking the same file is served while it awaits a verdict
+	h = newScanningHandler(t, address, config.ScanModeAsync, false)
+	file = uploadTestFile(t, h, "a.txt", "content", nil)
+	if rec := getFile(h, file.ID); rec.Code != http.StatusOK {
+		t.Errorf("download with blocking off returned %d, want 200", rec.Code)
+	}
+
+	// Uploads that wait for a verdict are refused while clamd is down
+	h = newScanningHandler(t, address, config.ScanModeSync, false)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/files", strings.NewReader("content"))
+	req.Header.Set("Content-Type", "text/plain")
+	rec = httptest.NewRecorder()
+	h.UploadFile(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("upload with clamd down returned %d, want 503", rec.Code)
+	}
+}
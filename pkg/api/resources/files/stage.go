@@ -0,0 +1,84 @@
+package files
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dvfs/storage-node/pkg/storage"
+	"github.com/dvfs/storage-node/pkg/webhook"
+)
+
+// CommitStaged handles POST /api/v1/staged/{token}/commit, making a staged
+// upload visible and replicating it. If its write quorum is not reached the
+// file is removed again.
+func (h *Handler) CommitStaged(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := h.extractStagingToken(r.URL.Path)
+	if token == "" {
+		h.sendError(w, "Invalid staging token", http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := h.storage.CommitStaged(token)
+	if err != nil {
+		if errors.Is(err, storage.ErrStagedNotFound) {
+			h.sendError(w, "Staged upload not found or expired", http.StatusNotFound)
+		} else {
+			log.Printf("Failed to commit staged upload: %v", err)
+			h.sendError(w, "Failed to commit staged upload", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	replicas, ok := h.replicateNew(w, metadata, nil)
+	if !ok {
+		return
+	}
+
+	h.notify(webhook.EventUpload, metadata)
+	response := h.uploadResponse(metadata)
+	response.Replicas = replicas
+	h.sendJSON(w, response, http.StatusCreated)
+}
+
+// AbortStaged handles POST /api/v1/staged/{token}/abort, discarding a
+// staged upload
+func (h *Handler) AbortStaged(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := h.extractStagingToken(r.URL.Path)
+	if token == "" {
+		h.sendError(w, "Invalid staging token", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.storage.AbortStaged(token); err != nil {
+		if errors.Is(err, storage.ErrStagedNotFound) {
+			h.sendError(w, "Staged upload not found or expired", http.StatusNotFound)
+		} else {
+			log.Printf("Failed to abort staged upload: %v", err)
+			h.sendError(w, "Failed to abort staged upload", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// extractStagingToken extracts the token from /api/v1/staged/{token}/...
+func (h *Handler) extractStagingToken(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) >= 4 && parts[0] == "api" && parts[1] == "v1" && parts[2] == "staged" {
+		return parts[3]
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dvfs/storage-node/pkg/imaging"
+)
+
+// GetThumbnail handles GET /api/v1/files/{id}/thumbnail?w=&h=&fit=
+func (h *Handler) GetThumbnail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileID := h.extractFileID(r.URL.Path)
+	if fileID == "" {
+		h.sendError(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	width, err := parseDimension(query.Get("w"))
+	if err != nil {
+		h.sendError(w, "Invalid width: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	height, err := parseDimension(query.Get("h"))
+	if err != nil {
+		h.sendError(w, "Invalid height: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if width == 0 && height == 0 {
+		h.sendError(w, "At least one of w and h is required", http.StatusBadRequest)
+		return
+	}
+	fit, err := imaging.ParseFit(query.Get("fit"))
+	if err != nil {
+		h.sendError(w, "Invalid fit: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := h.storage.GetMetadata(fileID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.sendError(w, "File not found", http.StatusNotFound)
+		} else {
+			log.Printf("Failed to get metadata for file %s: %v", fileID, err)
+			h.sendError(w, "Failed to get file info", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	contentType := strings.TrimSpace(strings.Split(metadata.ContentType, ";")[0])
+	if !imaging.Supported(contentType) {
+		h.sendError(w, "Thumbnails are only available for PNG, JPEG and GIF images", http.StatusUnsupportedMediaType)
+		return
+	}
+	outputType := imaging.OutputType(contentType)
+	ext := ".png"
+	if outputType == "image/jpeg" {
+		ext = ".jpg"
+	}
+
+	opts := imaging.Options{Width: width, Height: height, Fit: fit, MaxPixels: h.maxPixels}
+	name := fmt.Sprintf("thumb-%dx%d-%s%s", width, height, fit, ext)
+	thumbnail, metadata, err := h.storage.Derivative(fileID, name, func(src io.Reader, w io.Writer) error {
+		return imaging.Thumbnail(src, opts, outputType, w)
+	})
+	if err != nil {
+		if h.sendScanError(w, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, imaging.ErrTooManyPixels):
+			h.sendError(w, err.Error(), http.StatusUnprocessableEntity)
+		case errors.Is(err, imaging.ErrUnsupportedFormat):
+			h.sendError(w, "File is not a decodable image", http.StatusUnprocessableEntity)
+		case strings.Contains(err.Error(), "not found"):
+			h.sendError(w, "File not found", http.StatusNotFound)
+		default:
+			log.Printf("Failed to render thumbnail of %s: %v", fileID, err)
+			h.sendError(w, "Failed to render thumbnail", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", outputType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(thumbnail)))
+	w.Header().Set("X-File-ID", metadata.ID)
+	if metadata.CacheControl != "" {
+		w.Header().Set("Cache-Control", metadata.CacheControl)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(thumbnail)
+}
+
+// parseDimension parses an optional thumbnail width or height
+func parseDimension(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > imaging.MaxDimension {
+		return 0, fmt.Errorf("must be between 1 and %d", imaging.MaxDimension)
+	}
+	return n, nil
+}
@@ -0,0 +1,85 @@
+package files
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/dvfs/storage-node/pkg/config"
+)
+
+func TestGetThumbnail(t *testing.T) {
+	h := newTestHandler(t, func(cfg *config.Config) {
+		cfg.ThumbnailMaxPixels = 300 * 300
+	})
+
+	encode := func(width, height int) string {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+			t.Fatalf("png.Encode: %v", err)
+		}
+		return buf.String()
+	}
+	picture := uploadTestFile(t, h, "picture.png", encode(300, 150), map[string]string{"Content-Type": "image/png"})
+	huge := uploadTestFile(t, h, "huge.png", encode(301, 300), map[string]string{"Content-Type": "image/png"})
+	text := uploadTestFile(t, h, "notes.txt", "not an image", nil)
+
+	get := func(fileID, query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.GetThumbnail(rec, httptest.NewRequest(http.MethodGet, "/api/v1/files/"+fileID+"/thumbnail?"+query, nil))
+		return rec
+	}
+
+	// The first request renders the thumbnail, the second serves it from
+	// the cache; both must carry exactly one image
+	var first []byte
+	for i := 0; i < 2; i++ {
+		rec := get(picture.ID, "w=100&h=100")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("thumbnail returned %d: %s", rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "image/png" {
+			t.Errorf("Content-Type = %q, want image/png", got)
+		}
+		if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(rec.Body.Len()) {
+			t.Errorf("Content-Length is %s but the body has %d bytes", got, rec.Body.Len())
+		}
+		img, err := png.Decode(bytes.NewReader(rec.Body.Bytes()))
+		if err != nil {
+			t.Fatalf("decoding thumbnail: %v", err)
+		}
+		if size := img.Bounds().Size(); size.X != 100 || size.Y != 50 {
+			t.Errorf("thumbnail is %dx%d, want 100x50", size.X, size.Y)
+		}
+		if i == 0 {
+			first = rec.Body.Bytes()
+		} else if !bytes.Equal(first, rec.Body.Bytes()) {
+			t.Error("cached thumbnail differs from the rendered one")
+		}
+	}
+
+	tests := []struct {
+		name   string
+		fileID string
+		query  string
+		status int
+	}{
+		{"no dimensions", picture.ID, "", http.StatusBadRequest},
+		{"too wide", picture.ID, "w=5000", http.StatusBadRequest},
+		{"unknown fit", picture.ID, "w=10&fit=tile", http.StatusBadRequest},
+		{"too many pixels", huge.ID, "w=10", http.StatusUnprocessableEntity},
+		{"not an image", text.ID, "w=10", http.StatusUnsupportedMediaType},
+		{"missing", "00000000-0000-0000-0000-000000000000", "w=10", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := get(tt.fileID, tt.query); rec.Code != tt.status {
+				t.Errorf("thumbnail returned %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
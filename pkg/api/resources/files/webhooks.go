@@ -0,0 +1,15 @@
+package files
+
+import (
+	"log"
+
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// notify queues a webhook event carrying the file's information. Failing to
+// queue it does not fail the request that caused it.
+func (h *Handler) notify(event string, metadata *models.FileMetadata) {
+	if err := h.webhooks.Emit(event, h.infoResponse(metadata)); err != nil {
+		log.Printf("Failed to queue %s webhook for %s: %v", event, metadata.ID, err)
+	}
+}
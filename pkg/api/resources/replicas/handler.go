@@ -0,0 +1,220 @@
+package replicas
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/replication"
+	"github.com/dvfs/storage-node/pkg/storage"
+)
+
+// Handler serves the internal endpoint peers write replicas through
+type Handler struct {
+	storage    *storage.FileStorage
+	instanceID string
+	secret     string
+	maxBytes   int64
+}
+
+// NewHandler creates a new replication handler. Peers must present secret
+// as a bearer token; with no secret every replica is refused. Replicas
+// over maxBytes are refused when it is positive.
+func NewHandler(storage *storage.FileStorage, instanceID, secret string, maxBytes int64) *Handler {
+	return &Handler{
+		storage:    storage,
+		instanceID: instanceID,
+		secret:     secret,
+		maxBytes:   maxBytes,
+	}
+}
+
+// PutReplica handles PUT /internal/v1/replicas/{id}, storing a copy of a
+// file uploaded to another node under the same ID. Repeating a replica
+// that is already stored succeeds, and a replica of another version
+// replaces the one stored, as when the file was changed or deleted and
+// uploaded again on its node.
+func (h *Handler) PutReplica(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorized(r) {
+		h.sendError(w, "Invalid replication credentials", http.StatusUnauthorized)
+		return
+	}
+
+	fileID := h.extractReplicaID(r.URL.Path)
+	if !storage.ValidID(fileID) {
+		h.sendError(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.FileUploadRequest
+	attributes, err := base64.StdEncoding.DecodeString(r.Header.Get(replication.RequestHeader))
+	if err != nil || json.Unmarshal(attributes, &req) != nil {
+		h.sendError(w, "Invalid "+replication.RequestHeader+" header", http.StatusBadRequest)
+		return
+	}
+	version := r.Header.Get(replication.VersionHeader)
+	if version == "" {
+		h.sendError(w, replication.VersionHeader+" is required", http.StatusBadRequest)
+		return
+	}
+
+	if h.maxBytes > 0 {
+		if r.ContentLength > h.maxBytes {
+			h.sendError(w, "Replica is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxBytes)
+	}
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.sendError(w, "Replica is too large", http.StatusRequestEntityTooLarge)
+		} else {
+			h.sendError(w, "Failed to read request body", http.StatusInternalServerError)
+		}
+		return
+	}
+	checksum := r.Header.Get(replication.ChecksumHeader)
+	if replication.Checksum(content) != checksum {
+		h.sendError(w, "Replica content does not match "+replication.ChecksumHeader, http.StatusBadRequest)
+		return
+	}
+
+	req.ID = fileID
+	req.Content = content
+	req.ReplicaVersion = version
+	metadata, err := h.storage.Store(&req)
+	if errors.Is(err, storage.ErrFileExists) {
+		existing, getErr := h.storage.GetMetadata(fileID)
+		if getErr == nil && existing.ReplicaVersion == version {
+			// A retried replica finds the copy its first attempt wrote
+			h.sendJSON(w, h.replicaResponse(existing), http.StatusOK)
+			return
+		}
+		if getErr == nil && existing.ReplicaVersion != "" {
+			// The file changed on its node, or was deleted there and its
+			// ID reused, since this copy was written
+			err = h.storage.Delete(fileID, existing.ETag())
+			if err == nil || strings.Contains(err.Error(), "not found") {
+				metadata, err = h.storage.Store(&req)
+			}
+		}
+	}
+	if errors.Is(err, storage.ErrFileExists) || errors.Is(err, storage.ErrPreconditionFailed) {
+		h.sendError(w, "A different file with this ID exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrInfected):
+			h.sendError(w, err.Error(), http.StatusUnprocessableEntity)
+		case errors.Is(err, storage.ErrContentTypeMismatch):
+			h.sendError(w, err.Error(), http.StatusUnsupportedMediaType)
+		default:
+			log.Printf("Failed to store replica %s: %v", fileID, err)
+			h.sendError(w, "Failed to store replica", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.sendJSON(w, h.replicaResponse(metadata), http.StatusCreated)
+}
+
+// DeleteReplica handles DELETE /internal/v1/replicas/{id}, rolling back a
+// replica of an upload that did not reach its write quorum. The required
+// If-Match keeps a later version of the file from being removed.
+func (h *Handler) DeleteReplica(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorized(r) {
+		h.sendError(w, "Invalid replication credentials", http.StatusUnauthorized)
+		return
+	}
+
+	fileID := h.extractReplicaID(r.URL.Path)
+	if fileID == "" {
+		h.sendError(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		h.sendError(w, "If-Match is required to delete a replica", http.StatusPreconditionRequired)
+		return
+	}
+
+	if err := h.storage.Delete(fileID, ifMatch); err != nil {
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			h.sendError(w, "File has been modified", http.StatusPreconditionFailed)
+		} else if strings.Contains(err.Error(), "not found") {
+			h.sendError(w, "File not found", http.StatusNotFound)
+		} else {
+			log.Printf("Failed to delete replica %s: %v", fileID, err)
+			h.sendError(w, "Failed to delete replica", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorized checks the bearer token of a peer against the shared secret
+func (h *Handler) authorized(r *http.Request) bool {
+	if h.secret == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.secret)) == 1
+}
+
+// replicaResponse builds the confirmation of a stored replica
+func (h *Handler) replicaResponse(metadata *models.FileMetadata) *models.ReplicaResponse {
+	return &models.ReplicaResponse{
+		ID:         metadata.ID,
+		InstanceID: h.instanceID,
+		Checksum:   metadata.Checksum,
+		ETag:       metadata.ETag(),
+	}
+}
+
+// extractReplicaID extracts the file ID from /internal/v1/replicas/{id}
+func (h *Handler) extractReplicaID(path string) string {
+	id := strings.TrimPrefix(path, replication.Path)
+	if id == path || strings.Contains(id, "/") {
+		return ""
+	}
+	return id
+}
+
+// sendJSON sends a JSON response
+func (h *Handler) sendJSON(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(data)
+}
+
+// sendError sends an error response
+func (h *Handler) sendError(w http.ResponseWriter, message string, statusCode int) {
+	errorResp := &models.ErrorResponse{
+		Error:   http.StatusText(statusCode),
+		Code:    statusCode,
+		Message: message,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(errorResp)
+}
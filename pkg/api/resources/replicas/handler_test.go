@@ -0,0 +1,207 @@
+package replicas
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dvfs/storage-node/pkg/config"
+	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/replication"
+	"github.com/dvfs/storage-node/pkg/storage"
+)
+
+const (
+	testSecret = "s3cret"
+	testID     = "0b0f7bb4-5b0e-4a43-9d89-0a3c4e1f7c11"
+)
+
+// newTestHandler creates a handler over a storage in a temporary directory
+// accepting replicas of up to maxBytes
+func newTestHandler(t *testing.T, maxBytes int64) (*Handler, *storage.FileStorage) {
+	t.Helper()
+	dir := t.TempDir()
+	fs, err := storage.NewFileStorage(&config.Config{
+		StoragePath:    dir,
+		StoragePaths:   []string{dir},
+		PackVolumeSize: 1 << 20,
+	})
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return NewHandler(fs, "replica", testSecret, maxBytes), fs
+}
+
+// replicaRequest builds the request a peer sends to write content as the
+// given version of fileID
+func replicaRequest(fileID, version, content string) *http.Request {
+	return attributedRequest(fileID, version, content, &models.FileUploadRequest{FileName: "notes.txt", ContentType: "text/plain"})
+}
+
+// attributedRequest builds a replica request carrying the given attributes
+func attributedRequest(fileID, version, content string, attributes *models.FileUploadRequest) *http.Request {
+	encoded, _ := json.Marshal(attributes)
+	req := httptest.NewRequest(http.MethodPut, replication.Path+fileID, strings.NewReader(content))
+	req.Header.Set(replication.RequestHeader, base64.StdEncoding.EncodeToString(encoded))
+	req.Header.Set(replication.ChecksumHeader, replication.Checksum([]byte(content)))
+	req.Header.Set(replication.VersionHeader, version)
+	req.Header.Set("Authorization", "Bearer "+testSecret)
+	return req
+}
+
+// putReplica sends a replica and returns the response
+func putReplica(h *Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.PutReplica(rec, req)
+	return rec
+}
+
+// readFile returns the content stored for fileID
+func readFile(t *testing.T, fs *storage.FileStorage, fileID string) string {
+	t.Helper()
+	content, _, err := fs.Retrieve(fileID)
+	if err != nil {
+		t.Fatalf("Retrieve: %v", err)
+	}
+	return string(content)
+}
+
+func TestPutReplicaReplacesStaleReplica(t *testing.T) {
+	h, fs := newTestHandler(t, 0)
+
+	if rec := putReplica(h, replicaRequest(testID, `"v1"`, "first")); rec.Code != http.StatusCreated {
+		t.Fatalf("first replica returned %d: %s", rec.Code, rec.Body)
+	}
+	// A retry of the same version finds it written
+	if rec := putReplica(h, replicaRequest(testID, `"v1"`, "first")); rec.Code != http.StatusOK {
+		t.Fatalf("repeated replica returned %d: %s", rec.Code, rec.Body)
+	}
+
+	// The file was changed, or deleted and uploaded again, on its node
+	rec := putReplica(h, replicaRequest(testID, `"v2"`, "second"))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("replica of a new version returned %d: %s", rec.Code, rec.Body)
+	}
+	var replica models.ReplicaResponse
+	json.NewDecoder(rec.Body).Decode(&replica)
+	if replica.Checksum != replication.Checksum([]byte("second")) {
+		t.Errorf("replica reports checksum %s, want that of the new content", replica.Checksum)
+	}
+	if got := readFile(t, fs, testID); got != "second" {
+		t.Errorf("replica holds %q, want %q", got, "second")
+	}
+
+	// A change to the attributes alone replaces the replica as well
+	rec = putReplica(h, attributedRequest(testID, `"v3"`, "second", &models.FileUploadRequest{
+		FileName:     "renamed.txt",
+		ContentType:  "text/plain",
+		Tags:         map[string]string{"k": "v"},
+		CacheControl: "no-store",
+		Appendable:   true,
+		Sealed:       true,
+	}))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("replica with new attributes returned %d: %s", rec.Code, rec.Body)
+	}
+	metadata, err := fs.GetMetadata(testID)
+	if err != nil {
+		t.Fatalf("GetMetadata: %v", err)
+	}
+	if metadata.OriginalName != "renamed.txt" || metadata.Tags["k"] != "v" || metadata.CacheControl != "no-store" || !metadata.Sealed {
+		t.Errorf("replica metadata = %+v, want the new attributes", metadata)
+	}
+	if metadata.ReplicaVersion != `"v3"` {
+		t.Errorf("replica records version %s, want \"v3\"", metadata.ReplicaVersion)
+	}
+}
+
+func TestPutReplicaKeepsLocalFile(t *testing.T) {
+	h, fs := newTestHandler(t, 0)
+	if _, err := fs.Store(&models.FileUploadRequest{ID: testID, Content: []byte("local"), FileName: "notes.txt", ContentType: "text/plain"}); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	if rec := putReplica(h, replicaRequest(testID, `"v1"`, "remote")); rec.Code != http.StatusConflict {
+		t.Fatalf("replica over a local file returned %d, want 409: %s", rec.Code, rec.Body)
+	}
+	if got := readFile(t, fs, testID); got != "local" {
+		t.Errorf("local file holds %q after a conflicting replica", got)
+	}
+}
+
+func TestPutReplicaLimits(t *testing.T) {
+	h, _ := newTestHandler(t, 10)
+	content := strings.Repeat("x", 11)
+
+	// A declared length over the cap is refused unread
+	if rec := putReplica(h, replicaRequest(testID, `"v1"`, content)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized replica returned %d, want 413: %s", rec.Code, rec.Body)
+	}
+	// An undeclared one is cut off at the cap
+	req := replicaRequest(testID, `"v1"`, content)
+	req.ContentLength = -1
+	if rec := putReplica(h, req); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized chunked replica returned %d, want 413: %s", rec.Code, rec.Body)
+	}
+	if rec := putReplica(h, replicaRequest(testID, `"v1"`, content[:10])); rec.Code != http.StatusCreated {
+		t.Errorf("replica at the cap returned %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestReplicaAuthorization(t *testing.T) {
+	h, _ := newTestHandler(t, 0)
+
+	req := replicaRequest(testID, `"v1"`, "content")
+	req.Header.Set("Authorization", "Bearer wrong")
+	if rec := putReplica(h, req); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong secret returned %d, want 401", rec.Code)
+	}
+
+	// Without a secret of its own a node accepts no replicas at all
+	h.secret = ""
+	for _, token := range []string{"", "Bearer "} {
+		req := replicaRequest(testID, `"v1"`, "content")
+		req.Header.Set("Authorization", token)
+		if rec := putReplica(h, req); rec.Code != http.StatusUnauthorized {
+			t.Errorf("node without a secret returned %d for Authorization %q, want 401", rec.Code, token)
+		}
+	}
+}
+
+func TestDeleteReplicaRequiresIfMatch(t *testing.T) {
+	h, fs := newTestHandler(t, 0)
+	rec := putReplica(h, replicaRequest(testID, `"v1"`, "content"))
+	var replica models.ReplicaResponse
+	json.NewDecoder(rec.Body).Decode(&replica)
+
+	remove := func(ifMatch string) int {
+		req := httptest.NewRequest(http.MethodDelete, replication.Path+testID, nil)
+		req.Header.Set("Authorization", "Bearer "+testSecret)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.DeleteReplica(rec, req)
+		return rec.Code
+	}
+
+	if code := remove(""); code != http.StatusPreconditionRequired {
+		t.Errorf("delete without If-Match returned %d, want 428", code)
+	}
+	if code := remove(`"stale"`); code != http.StatusPreconditionFailed {
+		t.Errorf("delete of another version returned %d, want 412", code)
+	}
+	if !fs.Exists(testID) {
+		t.Fatal("refused deletes removed the replica")
+	}
+	if code := remove(replica.ETag); code != http.StatusNoContent {
+		t.Errorf("delete of the written version returned %d, want 204", code)
+	}
+	if code := remove(replica.ETag); code != http.StatusNotFound {
+		t.Errorf("repeated delete returned %d, want 404", code)
+	}
+}
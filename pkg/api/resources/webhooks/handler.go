@@ -0,0 +1,185 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/webhook"
+)
+
+// Sizes of a delivery log page
+const (
+	defaultDeliveries = 100
+	maxDeliveries     = 1000
+)
+
+// Handler manages webhook subscriptions
+type Handler struct {
+	dispatcher *webhook.Dispatcher
+}
+
+// NewHandler creates a new webhooks handler
+func NewHandler(dispatcher *webhook.Dispatcher) *Handler {
+	return &Handler{
+		dispatcher: dispatcher,
+	}
+}
+
+// ListWebhooks handles GET /api/v1/webhooks
+func (h *Handler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	subs := h.dispatcher.Subscriptions()
+	h.sendJSON(w, &models.WebhookListResponse{Webhooks: subs, Count: len(subs)}, http.StatusOK)
+}
+
+// CreateWebhook handles POST /api/v1/webhooks. The response is the only
+// place the subscription's secret is shown.
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	sub, err := h.dispatcher.Subscribe(&webhook.Subscription{
+		ID:     req.ID,
+		URL:    req.URL,
+		Events: req.Events,
+		Secret: req.Secret,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, webhook.ErrExists):
+			h.sendError(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, webhook.ErrInvalidID), errors.Is(err, webhook.ErrInvalidURL), errors.Is(err, webhook.ErrInvalidEvent):
+			h.sendError(w, err.Error(), http.StatusBadRequest)
+		default:
+			log.Printf("Failed to create webhook: %v", err)
+			h.sendError(w, "Failed to create webhook", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/webhooks/"+sub.ID)
+	h.sendJSON(w, sub, http.StatusCreated)
+}
+
+// GetWebhook handles GET /api/v1/webhooks/{id}
+func (h *Handler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sub, err := h.dispatcher.Subscription(h.extractWebhookID(r.URL.Path))
+	if err != nil {
+		h.sendError(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	h.sendJSON(w, sub, http.StatusOK)
+}
+
+// DeleteWebhook handles DELETE /api/v1/webhooks/{id}. Subscriptions from
+// the subscriptions file can only be removed by editing it.
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.dispatcher.Unsubscribe(h.extractWebhookID(r.URL.Path)); err != nil {
+		switch {
+		case errors.Is(err, webhook.ErrNotFound):
+			h.sendError(w, "Webhook not found", http.StatusNotFound)
+		case errors.Is(err, webhook.ErrStatic):
+			h.sendError(w, err.Error(), http.StatusConflict)
+		default:
+			log.Printf("Failed to delete webhook: %v", err)
+			h.sendError(w, "Failed to delete webhook", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDeliveries handles GET /api/v1/webhooks/{id}/deliveries?limit=N,
+// returning the most recent delivery attempts first
+func (h *Handler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := defaultDeliveries
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxDeliveries {
+			h.sendError(w, fmt.Sprintf("limit must be between 1 and %d", maxDeliveries), http.StatusBadRequest)
+			return
+		}
+	}
+
+	attempts, err := h.dispatcher.Deliveries(h.extractWebhookID(r.URL.Path), limit)
+	if err != nil {
+		if errors.Is(err, webhook.ErrNotFound) {
+			h.sendError(w, "Webhook not found", http.StatusNotFound)
+		} else {
+			log.Printf("Failed to read webhook deliveries: %v", err)
+			h.sendError(w, "Failed to read webhook deliveries", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if attempts == nil {
+		attempts = []webhook.Attempt{}
+	}
+	h.sendJSON(w, &models.WebhookDeliveriesResponse{Deliveries: attempts, Count: len(attempts)}, http.StatusOK)
+}
+
+// extractWebhookID extracts the subscription ID from
+// /api/v1/webhooks/{id}[/deliveries]
+func (h *Handler) extractWebhookID(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 4 {
+		return ""
+	}
+	return parts[3]
+}
+
+// sendJSON sends a JSON response
+func (h *Handler) sendJSON(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(data)
+}
+
+// sendError sends an error response
+func (h *Handler) sendError(w http.ResponseWriter, message string, statusCode int) {
+	errorResp := &models.ErrorResponse{
+		Error:   http.StatusText(statusCode),
+		Code:    statusCode,
+		Message: message,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(errorResp)
+}
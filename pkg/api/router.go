@@ -4,25 +4,45 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/dvfs/storage-node/pkg/api/resources/archive"
+	"github.com/dvfs/storage-node/pkg/api/resources/changes"
 	"github.com/dvfs/storage-node/pkg/api/resources/files"
+	"github.com/dvfs/storage-node/pkg/api/resources/replicas"
+	"github.com/dvfs/storage-node/pkg/api/resources/webhooks"
+	"github.com/dvfs/storage-node/pkg/config"
+	"github.com/dvfs/storage-node/pkg/idempotency"
+	"github.com/dvfs/storage-node/pkg/policy"
+	"github.com/dvfs/storage-node/pkg/replication"
 	"github.com/dvfs/storage-node/pkg/storage"
+	"github.com/dvfs/storage-node/pkg/webhook"
 )
 
 // Router handles all API routing
 type Router struct {
-	filesHandler *files.Handler
-	instanceID   string
-	startTime    time.Time
+	storage        *storage.FileStorage
+	filesHandler   *files.Handler
+	archiveHandler *archive.Handler
+	changesHandler *changes.Handler
+	webhookHandler *webhooks.Handler
+	replicaHandler *replicas.Handler
+	instanceID     string
+	startTime      time.Time
 }
 
 // NewRouter creates a new API router
-func NewRouter(storage *storage.FileStorage, instanceID string) *Router {
+func NewRouter(storage *storage.FileStorage, uploadPolicy *policy.Engine, idempotencyKeys *idempotency.Store, dispatcher *webhook.Dispatcher, replicator *replication.Replicator, cfg *config.Config) *Router {
 	return &Router{
-		filesHandler: files.NewHandler(storage),
-		instanceID:   instanceID,
-		startTime:    time.Now(),
+		storage:        storage,
+		filesHandler:   files.NewHandler(storage, uploadPolicy, idempotencyKeys, dispatcher, replicator, cfg),
+		archiveHandler: archive.NewHandler(storage),
+		changesHandler: changes.NewHandler(storage.Changes()),
+		webhookHandler: webhooks.NewHandler(dispatcher),
+		replicaHandler: replicas.NewHandler(storage, cfg.InstanceID, cfg.ReplicationSecret, cfg.ReplicaMaxBytes),
+		instanceID:     cfg.InstanceID,
+		startTime:      time.Now(),
 	}
 }
 
@@ -33,13 +53,24 @@ func (r *Router) Routes() http.Handler {
 	// API v1 routes
 	mux.HandleFunc("/api/v1/files", r.handleFiles)
 	mux.HandleFunc("/api/v1/files/", r.handleFilesWithID)
-	
+	mux.HandleFunc("/api/v1/compose", r.filesHandler.ComposeFiles)
+	mux.HandleFunc("/api/v1/staged/", r.handleStaged)
+	mux.HandleFunc("/api/v1/inventory", r.filesHandler.Inventory)
+	mux.HandleFunc("/api/v1/reconcile", r.filesHandler.Reconcile)
+	mux.HandleFunc("/api/v1/changes", r.changesHandler.ListChanges)
+	mux.HandleFunc("/api/v1/webhooks", r.handleWebhooks)
+	mux.HandleFunc("/api/v1/webhooks/", r.handleWebhooksWithID)
+	mux.HandleFunc("/api/v1/archive", r.archiveHandler.CreateArchive)
+
+	// Internal routes used by peer nodes
+	mux.HandleFunc(replication.Path, r.handleReplicas)
+
 	// Instance-specific routes
 	mux.HandleFunc("/api/v1/instance", r.getInstanceInfo)
-	
+
 	// Health check
 	mux.HandleFunc("/health", r.healthCheck)
-	
+
 	// Root endpoint
 	mux.HandleFunc("/", r.rootHandler)
 
@@ -49,6 +80,8 @@ func (r *Router) Routes() http.Handler {
 // handleFiles routes requests to /api/v1/files
 func (r *Router) handleFiles(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
+	case http.MethodGet:
+		r.filesHandler.ListFiles(w, req)
 	case http.MethodPost:
 		r.filesHandler.UploadFile(w, req)
 	default:
@@ -56,10 +89,11 @@ func (r *Router) handleFiles(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-// handleFilesWithID routes requests to /api/v1/files/{id} and /api/v1/files/{id}/info
+// handleFilesWithID routes requests to /api/v1/files/{id} and its sub-resources
 func (r *Router) handleFilesWithID(w http.ResponseWriter, req *http.Request) {
-	// Check if it's an info request
-	if r.isInfoRequest(req.URL.Path) {
+	switch r.subresource(req.URL.Path) {
+	case "":
+	case "info":
 		switch req.Method {
 		case http.MethodGet:
 			r.filesHandler.GetFileInfo(w, req)
@@ -67,12 +101,48 @@ func (r *Router) handleFilesWithID(w http.ResponseWriter, req *http.Request) {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
 		return
+	case "metadata":
+		switch req.Method {
+		case http.MethodPatch:
+			r.filesHandler.UpdateFileMetadata(w, req)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	case "copy":
+		r.filesHandler.CopyFile(w, req)
+		return
+	case "slice":
+		r.filesHandler.SliceFile(w, req)
+		return
+	case "append":
+		r.filesHandler.AppendFile(w, req)
+		return
+	case "seal":
+		r.filesHandler.SealFile(w, req)
+		return
+	case "thumbnail":
+		r.filesHandler.GetThumbnail(w, req)
+		return
+	case "signature":
+		r.filesHandler.GetSignature(w, req)
+		return
+	case "delta":
+		r.filesHandler.ApplyDelta(w, req)
+		return
+	default:
+		http.NotFound(w, req)
+		return
 	}
 
 	// Regular file operations
 	switch req.Method {
 	case http.MethodGet:
 		r.filesHandler.GetFile(w, req)
+	case http.MethodPut:
+		r.filesHandler.PutFile(w, req)
+	case http.MethodPatch:
+		r.filesHandler.UpdateFile(w, req)
 	case http.MethodDelete:
 		r.filesHandler.DeleteFile(w, req)
 	case http.MethodHead:
@@ -82,6 +152,69 @@ func (r *Router) handleFilesWithID(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// handleStaged routes requests to /api/v1/staged/{token}/{action}
+func (r *Router) handleStaged(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) != 5 {
+		http.NotFound(w, req)
+		return
+	}
+
+	switch parts[4] {
+	case "commit":
+		r.filesHandler.CommitStaged(w, req)
+	case "abort":
+		r.filesHandler.AbortStaged(w, req)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// handleWebhooks routes requests to /api/v1/webhooks
+func (r *Router) handleWebhooks(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		r.webhookHandler.ListWebhooks(w, req)
+	case http.MethodPost:
+		r.webhookHandler.CreateWebhook(w, req)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleWebhooksWithID routes requests to /api/v1/webhooks/{id} and its
+// delivery log
+func (r *Router) handleWebhooksWithID(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 4:
+		switch req.Method {
+		case http.MethodGet:
+			r.webhookHandler.GetWebhook(w, req)
+		case http.MethodDelete:
+			r.webhookHandler.DeleteWebhook(w, req)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	case len(parts) == 5 && parts[4] == "deliveries":
+		r.webhookHandler.ListDeliveries(w, req)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// handleReplicas routes requests to /internal/v1/replicas/{id}
+func (r *Router) handleReplicas(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPut:
+		r.replicaHandler.PutReplica(w, req)
+	case http.MethodDelete:
+		r.replicaHandler.DeleteReplica(w, req)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 // getInstanceInfo handles GET /api/v1/instance
 func (r *Router) getInstanceInfo(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodGet {
@@ -90,16 +223,17 @@ func (r *Router) getInstanceInfo(w http.ResponseWriter, req *http.Request) {
 	}
 
 	uptime := time.Since(r.startTime)
-	
+
 	instanceInfo := map[string]interface{}{
 		"instance_id": r.instanceID,
 		"service":     "storage-node",
 		"version":     "1.0.0",
 		"uptime":      uptime.String(),
 		"started_at":  r.startTime.Format(time.RFC3339),
+		"disks":       r.storage.Disks(),
 		"endpoints": map[string]string{
-			"files":   "/api/v1/files",
-			"health":  "/health",
+			"files":    "/api/v1/files",
+			"health":   "/health",
 			"instance": "/api/v1/instance",
 		},
 	}
@@ -109,9 +243,14 @@ func (r *Router) getInstanceInfo(w http.ResponseWriter, req *http.Request) {
 	json.NewEncoder(w).Encode(instanceInfo)
 }
 
-// isInfoRequest checks if the request is for file info
-func (r *Router) isInfoRequest(path string) bool {
-	return len(path) > 5 && path[len(path)-5:] == "/info"
+// subresource returns the segment following the file ID in
+// /api/v1/files/{id}/{subresource}, or "" for the file itself
+func (r *Router) subresource(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 5 {
+		return ""
+	}
+	return strings.Join(parts[4:], "/")
 }
 
 // healthCheck handles GET /health
@@ -122,9 +261,15 @@ func (r *Router) healthCheck(w http.ResponseWriter, req *http.Request) {
 	}
 
 	uptime := time.Since(r.startTime)
-	
+
+	// A degraded disk is reported but does not fail the health check
+	status := "healthy"
+	if r.storage.Degraded() {
+		status = "degraded"
+	}
+
 	healthInfo := map[string]interface{}{
-		"status":      "healthy",
+		"status":      status,
 		"service":     "storage-node",
 		"instance_id": r.instanceID,
 		"uptime":      uptime.String(),
@@ -149,8 +294,30 @@ func (r *Router) rootHandler(w http.ResponseWriter, req *http.Request) {
 		"instance_id": r.instanceID,
 		"endpoints": map[string]string{
 			"upload":    "POST /api/v1/files",
+			"list":      "GET /api/v1/files?tag=key=value",
 			"download":  "GET /api/v1/files/{id}",
+			"put":       "PUT /api/v1/files/{id}",
+			"stage":     "POST /api/v1/files?stage=1",
+			"commit":    "POST /api/v1/staged/{token}/commit",
+			"abort":     "POST /api/v1/staged/{token}/abort",
 			"info":      "GET /api/v1/files/{id}/info",
+			"update":    "PATCH /api/v1/files/{id}",
+			"metadata":  "PATCH /api/v1/files/{id}/metadata",
+			"copy":      "POST /api/v1/files/{id}/copy",
+			"slice":     "POST /api/v1/files/{id}/slice",
+			"compose":   "POST /api/v1/compose",
+			"append":    "POST /api/v1/files/{id}/append",
+			"seal":      "POST /api/v1/files/{id}/seal",
+			"thumbnail": "GET /api/v1/files/{id}/thumbnail?w=&h=&fit=",
+			"signature": "GET /api/v1/files/{id}/signature",
+			"delta":     "POST /api/v1/files/{id}/delta",
+			"archive":   "POST /api/v1/archive",
+			"inventory": "GET /api/v1/inventory?since=",
+			"reconcile": "POST /api/v1/reconcile",
+			"changes":   "GET /api/v1/changes?since=&wait=",
+			"webhooks":  "GET|POST /api/v1/webhooks",
+			"webhook":   "GET|DELETE /api/v1/webhooks/{id}",
+			"delivery":  "GET /api/v1/webhooks/{id}/deliveries?limit=",
 			"delete":    "DELETE /api/v1/files/{id}",
 			"exists":    "HEAD /api/v1/files/{id}",
 			"health":    "GET /health",
@@ -0,0 +1,185 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrLimitExceeded is returned when an archive has too many entries or
+// expands to more data than allowed
+var ErrLimitExceeded = errors.New("archive exceeds extraction limits")
+
+// ErrUnsafePath is returned for entries whose path escapes the archive root
+var ErrUnsafePath = errors.New("archive entry has an unsafe path")
+
+// Limits bound the work done unpacking an untrusted archive. Zero values
+// disable the corresponding check.
+type Limits struct {
+	// MaxEntries caps the number of entries, including directories
+	MaxEntries int
+	// MaxBytes caps the total expanded size of all files
+	MaxBytes int64
+	// MaxRatio caps the expanded size relative to the archive size
+	MaxRatio float64
+}
+
+// Entry describes a regular file read from an archive
+type Entry struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Extract unpacks the archive in data and calls fn with every regular file
+// and a reader over its content. Directories, links and other special
+// entries are skipped. Limits are enforced on the bytes actually
+// decompressed, not on the sizes the archive declares.
+func Extract(format string, data []byte, limits Limits, fn func(Entry, io.Reader) error) error {
+	budget := &budget{remaining: -1}
+	if limits.MaxBytes > 0 {
+		budget.remaining = limits.MaxBytes
+	}
+	if limits.MaxRatio > 0 {
+		byRatio := int64(limits.MaxRatio * float64(len(data)))
+		if budget.remaining < 0 || byRatio < budget.remaining {
+			budget.remaining = byRatio
+		}
+	}
+
+	switch format {
+	case FormatZip:
+		return extractZip(data, limits, budget, fn)
+	case FormatTar:
+		return extractTar(bytes.NewReader(data), limits, budget, fn)
+	case FormatTarGz:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("invalid gzip stream: %w", err)
+		}
+		defer gz.Close()
+		return extractTar(gz, limits, budget, fn)
+	default:
+		return fmt.Errorf("unsupported archive format %q", format)
+	}
+}
+
+func extractZip(data []byte, limits Limits, budget *budget, fn func(Entry, io.Reader) error) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("invalid zip archive: %w", err)
+	}
+	if limits.MaxEntries > 0 && len(zr.File) > limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrLimitExceeded, limits.MaxEntries)
+	}
+
+	// Reject on declared sizes first so obvious bombs cost nothing
+	var declared uint64
+	for _, file := range zr.File {
+		declared += file.UncompressedSize64
+	}
+	if err := budget.check(declared); err != nil {
+		return err
+	}
+
+	for _, file := range zr.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		name, err := entryPath(file.Name)
+		if err != nil {
+			return err
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", file.Name, err)
+		}
+		entry := Entry{Path: name, Size: int64(file.UncompressedSize64), ModTime: file.Modified}
+		err = fn(entry, &budgetReader{reader: rc, budget: budget})
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTar(r io.Reader, limits Limits, budget *budget, fn func(Entry, io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for entries := 1; ; entries++ {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar archive: %w", err)
+		}
+		if limits.MaxEntries > 0 && entries > limits.MaxEntries {
+			return fmt.Errorf("%w: more than %d entries", ErrLimitExceeded, limits.MaxEntries)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name, err := entryPath(header.Name)
+		if err != nil {
+			return err
+		}
+
+		entry := Entry{Path: name, Size: header.Size, ModTime: header.ModTime}
+		if err := fn(entry, &budgetReader{reader: tr, budget: budget}); err != nil {
+			return err
+		}
+	}
+}
+
+// entryPath validates an entry name, rejecting paths that would escape the
+// root if the archive were unpacked onto a filesystem
+func entryPath(name string) (string, error) {
+	clean := CleanPath(name)
+	if clean == "" {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	return clean, nil
+}
+
+// budget tracks how many more expanded bytes may be read; negative means
+// unlimited
+type budget struct {
+	remaining int64
+}
+
+// check fails if n bytes would not fit in the budget
+func (b *budget) check(n uint64) error {
+	if b.remaining >= 0 && n > uint64(b.remaining) {
+		return fmt.Errorf("%w: expands to more than %d bytes", ErrLimitExceeded, b.remaining)
+	}
+	return nil
+}
+
+// budgetReader charges every byte read against a shared budget
+type budgetReader struct {
+	reader io.Reader
+	budget *budget
+}
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	if r.budget.remaining < 0 {
+		return r.reader.Read(p)
+	}
+	// Read one byte past the budget so overflow is detected
+	if int64(len(p)) > r.budget.remaining+1 {
+		p = p[:r.budget.remaining+1]
+	}
+	n, err := r.reader.Read(p)
+	if int64(n) > r.budget.remaining {
+		return 0, fmt.Errorf("%w: expanded size over budget", ErrLimitExceeded)
+	}
+	r.budget.remaining -= int64(n)
+	return n, err
+}
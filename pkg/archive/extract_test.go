@@ -0,0 +1,149 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+)
+
+// testFile is a name and content to put in a test archive
+type testFile struct {
+	name    string
+	content string
+}
+
+func zipOf(t *testing.T, files ...testFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, file := range files {
+		w, err := zw.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, file.content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarOf(t *testing.T, gzipped bool, files ...testFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if gzipped {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for _, file := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, file.content)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		gz.Close()
+	}
+	return buf.Bytes()
+}
+
+// extractAll returns the content of every file extracted from data
+func extractAll(format string, data []byte, limits Limits) (map[string]string, error) {
+	files := make(map[string]string)
+	err := Extract(format, data, limits, func(entry Entry, r io.Reader) error {
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		files[entry.Path] = string(content)
+		return nil
+	})
+	return files, err
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := map[string]string{
+		"a/b.txt":        "a/b.txt",
+		"/etc/passwd":    "etc/passwd",
+		"a//./b.txt":     "a/b.txt",
+		`dir\file.txt`:   "dir/file.txt",
+		"../evil.txt":    "",
+		"a/../../evil":   "",
+		`a\..\..\evil`:   "",
+		"a/..":           "",
+		".":              "",
+		"":               "",
+		"a/..b/file.txt": "a/..b/file.txt",
+	}
+	for name, want := range tests {
+		if got := CleanPath(name); got != want {
+			t.Errorf("CleanPath(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestExtractRejectsUnsafePaths(t *testing.T) {
+	for _, name := range []string{"../evil.txt", "dir/../../evil.txt", `..\evil.txt`} {
+		for format, data := range map[string][]byte{
+			FormatZip: zipOf(t, testFile{"ok.txt", "ok"}, testFile{name, "evil"}),
+			FormatTar: tarOf(t, false, testFile{"ok.txt", "ok"}, testFile{name, "evil"}),
+		} {
+			if _, err := extractAll(format, data, Limits{}); !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("%s entry %q returned %v, want ErrUnsafePath", format, name, err)
+			}
+		}
+	}
+
+	// Absolute paths are made relative rather than refused
+	files, err := extractAll(FormatZip, zipOf(t, testFile{"/abs/file.txt", "x"}), Limits{})
+	if err != nil || files["abs/file.txt"] != "x" {
+		t.Errorf("absolute entry extracted as %v, %v", files, err)
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	three := []testFile{{"a.txt", "aaaa"}, {"b.txt", "bbbb"}, {"c.txt", "cccc"}}
+	zeros := testFile{"zeros.bin", string(make([]byte, 1<<20))}
+
+	tests := []struct {
+		name   string
+		format string
+		data   []byte
+		limits Limits
+		ok     bool
+	}{
+		{"zip entries at the limit", FormatZip, zipOf(t, three...), Limits{MaxEntries: 3}, true},
+		{"zip too many entries", FormatZip, zipOf(t, three...), Limits{MaxEntries: 2}, false},
+		{"tar too many entries", FormatTar, tarOf(t, false, three...), Limits{MaxEntries: 2}, false},
+		{"zip bytes at the limit", FormatZip, zipOf(t, three...), Limits{MaxBytes: 12}, true},
+		{"zip too many bytes", FormatZip, zipOf(t, three...), Limits{MaxBytes: 11}, false},
+		// Tar declares no total up front, so these are caught on the bytes
+		// actually decompressed
+		{"tar too many bytes", FormatTar, tarOf(t, false, three...), Limits{MaxBytes: 11}, false},
+		{"tar.gz bomb by ratio", FormatTarGz, tarOf(t, true, zeros), Limits{MaxRatio: 10}, false},
+		{"tar.gz within ratio", FormatTarGz, tarOf(t, true, zeros), Limits{MaxRatio: 10000}, true},
+		{"zip bomb by ratio", FormatZip, zipOf(t, zeros), Limits{MaxRatio: 10}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := extractAll(tt.format, tt.data, tt.limits)
+			if tt.ok && err != nil {
+				t.Errorf("Extract returned %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrLimitExceeded) {
+				t.Errorf("Extract returned %v, want ErrLimitExceeded", err)
+			}
+		})
+	}
+}
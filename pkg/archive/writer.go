@@ -0,0 +1,176 @@
+// Package archive builds ZIP and tar archives as streams and unpacks
+// untrusted uploads
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported archive formats
+const (
+	FormatZip   = "zip"
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+)
+
+// Writer adds files to an archive written to an underlying stream
+type Writer interface {
+	// Add appends a file read from r, which must yield exactly size bytes
+	Add(name string, size int64, modTime time.Time, r io.Reader) error
+	// Close writes the archive trailer; it does not close the stream
+	Close() error
+}
+
+// ParseFormat normalizes a format name such as "tgz" or "ZIP"
+func ParseFormat(format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "", FormatZip:
+		return FormatZip, nil
+	case FormatTar:
+		return FormatTar, nil
+	case FormatTarGz, "tgz":
+		return FormatTarGz, nil
+	default:
+		return "", fmt.Errorf("unsupported archive format %q", format)
+	}
+}
+
+// ContentType returns the MIME type of an archive format
+func ContentType(format string) string {
+	switch format {
+	case FormatTar:
+		return "application/x-tar"
+	case FormatTarGz:
+		return "application/gzip"
+	default:
+		return "application/zip"
+	}
+}
+
+// Extension returns the file extension of an archive format
+func Extension(format string) string {
+	return "." + format
+}
+
+// NewWriter starts an archive of the given format on w
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatZip:
+		return &zipWriter{zw: zip.NewWriter(w)}, nil
+	case FormatTar:
+		return &tarWriter{tw: tar.NewWriter(w)}, nil
+	case FormatTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarWriter{tw: tar.NewWriter(gz), gz: gz}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format %q", format)
+	}
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) Add(name string, size int64, modTime time.Time, r io.Reader) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	}
+	header.SetMode(0644)
+
+	w, err := z.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	return copyExactly(w, r, size)
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
+
+type tarWriter struct {
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+func (t *tarWriter) Add(name string, size int64, modTime time.Time, r io.Reader) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	}
+	if err := t.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	return copyExactly(t.tw, r, size)
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	if t.gz != nil {
+		return t.gz.Close()
+	}
+	return nil
+}
+
+// copyExactly copies size bytes and fails if r is shorter
+func copyExactly(w io.Writer, r io.Reader, size int64) error {
+	n, err := io.CopyN(w, r, size)
+	if err == io.EOF {
+		return fmt.Errorf("short read: got %d of %d bytes", n, size)
+	}
+	return err
+}
+
+// CleanPath turns a client-supplied path into a safe relative archive path.
+// It returns "" for paths that are empty or escape the archive root.
+func CleanPath(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return ""
+		}
+	}
+	name = strings.TrimLeft(path.Clean("/"+name), "/")
+	if name == "." {
+		return ""
+	}
+	return name
+}
+
+// UniqueNames hands out archive paths, renaming duplicates to
+// "name (1).ext", "name (2).ext" and so on
+type UniqueNames map[string]bool
+
+// Claim returns name or the first free variant of it
+func (u UniqueNames) Claim(name string) string {
+	if !u[name] {
+		u[name] = true
+		return name
+	}
+
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := stem + " (" + strconv.Itoa(i) + ")" + ext
+		if !u[candidate] {
+			u[candidate] = true
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFormat(t *testing.T) {
+	for input, want := range map[string]string{
+		"":       FormatZip,
+		"ZIP":    FormatZip,
+		"tar":    FormatTar,
+		"tar.gz": FormatTarGz,
+		"TGZ":    FormatTarGz,
+	} {
+		if got, err := ParseFormat(input); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("rar"); err == nil {
+		t.Error("ParseFormat accepted rar")
+	}
+}
+
+func TestUniqueNames(t *testing.T) {
+	names := make(UniqueNames)
+	var got []string
+	for _, name := range []string{"a.txt", "a.txt", "b", "a.txt", "b", "a (1).txt"} {
+		got = append(got, names.Claim(name))
+	}
+	want := "a.txt,a (1).txt,b,a (2).txt,b (1),a (1) (1).txt"
+	if strings.Join(got, ",") != want {
+		t.Errorf("claimed %v, want %s", got, want)
+	}
+}
+
+// writeArchive builds an archive of format holding the given name and
+// content pairs
+func writeArchive(t *testing.T, format string, files ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	aw, err := NewWriter(format, &buf)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	for i := 0; i < len(files); i += 2 {
+		content := files[i+1]
+		if err := aw.Add(files[i], int64(len(content)), time.Now(), strings.NewReader(content)); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// readArchive returns the name and content pairs of an archive
+func readArchive(t *testing.T, format string, data []byte) []string {
+	t.Helper()
+	var files []string
+	if format == FormatZip {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("zip.NewReader: %v", err)
+		}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("opening %s: %v", f.Name, err)
+			}
+			content, _ := io.ReadAll(rc)
+			rc.Close()
+			files = append(files, f.Name, string(content))
+		}
+		return files
+	}
+
+	var r io.Reader = bytes.NewReader(data)
+	if format == FormatTarGz {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatalf("gzip.NewReader: %v", err)
+		}
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatalf("reading tar: %v", err)
+		}
+		content, _ := io.ReadAll(tr)
+		files = append(files, header.Name, string(content))
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	files := []string{"a.txt", "alpha", "docs/b.txt", "beta", "empty", ""}
+	for _, format := range []string{FormatZip, FormatTar, FormatTarGz} {
+		t.Run(format, func(t *testing.T) {
+			got := readArchive(t, format, writeArchive(t, format, files...))
+			if strings.Join(got, "|") != strings.Join(files, "|") {
+				t.Errorf("archive holds %q, want %q", got, files)
+			}
+		})
+	}
+}
+
+func TestWriterRejectsShortContent(t *testing.T) {
+	for _, format := range []string{FormatZip, FormatTar, FormatTarGz} {
+		aw, err := NewWriter(format, io.Discard)
+		if err != nil {
+			t.Fatalf("NewWriter: %v", err)
+		}
+		if err := aw.Add("a.txt", 10, time.Now(), strings.NewReader("short")); err == nil {
+			t.Errorf("%s: Add of fewer bytes than declared succeeded", format)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+// Package changes keeps a durable, ordered log of the files created,
+// updated and deleted on a node, so that other services can follow what
+// changed by sequence number
+package changes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	Create = "create"
+	Update = "update"
+	Delete = "delete"
+)
+
+// segmentEvents is the number of events written to a segment file before a
+// new one is started; retention drops whole segments, so a segment is also
+// closed once its first event falls out of the retention window
+const segmentEvents = 10000
+
+// segmentExt is the extension of segment files, which are named after the
+// sequence number of their first event
+const segmentExt = ".log"
+
+// Errors returned for cursors the log cannot continue from
+var (
+	ErrCursorExpired = errors.New("cursor is older than the retained change log")
+	ErrCursorAhead   = errors.New("cursor is ahead of the change log")
+)
+
+// Event records one change to a file
+type Event struct {
+	Seq    uint64 `json:"seq"`
+	Type   string `json:"type"`
+	FileID string `json:"file_id"`
+	// ETag is the version of the file after the change; empty for deletes
+	ETag string    `json:"etag,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// Log appends events to segment files of newline-delimited JSON. Sequence
+// numbers start at 1 and increase by one per event.
+type Log struct {
+	dir       string
+	retention time.Duration
+
+	mu       sync.Mutex
+	segments []uint64 // first sequence number of each segment, ascending
+	file     *os.File // the last segment, open for appending
+	size     int64
+	count    int
+	started  time.Time // time of the first event in the last segment
+	last     uint64
+	notify   chan struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// Open opens the log in dir, recovering from a partially written event.
+// Segments whose events are all older than retention are removed
+// periodically; a retention of zero keeps every event.
+func Open(dir string, retention time.Duration) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create change log directory: %w", err)
+	}
+
+	l := &Log{
+		dir:       dir,
+		retention: retention,
+		notify:    make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+
+	if retention > 0 {
+		l.wg.Add(1)
+		go l.expire()
+	}
+	return l, nil
+}
+
+// load finds the segments in the directory and opens the last one
+func (l *Log) load() error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list change log: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil || first == 0 {
+			continue
+		}
+		l.segments = append(l.segments, first)
+	}
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i] < l.segments[j] })
+
+	if len(l.segments) == 0 {
+		return nil
+	}
+	first := l.segments[len(l.segments)-1]
+	return l.openSegment(first)
+}
+
+// openSegment opens the segment starting at first for appending, dropping
+// a trailing event that was only partially written
+func (l *Log) openSegment(first uint64) error {
+	path := l.segmentPath(first)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open change log segment: %w", err)
+	}
+
+	l.last = first - 1
+	l.count = 0
+	l.started = time.Time{}
+	var size int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		var event Event
+		if json.Unmarshal(line, &event) != nil || event.Seq != l.last+1 {
+			break
+		}
+		if l.count == 0 {
+			l.started = event.Time
+		}
+		l.last = event.Seq
+		l.count++
+		size += int64(len(line))
+	}
+
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to repair change log segment: %w", err)
+	}
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = size
+	return nil
+}
+
+// Append records a change and wakes up waiting readers
+func (l *Log) Append(eventType, fileID, etag string) (Event, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil || l.count >= segmentEvents || l.aged(time.Now()) {
+		if err := l.roll(); err != nil {
+			return Event{}, err
+		}
+	}
+
+	event := Event{
+		Seq:    l.last + 1,
+		Type:   eventType,
+		FileID: fileID,
+		ETag:   etag,
+		Time:   time.Now().UTC(),
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return Event{}, err
+	}
+	line = append(line, '\n')
+
+	if _, err := l.file.Write(line); err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		// Cut off whatever part of the event made it to disk
+		l.file.Truncate(l.size)
+		l.file.Seek(l.size, io.SeekStart)
+		return Event{}, fmt.Errorf("failed to append change: %w", err)
+	}
+
+	l.size += int64(len(line))
+	if l.count == 0 {
+		l.started = event.Time
+	}
+	l.count++
+	l.last = event.Seq
+	close(l.notify)
+	l.notify = make(chan struct{})
+	return event, nil
+}
+
+// roll starts a new segment after the last event
+func (l *Log) roll() error {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	first := l.last + 1
+	if err := l.openSegment(first); err != nil {
+		return err
+	}
+	if len(l.segments) == 0 || l.segments[len(l.segments)-1] != first {
+		l.segments = append(l.segments, first)
+	}
+	return nil
+}
+
+// aged reports whether the first event of the last segment is past the
+// retention window, so the segment must be closed for retention to drop it
+func (l *Log) aged(now time.Time) bool {
+	return l.retention > 0 && l.count > 0 && now.Sub(l.started) > l.retention
+}
+
+// Last returns the sequence number of the newest event, 0 if there is none
+func (l *Log) Last() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+// Since returns up to limit events following the cursor seq, oldest first.
+// It returns ErrCursorExpired if events after seq were already dropped by
+// retention, and ErrCursorAhead if seq is beyond the newest event, e.g.
+// because the log was reset.
+func (l *Log) Since(seq uint64, limit int) ([]Event, error) {
+	l.mu.Lock()
+	last := l.last
+	segments := append([]uint64(nil), l.segments...)
+	l.mu.Unlock()
+
+	if seq > last {
+		return nil, ErrCursorAhead
+	}
+	if seq == last {
+		return nil, nil
+	}
+	if len(segments) == 0 || seq+1 < segments[0] {
+		return nil, ErrCursorExpired
+	}
+
+	// Start from the last segment that can hold the event after seq
+	start := sort.Search(len(segments), func(i int) bool { return segments[i] > seq+1 }) - 1
+
+	var events []Event
+	for _, first := range segments[start:] {
+		data, err := os.ReadFile(l.segmentPath(first))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, ErrCursorExpired
+			}
+			return nil, fmt.Errorf("failed to read change log: %w", err)
+		}
+
+		for len(data) > 0 {
+			line := data
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				line, data = data[:i], data[i+1:]
+			} else {
+				data = nil
+			}
+			var event Event
+			if json.Unmarshal(line, &event) != nil || event.Seq > last {
+				return events, nil
+			}
+			if event.Seq <= seq {
+				continue
+			}
+			events = append(events, event)
+			if limit > 0 && len(events) >= limit {
+				return events, nil
+			}
+		}
+	}
+	return events, nil
+}
+
+// Wait blocks until there are events after seq, ctx is done or the log is
+// closed
+func (l *Log) Wait(ctx context.Context, seq uint64) {
+	for {
+		l.mu.Lock()
+		last, notify := l.last, l.notify
+		l.mu.Unlock()
+		if last > seq {
+			return
+		}
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// Close stops retention and closes the current segment. Waiting readers
+// are released.
+func (l *Log) Close() {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	l.wg.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// segmentPath returns the file of the segment starting at first
+func (l *Log) segmentPath(first uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", first, segmentExt))
+}
+
+// expire removes segments past retention until Close is called
+func (l *Log) expire() {
+	defer l.wg.Done()
+
+	interval := l.retention
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			l.removeExpired()
+		}
+	}
+}
+
+// removeExpired drops the oldest segments whose last event is older than
+// the retention window. A segment is last written by its newest event, so
+// its modification time is the time of that event. The segment being
+// appended to is kept, but closed first if it has aged so that a quiet log
+// still expires.
+func (l *Log) removeExpired() {
+	now := time.Now()
+	cutoff := now.Add(-l.retention)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.aged(now) {
+		if err := l.roll(); err != nil {
+			log.Printf("Failed to start a new change log segment: %v", err)
+		}
+	}
+
+	removed := 0
+	for len(l.segments) > 1 {
+		path := l.segmentPath(l.segments[0])
+		info, err := os.Stat(path)
+		if err == nil && info.ModTime().After(cutoff) {
+			break
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove change log segment: %v", err)
+			break
+		}
+		l.segments = l.segments[1:]
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("Expired %d change log segments", removed)
+	}
+}
@@ -0,0 +1,195 @@
+package changes
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func openTestLog(t *testing.T, dir string) *Log {
+	t.Helper()
+	l, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return l
+}
+
+func mustAppend(t *testing.T, l *Log, fileID string) Event {
+	t.Helper()
+	event, err := l.Append(Create, fileID, `"etag"`)
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	return event
+}
+
+func TestSinceAcrossReopen(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir)
+	for _, id := range []string{"a", "b", "c"} {
+		mustAppend(t, l, id)
+	}
+	l.Close()
+
+	l = openTestLog(t, dir)
+	defer l.Close()
+	if event := mustAppend(t, l, "d"); event.Seq != 4 {
+		t.Errorf("event after reopen has seq %d, want 4", event.Seq)
+	}
+
+	events, err := l.Since(1, 2)
+	if err != nil {
+		t.Fatalf("Since: %v", err)
+	}
+	if len(events) != 2 || events[0].FileID != "b" || events[1].FileID != "c" {
+		t.Errorf("Since(1, 2) = %+v, want b and c", events)
+	}
+	if events, err := l.Since(4, 0); err != nil || len(events) != 0 {
+		t.Errorf("Since(last) = %v, %v, want nothing", events, err)
+	}
+}
+
+func TestOpenRepairsPartialEvent(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir)
+	mustAppend(t, l, "a")
+	mustAppend(t, l, "b")
+	path := l.segmentPath(1)
+	l.Close()
+
+	// Simulate a crash in the middle of writing the third event
+	intact, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(intact, `{"seq":3,"type":"cre`...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openTestLog(t, dir)
+	defer l.Close()
+	if last := l.Last(); last != 2 {
+		t.Fatalf("Last after repair = %d, want 2", last)
+	}
+	if event := mustAppend(t, l, "c"); event.Seq != 3 {
+		t.Errorf("event after repair has seq %d, want 3", event.Seq)
+	}
+
+	events, err := l.Since(0, 0)
+	if err != nil {
+		t.Fatalf("Since: %v", err)
+	}
+	if len(events) != 3 || events[2].FileID != "c" {
+		t.Errorf("Since(0) = %+v, want a, b and c", events)
+	}
+}
+
+func TestOpenRepairsOutOfSequenceEvent(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir)
+	mustAppend(t, l, "a")
+	path := l.segmentPath(1)
+	l.Close()
+
+	intact, _ := os.ReadFile(path)
+	os.WriteFile(path, append(intact, "{\"seq\":7,\"type\":\"create\",\"file_id\":\"x\"}\n"...), 0644)
+
+	l = openTestLog(t, dir)
+	defer l.Close()
+	if last := l.Last(); last != 1 {
+		t.Errorf("Last after repair = %d, want 1", last)
+	}
+	if data, _ := os.ReadFile(path); len(data) != len(intact) {
+		t.Errorf("segment has %d bytes after repair, want %d", len(data), len(intact))
+	}
+}
+
+func TestCursorErrors(t *testing.T) {
+	l := openTestLog(t, t.TempDir())
+	defer l.Close()
+
+	if _, err := l.Since(1, 0); !errors.Is(err, ErrCursorAhead) {
+		t.Errorf("Since on an empty log returned %v, want ErrCursorAhead", err)
+	}
+	mustAppend(t, l, "a")
+	mustAppend(t, l, "b")
+	if _, err := l.Since(3, 0); !errors.Is(err, ErrCursorAhead) {
+		t.Errorf("Since past the end returned %v, want ErrCursorAhead", err)
+	}
+
+	// Age every event out of a quiet log
+	l.retention = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+	l.removeExpired()
+
+	if _, err := l.Since(0, 0); !errors.Is(err, ErrCursorExpired) {
+		t.Errorf("Since before the retained log returned %v, want ErrCursorExpired", err)
+	}
+	if _, err := l.Since(1, 0); !errors.Is(err, ErrCursorExpired) {
+		t.Errorf("Since(1) after expiry returned %v, want ErrCursorExpired", err)
+	}
+	if events, err := l.Since(2, 0); err != nil || len(events) != 0 {
+		t.Errorf("Since(last) after expiry = %v, %v, want nothing", events, err)
+	}
+
+	// The sequence carries on after everything expired
+	l.retention = time.Hour
+	if event := mustAppend(t, l, "c"); event.Seq != 3 {
+		t.Errorf("event after expiry has seq %d, want 3", event.Seq)
+	}
+	if events, err := l.Since(2, 0); err != nil || len(events) != 1 || events[0].FileID != "c" {
+		t.Errorf("Since(2) = %+v, %v, want c", events, err)
+	}
+}
+
+func TestAppendRollsAgedSegment(t *testing.T) {
+	l := openTestLog(t, t.TempDir())
+	defer l.Close()
+	l.retention = 20 * time.Millisecond
+
+	mustAppend(t, l, "a")
+	time.Sleep(30 * time.Millisecond)
+	mustAppend(t, l, "b")
+
+	l.mu.Lock()
+	segments := append([]uint64(nil), l.segments...)
+	l.mu.Unlock()
+	if len(segments) != 2 || segments[1] != 2 {
+		t.Fatalf("segments = %v, want a new one starting at 2", segments)
+	}
+
+	l.removeExpired()
+	if _, err := l.Since(0, 0); !errors.Is(err, ErrCursorExpired) {
+		t.Errorf("Since(0) returned %v, want ErrCursorExpired", err)
+	}
+	if events, err := l.Since(1, 0); err != nil || len(events) != 1 {
+		t.Errorf("Since(1) = %v, %v, want b", events, err)
+	}
+}
+
+func TestWait(t *testing.T) {
+	l := openTestLog(t, t.TempDir())
+	defer l.Close()
+
+	done := make(chan struct{})
+	go func() {
+		l.Wait(context.Background(), 0)
+		close(done)
+	}()
+	mustAppend(t, l, "a")
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after an append")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	l.Wait(ctx, 1)
+	if ctx.Err() == nil {
+		t.Error("Wait returned before its context was done")
+	}
+}
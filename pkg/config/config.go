@@ -3,15 +3,158 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// Placement strategies for choosing the disk that receives a new object
+const (
+	PlacementFreeSpace  = "free-space"
+	PlacementRoundRobin = "round-robin"
+)
+
+// Content type policies deciding what happens when the sniffed type of an
+// upload contradicts its declared type or filename extension
+const (
+	TypePolicyFlag    = "flag"
+	TypePolicyCorrect = "correct"
+	TypePolicyReject  = "reject"
+)
+
+// Malware scanners
+const (
+	ScannerNone  = ""
+	ScannerClamd = "clamd"
+)
+
+// Scan modes deciding whether uploads wait for their malware scan
+const (
+	ScanModeSync  = "sync"
+	ScanModeAsync = "async"
+)
+
 // Config holds all configuration for the storage node
 type Config struct {
 	Port        int
 	StoragePath string
 	InstanceID  string
+
+	// StoragePaths lists the data directories (one per disk) managed by
+	// this node. Metadata always lives under StoragePath.
+	StoragePaths []string
+	// Placement selects how new objects are spread across StoragePaths
+	Placement string
+
+	// PackThreshold is the size in bytes below which objects are appended
+	// to packed volume files instead of getting their own file; 0 disables
+	// packing
+	PackThreshold int64
+	// PackVolumeSize is the size at which a new volume file is started
+	PackVolumeSize int64
+	// CompactInterval is how often volumes are checked for compaction
+	CompactInterval time.Duration
+	// CompactRatio is the fraction of dead bytes that triggers a rewrite
+	CompactRatio float64
+
+	// ColdStoragePath is the cold tier directory; empty disables tiering
+	ColdStoragePath string
+	// ColdAfter moves files to the cold tier once they have not been read
+	// for this long
+	ColdAfter time.Duration
+	// ColdCompress gzips files as they move to the cold tier
+	ColdCompress bool
+	// TierMoveInterval is how often the lifecycle mover runs
+	TierMoveInterval time.Duration
+
+	// ExtractMaxEntries caps the number of entries in an archive unpacked
+	// on upload
+	ExtractMaxEntries int
+	// ExtractMaxBytes caps the total expanded size of an unpacked archive
+	ExtractMaxBytes int64
+	// ExtractMaxRatio caps the expanded size relative to the archive size
+	ExtractMaxRatio float64
+
+	// ComposeMaxBytes caps the size of a file created by copy, compose or
+	// slice, which is assembled in memory; zero or less disables the cap
+	ComposeMaxBytes int64
+	// DeltaMaxBytes caps the size of a new version built from a delta;
+	// zero or less disables the cap
+	DeltaMaxBytes int64
+
+	// MIMETypesFile is an optional JSON file extending the MIME registry
+	MIMETypesFile string
+	// ContentTypePolicy is TypePolicyFlag, TypePolicyCorrect or
+	// TypePolicyReject
+	ContentTypePolicy string
+
+	// UploadPolicyFile is an optional JSON file of upload allow/deny rules
+	UploadPolicyFile string
+	// UploadPolicyReloadInterval is how often the policy file is checked
+	// for changes
+	UploadPolicyReloadInterval time.Duration
+
+	// IdempotencyTTL is how long upload results are remembered for
+	// retries carrying the same Idempotency-Key; 0 disables replay
+	IdempotencyTTL time.Duration
+
+	// StagingTimeout is how long a staged upload waits for its commit
+	// before it is aborted
+	StagingTimeout time.Duration
+
+	// ChangesRetention is how long change log events are kept; 0 keeps
+	// them forever
+	ChangesRetention time.Duration
+
+	// WebhooksFile is an optional JSON file of webhook subscriptions, in
+	// addition to those created through the API
+	WebhooksFile string
+	// WebhookTimeout bounds a single webhook delivery attempt
+	WebhookTimeout time.Duration
+	// WebhookMaxAttempts is the number of attempts made at a delivery
+	// before it is given up
+	WebhookMaxAttempts int
+
+	// Peers are the base URLs of the other storage nodes replicas are
+	// written to
+	Peers []string
+	// ReplicationFactor is the number of copies of an upload, counting the
+	// one on this node, when the client does not choose
+	ReplicationFactor int
+	// WriteQuorum is the number of copies that must be written before an
+	// upload succeeds; 0 means a majority of the replication factor
+	WriteQuorum int
+	// ReplicationTimeout bounds writing a single replica to a peer
+	ReplicationTimeout time.Duration
+	// ReplicationSecret authenticates nodes to each other's replication
+	// endpoint. It is required with Peers; without it the endpoint refuses
+	// every replica.
+	ReplicationSecret string
+	// ReplicaMaxBytes caps the size of a replica a peer may write to this
+	// node; zero or less disables the cap
+	ReplicaMaxBytes int64
+
+	// Scanner selects the malware scanner; ScannerNone disables scanning
+	Scanner string
+	// ClamdAddress is the clamd socket, "unix:///path" or "tcp://host:port"
+	ClamdAddress string
+	// ScanMode is ScanModeSync or ScanModeAsync
+	ScanMode string
+	// ScanTimeout bounds a single scan
+	ScanTimeout time.Duration
+	// ScanWorkers is the number of background scans run concurrently
+	ScanWorkers int
+	// ScanBlockUnscanned refuses downloads of files that have not passed
+	// a scan yet
+	ScanBlockUnscanned bool
+	// QuarantinePath receives infected files; empty means a quarantine
+	// directory under StoragePath
+	QuarantinePath string
+
+	// ThumbnailMaxPixels is the largest image, in pixels, the node will
+	// decode to render a thumbnail
+	ThumbnailMaxPixels int64
 }
 
 // Load reads configuration from environment variables with defaults
@@ -19,6 +162,45 @@ func Load() *Config {
 	cfg := &Config{
 		Port:        8080,
 		StoragePath: "/tmp/storage_data",
+		Placement:   PlacementFreeSpace,
+
+		PackVolumeSize:  1 << 30,
+		CompactInterval: time.Hour,
+		CompactRatio:    0.5,
+
+		ColdAfter:        30 * 24 * time.Hour,
+		TierMoveInterval: time.Hour,
+
+		ExtractMaxEntries: 10000,
+		ExtractMaxBytes:   1 << 30,
+		ExtractMaxRatio:   100,
+
+		ComposeMaxBytes: 256 << 20,
+		DeltaMaxBytes:   1 << 30,
+
+		ContentTypePolicy: TypePolicyFlag,
+
+		UploadPolicyReloadInterval: 10 * time.Second,
+
+		IdempotencyTTL: 24 * time.Hour,
+
+		StagingTimeout: time.Hour,
+
+		ChangesRetention: 7 * 24 * time.Hour,
+
+		WebhookTimeout:     10 * time.Second,
+		WebhookMaxAttempts: 10,
+
+		ReplicationFactor:  1,
+		ReplicationTimeout: 10 * time.Second,
+		ReplicaMaxBytes:    1 << 30,
+
+		ClamdAddress: "tcp://127.0.0.1:3310",
+		ScanMode:     ScanModeAsync,
+		ScanTimeout:  time.Minute,
+		ScanWorkers:  2,
+
+		ThumbnailMaxPixels: 50_000_000,
 	}
 
 	// Override with environment variables if set
@@ -32,6 +214,79 @@ func Load() *Config {
 		cfg.StoragePath = storagePath
 	}
 
+	// Data directories default to the single storage path
+	cfg.StoragePaths = splitList(os.Getenv("STORAGE_PATHS"))
+	if len(cfg.StoragePaths) == 0 {
+		cfg.StoragePaths = []string{cfg.StoragePath}
+	}
+
+	switch placement := os.Getenv("STORAGE_PLACEMENT"); placement {
+	case PlacementFreeSpace, PlacementRoundRobin:
+		cfg.Placement = placement
+	}
+
+	cfg.PackThreshold = getEnvInt64("PACK_THRESHOLD", cfg.PackThreshold)
+	cfg.PackVolumeSize = getEnvInt64("PACK_VOLUME_SIZE", cfg.PackVolumeSize)
+	cfg.CompactInterval = getEnvDuration("PACK_COMPACT_INTERVAL", cfg.CompactInterval)
+	cfg.CompactRatio = getEnvFloat("PACK_COMPACT_RATIO", cfg.CompactRatio)
+
+	cfg.ColdStoragePath = os.Getenv("COLD_STORAGE_PATH")
+	if days := getEnvInt64("COLD_AFTER_DAYS", 0); days > 0 {
+		cfg.ColdAfter = time.Duration(days) * 24 * time.Hour
+	}
+	cfg.ColdAfter = getEnvDuration("COLD_AFTER", cfg.ColdAfter)
+	cfg.ColdCompress = getEnvBool("COLD_COMPRESS", cfg.ColdCompress)
+	cfg.TierMoveInterval = getEnvDuration("TIER_MOVE_INTERVAL", cfg.TierMoveInterval)
+
+	cfg.ExtractMaxEntries = int(getEnvInt64("EXTRACT_MAX_ENTRIES", int64(cfg.ExtractMaxEntries)))
+	cfg.ExtractMaxBytes = getEnvInt64("EXTRACT_MAX_BYTES", cfg.ExtractMaxBytes)
+	cfg.ExtractMaxRatio = getEnvFloat("EXTRACT_MAX_RATIO", cfg.ExtractMaxRatio)
+
+	cfg.ComposeMaxBytes = getEnvInt64("COMPOSE_MAX_BYTES", cfg.ComposeMaxBytes)
+	cfg.DeltaMaxBytes = getEnvInt64("DELTA_MAX_BYTES", cfg.DeltaMaxBytes)
+
+	cfg.MIMETypesFile = os.Getenv("MIME_TYPES_FILE")
+	switch policy := os.Getenv("CONTENT_TYPE_POLICY"); policy {
+	case TypePolicyFlag, TypePolicyCorrect, TypePolicyReject:
+		cfg.ContentTypePolicy = policy
+	}
+
+	cfg.UploadPolicyFile = os.Getenv("UPLOAD_POLICY_FILE")
+	cfg.UploadPolicyReloadInterval = getEnvDuration("UPLOAD_POLICY_RELOAD_INTERVAL", cfg.UploadPolicyReloadInterval)
+
+	cfg.IdempotencyTTL = getEnvDuration("IDEMPOTENCY_TTL", cfg.IdempotencyTTL)
+	cfg.StagingTimeout = getEnvDuration("STAGING_TIMEOUT", cfg.StagingTimeout)
+	cfg.ChangesRetention = getEnvDuration("CHANGES_RETENTION", cfg.ChangesRetention)
+
+	cfg.WebhooksFile = os.Getenv("WEBHOOKS_FILE")
+	cfg.WebhookTimeout = getEnvDuration("WEBHOOK_TIMEOUT", cfg.WebhookTimeout)
+	cfg.WebhookMaxAttempts = int(getEnvInt64("WEBHOOK_MAX_ATTEMPTS", int64(cfg.WebhookMaxAttempts)))
+
+	cfg.Peers = splitList(os.Getenv("REPLICATION_PEERS"))
+	cfg.ReplicationFactor = int(getEnvInt64("REPLICATION_FACTOR", int64(cfg.ReplicationFactor)))
+	cfg.WriteQuorum = int(getEnvInt64("REPLICATION_WRITE_QUORUM", int64(cfg.WriteQuorum)))
+	cfg.ReplicationTimeout = getEnvDuration("REPLICATION_TIMEOUT", cfg.ReplicationTimeout)
+	cfg.ReplicationSecret = os.Getenv("REPLICATION_SECRET")
+	cfg.ReplicaMaxBytes = getEnvInt64("REPLICA_MAX_BYTES", cfg.ReplicaMaxBytes)
+
+	switch scanner := os.Getenv("SCANNER"); scanner {
+	case ScannerNone, ScannerClamd:
+		cfg.Scanner = scanner
+	}
+	if address := os.Getenv("CLAMD_ADDRESS"); address != "" {
+		cfg.ClamdAddress = address
+	}
+	switch mode := os.Getenv("SCAN_MODE"); mode {
+	case ScanModeSync, ScanModeAsync:
+		cfg.ScanMode = mode
+	}
+	cfg.ScanTimeout = getEnvDuration("SCAN_TIMEOUT", cfg.ScanTimeout)
+	cfg.ScanWorkers = int(getEnvInt64("SCAN_WORKERS", int64(cfg.ScanWorkers)))
+	cfg.ScanBlockUnscanned = getEnvBool("SCAN_BLOCK_UNSCANNED", cfg.ScanBlockUnscanned)
+	cfg.QuarantinePath = os.Getenv("QUARANTINE_PATH")
+
+	cfg.ThumbnailMaxPixels = getEnvInt64("THUMBNAIL_MAX_PIXELS", cfg.ThumbnailMaxPixels)
+
 	// Load instance ID from environment or generate a new one
 	if instanceID := os.Getenv("INSTANCE_ID"); instanceID != "" {
 		cfg.InstanceID = instanceID
@@ -41,3 +296,47 @@ func Load() *Config {
 
 	return cfg
 }
+
+// splitList splits a comma-separated value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getEnvInt64 reads an integer environment variable, keeping the default
+// when it is unset or invalid
+func getEnvInt64(key string, def int64) int64 {
+	if value, err := strconv.ParseInt(os.Getenv(key), 10, 64); err == nil {
+		return value
+	}
+	return def
+}
+
+// getEnvFloat reads a floating point environment variable
+func getEnvFloat(key string, def float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return def
+}
+
+// getEnvBool reads a boolean such as "true" or "0"
+func getEnvBool(key string, def bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return def
+}
+
+// getEnvDuration reads a duration such as "30s" or "1h"
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return def
+}
@@ -0,0 +1,122 @@
+package delta
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Client updates files on a storage node by sending only the changed parts
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewClient creates a client for the node at baseURL, e.g.
+// "http://localhost:8080"
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// Signature fetches the block signature of a stored file. A blockSize of 0
+// lets the node choose.
+func (c *Client) Signature(ctx context.Context, fileID string, blockSize int) (*Signature, error) {
+	endpoint := c.fileURL(fileID, "signature")
+	if blockSize > 0 {
+		endpoint += "?block_size=" + strconv.Itoa(blockSize)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+
+	var sig Signature
+	if err := json.NewDecoder(resp.Body).Decode(&sig); err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %w", err)
+	}
+	return &sig, nil
+}
+
+// Upload sends a delta for a stored file. ifMatch is the ETag of the
+// signature the delta was computed against; the node rejects the delta if
+// the file changed since.
+func (c *Client) Upload(ctx context.Context, fileID string, d *Delta, ifMatch string) error {
+	body, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.fileURL(fileID, "delta"), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", ifMatch)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// Sync replaces a stored file with the content of r, transferring only the
+// blocks the node does not already have. It returns the delta that was sent.
+func (c *Client) Sync(ctx context.Context, fileID string, r io.Reader) (*Delta, error) {
+	sig, err := c.Signature(ctx, fileID, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signature: %w", err)
+	}
+
+	d, err := ComputeDelta(sig, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute delta: %w", err)
+	}
+
+	if err := c.Upload(ctx, fileID, d, sig.ETag); err != nil {
+		return nil, fmt.Errorf("failed to upload delta: %w", err)
+	}
+	return d, nil
+}
+
+// fileURL builds the URL of a file sub-resource
+func (c *Client) fileURL(fileID, subresource string) string {
+	return fmt.Sprintf("%s/api/v1/files/%s/%s", c.BaseURL, url.PathEscape(fileID), subresource)
+}
+
+// responseError turns a non-success response into an error, including the
+// node's error message when present
+func responseError(resp *http.Response) error {
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body); err == nil && body.Message != "" {
+		return fmt.Errorf("node returned %s: %s", resp.Status, body.Message)
+	}
+	return fmt.Errorf("node returned %s", resp.Status)
+}
@@ -0,0 +1,345 @@
+// Package delta implements rsync-style delta transfer. The holder of the old
+// version publishes a Signature of fixed-size blocks; the holder of the new
+// version scans it with a rolling checksum and produces a Delta of block
+// copies and literal bytes, which is applied against the old version to
+// rebuild the new one.
+package delta
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Operation types in a Delta
+const (
+	OpCopy    = "copy"
+	OpLiteral = "literal"
+)
+
+// Block size limits
+const (
+	MinBlockSize = 512
+	MaxBlockSize = 1 << 20
+)
+
+// maxLiteral caps the payload of a single literal op
+const maxLiteral = 1 << 20
+
+// ErrTooLarge is returned by Apply when the new version would exceed the
+// size it is allowed to have
+var ErrTooLarge = errors.New("delta result too large")
+
+// Signature describes a file as a sequence of fixed-size blocks. The last
+// block may be shorter than BlockSize.
+type Signature struct {
+	BlockSize int              `json:"block_size"`
+	FileSize  int64            `json:"file_size"`
+	ETag      string           `json:"etag,omitempty"`
+	Blocks    []BlockSignature `json:"blocks"`
+}
+
+// BlockSignature holds the weak rolling checksum and strong hash of a block
+type BlockSignature struct {
+	Index  int    `json:"index"`
+	Weak   uint32 `json:"weak"`
+	Strong string `json:"strong"`
+}
+
+// Op is a single delta instruction. A copy op reuses Count blocks of the
+// old version starting at Block; a literal op inserts Data.
+type Op struct {
+	Type  string `json:"type"`
+	Block int    `json:"block,omitempty"`
+	Count int    `json:"count,omitempty"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+// Delta transforms the old version of a file into the new one
+type Delta struct {
+	BlockSize int  `json:"block_size"`
+	Ops       []Op `json:"ops"`
+}
+
+// DefaultBlockSize picks a block size of roughly the square root of the
+// file size, clamped to the supported range
+func DefaultBlockSize(size int64) int {
+	blockSize := int(math.Sqrt(float64(size)))
+	blockSize = (blockSize + MinBlockSize - 1) / MinBlockSize * MinBlockSize
+	if blockSize < MinBlockSize {
+		return MinBlockSize
+	}
+	if blockSize > MaxBlockSize {
+		return MaxBlockSize
+	}
+	return blockSize
+}
+
+// ComputeSignature reads r to the end and returns its block signature
+func ComputeSignature(r io.Reader, blockSize int) (*Signature, error) {
+	if blockSize < MinBlockSize || blockSize > MaxBlockSize {
+		return nil, fmt.Errorf("block size must be between %d and %d", MinBlockSize, MaxBlockSize)
+	}
+
+	sig := &Signature{BlockSize: blockSize}
+	block := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, block)
+		if n > 0 {
+			sig.Blocks = append(sig.Blocks, BlockSignature{
+				Index:  len(sig.Blocks),
+				Weak:   weakSum(block[:n]),
+				Strong: strongSum(block[:n]),
+			})
+			sig.FileSize += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sig, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ComputeDelta reads the new version from r and describes it relative to
+// the version summarized by sig
+func ComputeDelta(sig *Signature, r io.Reader) (*Delta, error) {
+	bs := sig.BlockSize
+	if bs < MinBlockSize || bs > MaxBlockSize {
+		return nil, fmt.Errorf("block size must be between %d and %d", MinBlockSize, MaxBlockSize)
+	}
+
+	index := make(map[uint32][]int, len(sig.Blocks))
+	for i, block := range sig.Blocks {
+		index[block.Weak] = append(index[block.Weak], i)
+	}
+
+	d := &Delta{BlockSize: bs}
+	s := &scanner{reader: bufio.NewReaderSize(r, 64<<10), buf: make([]byte, 0, 4*maxLiteral)}
+
+	var a, b uint32
+	rolling := false
+	for {
+		if err := s.fill(s.pos + bs + 1); err != nil {
+			return nil, err
+		}
+		avail := len(s.buf) - s.pos
+		if avail == 0 {
+			break
+		}
+		if avail < bs {
+			// Tail shorter than a block can only match a short last block
+			tail := s.buf[s.pos:]
+			if i, ok := findBlock(sig, index, tail); ok {
+				d.literal(s.buf[s.lit:s.pos])
+				d.copyBlock(i)
+				s.pos = len(s.buf)
+				s.lit = s.pos
+			}
+			break
+		}
+
+		window := s.buf[s.pos : s.pos+bs]
+		if !rolling {
+			a, b = rollingSums(window)
+			rolling = true
+		}
+		if i, ok := findBlockWeak(sig, index, a|b<<16, window); ok {
+			d.literal(s.buf[s.lit:s.pos])
+			d.copyBlock(i)
+			s.pos += bs
+			s.lit = s.pos
+			rolling = false
+			s.compact()
+			continue
+		}
+
+		// No match: slide the window by one byte
+		if avail > bs {
+			out, in := uint32(s.buf[s.pos]), uint32(s.buf[s.pos+bs])
+			a = (a - out + in) & 0xffff
+			b = (b - uint32(bs)*out + a) & 0xffff
+		} else {
+			rolling = false
+		}
+		s.pos++
+
+		if s.pos-s.lit >= maxLiteral {
+			d.literal(s.buf[s.lit:s.pos])
+			s.lit = s.pos
+			s.compact()
+		}
+	}
+
+	d.literal(s.buf[s.lit:])
+	return d, nil
+}
+
+// Apply writes the new version to w by replaying d against the old version
+// in base, which is baseSize bytes long. The delta is checked before
+// anything is written: a new version over maxSize bytes, when maxSize is
+// positive, fails with ErrTooLarge.
+func Apply(base io.ReaderAt, baseSize int64, d *Delta, w io.Writer, maxSize int64) error {
+	if d.BlockSize < MinBlockSize || d.BlockSize > MaxBlockSize {
+		return fmt.Errorf("block size must be between %d and %d", MinBlockSize, MaxBlockSize)
+	}
+	bs := int64(d.BlockSize)
+	blocks := (baseSize + bs - 1) / bs
+
+	// Each copy is bounded by the base, so the running total is checked
+	// against the limit before it could overflow
+	var size int64
+	for _, op := range d.Ops {
+		var n int64
+		switch op.Type {
+		case OpCopy:
+			if op.Block < 0 || op.Count < 1 || int64(op.Block)+int64(op.Count) > blocks {
+				return fmt.Errorf("copy of blocks %d+%d is outside the %d-block base", op.Block, op.Count, blocks)
+			}
+			start := int64(op.Block) * bs
+			n = min(start+int64(op.Count)*bs, baseSize) - start
+		case OpLiteral:
+			n = int64(len(op.Data))
+		default:
+			return fmt.Errorf("unknown op type %q", op.Type)
+		}
+		if maxSize > 0 && n > maxSize-size {
+			return fmt.Errorf("%w: the new version would exceed %d bytes", ErrTooLarge, maxSize)
+		}
+		size += n
+	}
+
+	for _, op := range d.Ops {
+		if op.Type == OpLiteral {
+			if _, err := w.Write(op.Data); err != nil {
+				return err
+			}
+			continue
+		}
+		start := int64(op.Block) * bs
+		end := min(start+int64(op.Count)*bs, baseSize)
+		if _, err := io.Copy(w, io.NewSectionReader(base, start, end-start)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// literal appends literal bytes, copying them out of the scan buffer
+func (d *Delta) literal(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	d.Ops = append(d.Ops, Op{Type: OpLiteral, Data: append([]byte(nil), data...)})
+}
+
+// copyBlock appends a block copy, extending the previous copy when the
+// blocks are consecutive
+func (d *Delta) copyBlock(block int) {
+	if n := len(d.Ops); n > 0 {
+		last := &d.Ops[n-1]
+		if last.Type == OpCopy && last.Block+last.Count == block {
+			last.Count++
+			return
+		}
+	}
+	d.Ops = append(d.Ops, Op{Type: OpCopy, Block: block, Count: 1})
+}
+
+// scanner buffers the new version while ComputeDelta slides over it. Bytes
+// before lit have already been emitted and can be discarded.
+type scanner struct {
+	reader *bufio.Reader
+	buf    []byte
+	pos    int
+	lit    int
+	eof    bool
+}
+
+// fill reads until buf holds n bytes or the input is exhausted
+func (s *scanner) fill(n int) error {
+	for len(s.buf) < n && !s.eof {
+		if len(s.buf) == cap(s.buf) {
+			grown := make([]byte, len(s.buf), 2*cap(s.buf))
+			copy(grown, s.buf)
+			s.buf = grown
+		}
+		read, err := s.reader.Read(s.buf[len(s.buf):cap(s.buf)])
+		s.buf = s.buf[:len(s.buf)+read]
+		if errors.Is(err, io.EOF) {
+			s.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compact drops emitted bytes once they make up half of the buffer
+func (s *scanner) compact() {
+	if s.lit < cap(s.buf)/2 {
+		return
+	}
+	n := copy(s.buf, s.buf[s.lit:])
+	s.buf = s.buf[:n]
+	s.pos -= s.lit
+	s.lit = 0
+}
+
+// findBlock looks up data by computing its weak checksum from scratch
+func findBlock(sig *Signature, index map[uint32][]int, data []byte) (int, bool) {
+	return findBlockWeak(sig, index, weakSum(data), data)
+}
+
+// findBlockWeak returns the block whose weak and strong sums match data
+func findBlockWeak(sig *Signature, index map[uint32][]int, weak uint32, data []byte) (int, bool) {
+	candidates, ok := index[weak]
+	if !ok {
+		return 0, false
+	}
+
+	strong := strongSum(data)
+	for _, i := range candidates {
+		if sig.Blocks[i].Strong == strong && blockLength(sig, i) == int64(len(data)) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// blockLength returns the length of block i
+func blockLength(sig *Signature, i int) int64 {
+	start := int64(i) * int64(sig.BlockSize)
+	if remaining := sig.FileSize - start; remaining < int64(sig.BlockSize) {
+		return remaining
+	}
+	return int64(sig.BlockSize)
+}
+
+// rollingSums computes the two halves of the rsync weak checksum
+func rollingSums(data []byte) (uint32, uint32) {
+	var a, b uint32
+	l := uint32(len(data))
+	for i, c := range data {
+		a += uint32(c)
+		b += (l - uint32(i)) * uint32(c)
+	}
+	return a & 0xffff, b & 0xffff
+}
+
+// weakSum returns the rsync weak checksum of data
+func weakSum(data []byte) uint32 {
+	a, b := rollingSums(data)
+	return a | b<<16
+}
+
+// strongSum returns a truncated SHA-256 of data
+func strongSum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,132 @@
+package delta
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+const testBlockSize = MinBlockSize
+
+// randomBytes returns n reproducible pseudo-random bytes
+func randomBytes(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// splice returns data with n bytes at offset replaced by insert
+func splice(data []byte, offset, n int, insert []byte) []byte {
+	out := append([]byte(nil), data[:offset]...)
+	out = append(out, insert...)
+	return append(out, data[offset+n:]...)
+}
+
+func TestRoundTrip(t *testing.T) {
+	// Ten full blocks and a partial one
+	base := randomBytes(1, 10*testBlockSize+100)
+
+	tests := []struct {
+		name       string
+		base       []byte
+		next       []byte
+		maxLiteral int
+	}{
+		{"unchanged", base, base, 0},
+		{"insert", base, splice(base, 3*testBlockSize+17, 0, []byte("inserted bytes")), testBlockSize + 14},
+		{"insert at start", base, splice(base, 0, 0, []byte("prefix")), 6},
+		{"delete", base, splice(base, 2*testBlockSize+5, 300, nil), testBlockSize},
+		{"delete whole blocks", base, splice(base, 4*testBlockSize, 3*testBlockSize, nil), 0},
+		{"truncate partial block", base, base[:10*testBlockSize+40], 40},
+		{"drop partial block", base, base[:10*testBlockSize], 0},
+		{"append", base, append(append([]byte(nil), base...), randomBytes(2, 700)...), 800},
+		{"replace", base, randomBytes(3, len(base)), len(base)},
+		{"empty", base, nil, 0},
+		{"from empty", nil, base, len(base)},
+		{"short base", base[:100], append(append([]byte(nil), base[:100]...), 'x'), 101},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := ComputeSignature(bytes.NewReader(tt.base), testBlockSize)
+			if err != nil {
+				t.Fatalf("ComputeSignature: %v", err)
+			}
+			if sig.FileSize != int64(len(tt.base)) {
+				t.Fatalf("signature covers %d bytes, want %d", sig.FileSize, len(tt.base))
+			}
+
+			d, err := ComputeDelta(sig, bytes.NewReader(tt.next))
+			if err != nil {
+				t.Fatalf("ComputeDelta: %v", err)
+			}
+
+			var out bytes.Buffer
+			if err := Apply(bytes.NewReader(tt.base), int64(len(tt.base)), d, &out, 0); err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			if !bytes.Equal(out.Bytes(), tt.next) {
+				t.Fatalf("Apply produced %d bytes that differ from the %d expected", out.Len(), len(tt.next))
+			}
+
+			literal := 0
+			for _, op := range d.Ops {
+				if op.Type == OpLiteral {
+					literal += len(op.Data)
+				}
+			}
+			if literal > tt.maxLiteral {
+				t.Errorf("delta carries %d literal bytes, want at most %d", literal, tt.maxLiteral)
+			}
+		})
+	}
+}
+
+func TestApplyRejectsInvalidDelta(t *testing.T) {
+	base := randomBytes(1, 2*testBlockSize+10)
+
+	tests := []struct {
+		name string
+		d    *Delta
+	}{
+		{"block size", &Delta{BlockSize: 1, Ops: []Op{{Type: OpLiteral, Data: []byte("x")}}}},
+		{"block past end", &Delta{BlockSize: testBlockSize, Ops: []Op{{Type: OpCopy, Block: 3, Count: 1}}}},
+		{"count past end", &Delta{BlockSize: testBlockSize, Ops: []Op{{Type: OpCopy, Block: 1, Count: 3}}}},
+		{"negative block", &Delta{BlockSize: testBlockSize, Ops: []Op{{Type: OpCopy, Block: -1, Count: 1}}}},
+		{"unknown op", &Delta{BlockSize: testBlockSize, Ops: []Op{{Type: "move"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Apply(bytes.NewReader(base), int64(len(base)), tt.d, &out, 0); err == nil {
+				t.Error("Apply accepted an invalid delta")
+			}
+		})
+	}
+}
+
+func TestApplySizeLimit(t *testing.T) {
+	base := randomBytes(1, 2*testBlockSize+10)
+	// Each op copies the whole base again
+	d := &Delta{BlockSize: testBlockSize}
+	for i := 0; i < 1000; i++ {
+		d.Ops = append(d.Ops, Op{Type: OpCopy, Block: 0, Count: 3})
+	}
+
+	var out bytes.Buffer
+	if err := Apply(bytes.NewReader(base), int64(len(base)), d, &out, 10*int64(len(base))); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Apply returned %v, want ErrTooLarge", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Apply wrote %d bytes of a delta over the limit", out.Len())
+	}
+
+	d.Ops = d.Ops[:10]
+	if err := Apply(bytes.NewReader(base), int64(len(base)), d, &out, 10*int64(len(base))); err != nil {
+		t.Fatalf("Apply of a delta at the limit: %v", err)
+	}
+	if out.Len() != 10*len(base) {
+		t.Errorf("Apply wrote %d bytes, want %d", out.Len(), 10*len(base))
+	}
+}
@@ -0,0 +1,208 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+// EXIF tags read by exifExtractor
+const (
+	tagMake             = 0x010f
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+)
+
+// TIFF field types
+const (
+	typeASCII = 2
+	typeShort = 3
+	typeLong  = 4
+)
+
+// exifDateLayout is the fixed timestamp format used by EXIF
+const exifDateLayout = "2006:01:02 15:04:05"
+
+var errInvalidEXIF = errors.New("invalid EXIF data")
+
+// exifExtractor reads the capture date, camera and orientation from the
+// EXIF block of a JPEG
+type exifExtractor struct{}
+
+func (exifExtractor) Name() string {
+	return "exif"
+}
+
+func (exifExtractor) Supports(contentType string) bool {
+	return contentType == "image/jpeg"
+}
+
+func (exifExtractor) Extract(content []byte) (Properties, error) {
+	tiff := findEXIF(content)
+	if tiff == nil {
+		return nil, nil
+	}
+	if len(tiff) < 8 {
+		return nil, errInvalidEXIF
+	}
+
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(tiff, []byte("II*\x00")):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(tiff, []byte("MM\x00*")):
+		order = binary.BigEndian
+	default:
+		return nil, errInvalidEXIF
+	}
+
+	ifd0, err := readIFD(tiff, order, order.Uint32(tiff[4:8]))
+	if err != nil {
+		return nil, err
+	}
+
+	props := Properties{}
+	if maker := ifd0.ascii(tagMake); maker != "" {
+		props["make"] = maker
+	}
+	if model := ifd0.ascii(tagModel); model != "" {
+		props["model"] = model
+	}
+	if orientation, ok := ifd0.uint(tagOrientation); ok {
+		props["orientation"] = orientation
+	}
+
+	// The capture date lives in the Exif sub-IFD; fall back to the
+	// modification date in IFD0
+	date := ifd0.ascii(tagDateTime)
+	if offset, ok := ifd0.uint(tagExifIFD); ok {
+		if exif, err := readIFD(tiff, order, offset); err == nil {
+			if original := exif.ascii(tagDateTimeOriginal); original != "" {
+				date = original
+			}
+		}
+	}
+	if t, err := time.Parse(exifDateLayout, date); err == nil {
+		props["date_time_original"] = t.Format("2006-01-02T15:04:05")
+	}
+
+	return props, nil
+}
+
+// findEXIF returns the TIFF structure embedded in the APP1 segment of a
+// JPEG, or nil if there is none
+func findEXIF(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil
+	}
+
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xff {
+			return nil
+		}
+		marker := data[pos+1]
+		// Image data starts at SOS; metadata segments all come before it
+		if marker == 0xda || marker == 0xd9 {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil
+		}
+		segment := data[pos+4 : end]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		pos = end
+	}
+	return nil
+}
+
+// ifdEntry is a raw field of an image file directory
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// ifd maps tags to the fields of one image file directory
+type ifd struct {
+	order   binary.ByteOrder
+	entries map[uint16]ifdEntry
+}
+
+// readIFD parses the directory at offset within tiff
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) (*ifd, error) {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return nil, errInvalidEXIF
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	pos := int(offset) + 2
+	if pos+count*12 > len(tiff) {
+		return nil, errInvalidEXIF
+	}
+
+	dir := &ifd{order: order, entries: make(map[uint16]ifdEntry, count)}
+	for i := 0; i < count; i++ {
+		raw := tiff[pos+i*12 : pos+(i+1)*12]
+		entry := ifdEntry{typ: order.Uint16(raw[2:4]), count: order.Uint32(raw[4:8])}
+
+		size := uint64(entry.count) * uint64(typeSize(entry.typ))
+		if size <= 4 {
+			entry.value = raw[8 : 8+size]
+		} else {
+			start := uint64(order.Uint32(raw[8:12]))
+			if start+size > uint64(len(tiff)) {
+				continue
+			}
+			entry.value = tiff[start : start+size]
+		}
+		dir.entries[order.Uint16(raw[0:2])] = entry
+	}
+	return dir, nil
+}
+
+// ascii returns a string field, trimmed of padding
+func (d *ifd) ascii(tag uint16) string {
+	entry, ok := d.entries[tag]
+	if !ok || entry.typ != typeASCII {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(entry.value), "\x00"))
+}
+
+// uint returns the first value of a SHORT or LONG field
+func (d *ifd) uint(tag uint16) (uint32, bool) {
+	entry, ok := d.entries[tag]
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case entry.typ == typeShort && len(entry.value) >= 2:
+		return uint32(d.order.Uint16(entry.value)), true
+	case entry.typ == typeLong && len(entry.value) >= 4:
+		return d.order.Uint32(entry.value), true
+	}
+	return 0, false
+}
+
+// typeSize returns the size in bytes of one value of a TIFF field type
+func typeSize(typ uint16) int {
+	switch typ {
+	case 1, 2, 6, 7:
+		return 1
+	case 3, 8:
+		return 2
+	case 4, 9, 11:
+		return 4
+	case 5, 10, 12:
+		return 8
+	}
+	return 0
+}
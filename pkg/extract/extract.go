@@ -0,0 +1,96 @@
+// Package extract derives structured properties, such as image dimensions
+// or PDF page counts, from file content. Extractors are selected by
+// content type and can be added with Register.
+package extract
+
+import (
+	"log"
+	"strings"
+	"sync"
+)
+
+// Properties holds the fields found by a single extractor
+type Properties map[string]interface{}
+
+// Extractor reads properties from the content of a file
+type Extractor interface {
+	// Name identifies the extractor; its properties are stored under it
+	Name() string
+	// Supports reports whether the extractor understands contentType,
+	// which is lower-cased and stripped of parameters
+	Supports(contentType string) bool
+	// Extract returns the properties found in content. A nil result
+	// means there was nothing to report.
+	Extract(content []byte) (Properties, error)
+}
+
+// Registry dispatches content to the extractors that support its type
+type Registry struct {
+	mu         sync.RWMutex
+	extractors []Extractor
+}
+
+// NewRegistry creates a registry holding the given extractors
+func NewRegistry(extractors ...Extractor) *Registry {
+	return &Registry{extractors: extractors}
+}
+
+// Register adds an extractor. An extractor with the same name replaces the
+// existing one.
+func (r *Registry) Register(e Extractor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, existing := range r.extractors {
+		if existing.Name() == e.Name() {
+			r.extractors[i] = e
+			return
+		}
+	}
+	r.extractors = append(r.extractors, e)
+}
+
+// Run runs every extractor supporting contentType and returns their
+// properties keyed by extractor name, or nil if none produced any. Failing
+// extractors are logged and skipped so a malformed file never blocks an
+// upload.
+func (r *Registry) Run(contentType string, content []byte) map[string]interface{} {
+	contentType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+
+	r.mu.RLock()
+	extractors := append([]Extractor(nil), r.extractors...)
+	r.mu.RUnlock()
+
+	var result map[string]interface{}
+	for _, e := range extractors {
+		if !e.Supports(contentType) {
+			continue
+		}
+		props, err := e.Extract(content)
+		if err != nil {
+			log.Printf("Extractor %s failed on %s content: %v", e.Name(), contentType, err)
+			continue
+		}
+		if len(props) == 0 {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]interface{})
+		}
+		result[e.Name()] = props
+	}
+	return result
+}
+
+// defaultRegistry holds the built-in extractors and any added by Register
+var defaultRegistry = NewRegistry(imageExtractor{}, exifExtractor{}, pdfExtractor{})
+
+// Default returns the process-wide registry
+func Default() *Registry {
+	return defaultRegistry
+}
+
+// Register adds an extractor to the process-wide registry
+func Register(e Extractor) {
+	defaultRegistry.Register(e)
+}
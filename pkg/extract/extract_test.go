@@ -0,0 +1,208 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+// pngImage encodes a blank PNG of the given size
+func pngImage(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// exifJPEG builds the start of a JPEG whose EXIF block names the camera
+// make, the orientation and, in the Exif sub-IFD, the capture date
+func exifJPEG(order binary.ByteOrder, camera string, orientation uint16, date string) []byte {
+	makeValue := camera + "\x00"
+	dateValue := date + "\x00"
+
+	// IFD0 holds three entries and starts after the 8 byte header; the
+	// make string follows it, then the Exif IFD with its date string
+	const ifd0 = 8
+	makeOffset := ifd0 + 2 + 3*12 + 4
+	exifOffset := makeOffset + len(makeValue)
+	dateOffset := exifOffset + 2 + 12 + 4
+
+	var tiff bytes.Buffer
+	if order == binary.LittleEndian {
+		tiff.WriteString("II*\x00")
+	} else {
+		tiff.WriteString("MM\x00*")
+	}
+	write := func(v interface{}) { binary.Write(&tiff, order, v) }
+	entry := func(tag, typ uint16, count, value uint32) {
+		write(tag)
+		write(typ)
+		write(count)
+		write(value)
+	}
+	write(uint32(ifd0))
+
+	write(uint16(3))
+	entry(tagMake, typeASCII, uint32(len(makeValue)), uint32(makeOffset))
+	// A SHORT value sits in the first two bytes of the value field
+	write(uint16(tagOrientation))
+	write(uint16(typeShort))
+	write(uint32(1))
+	write(orientation)
+	write(uint16(0))
+	entry(tagExifIFD, typeLong, 1, uint32(exifOffset))
+	write(uint32(0))
+	tiff.WriteString(makeValue)
+
+	write(uint16(1))
+	entry(tagDateTimeOriginal, typeASCII, uint32(len(dateValue)), uint32(dateOffset))
+	write(uint32(0))
+	tiff.WriteString(dateValue)
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	jpeg := []byte{0xff, 0xd8, 0xff, 0xe1}
+	jpeg = binary.BigEndian.AppendUint16(jpeg, uint16(len(segment)+2))
+	jpeg = append(jpeg, segment...)
+	return append(jpeg, 0xff, 0xda)
+}
+
+func TestImageExtractor(t *testing.T) {
+	props, err := imageExtractor{}.Extract(pngImage(t, 3, 2))
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	want := Properties{"width": 3, "height": 2, "format": "png"}
+	if !reflect.DeepEqual(props, want) {
+		t.Errorf("properties = %v, want %v", props, want)
+	}
+
+	if _, err := (imageExtractor{}).Extract([]byte("not an image")); err == nil {
+		t.Error("Extract accepted content that is not an image")
+	}
+}
+
+func TestEXIFExtractor(t *testing.T) {
+	want := Properties{
+		"make":               "Canon",
+		"orientation":        uint32(6),
+		"date_time_original": "2024-05-06T07:08:09",
+	}
+	for name, order := range map[string]binary.ByteOrder{"little endian": binary.LittleEndian, "big endian": binary.BigEndian} {
+		t.Run(name, func(t *testing.T) {
+			props, err := exifExtractor{}.Extract(exifJPEG(order, "Canon", 6, "2024:05:06 07:08:09"))
+			if err != nil {
+				t.Fatalf("Extract: %v", err)
+			}
+			if !reflect.DeepEqual(props, want) {
+				t.Errorf("properties = %v, want %v", props, want)
+			}
+		})
+	}
+
+	// A JPEG without an EXIF block has nothing to report
+	if props, err := (exifExtractor{}).Extract([]byte{0xff, 0xd8, 0xff, 0xda}); props != nil || err != nil {
+		t.Errorf("Extract without EXIF = %v, %v, want nothing", props, err)
+	}
+	// Offsets past the end of the block are rejected, not followed
+	broken := exifJPEG(binary.LittleEndian, "Canon", 6, "2024:05:06 07:08:09")
+	binary.LittleEndian.PutUint32(broken[4+2+6+4:], 0xffff)
+	if _, err := (exifExtractor{}).Extract(broken); !errors.Is(err, errInvalidEXIF) {
+		t.Errorf("Extract with a bad IFD offset returned %v, want errInvalidEXIF", err)
+	}
+}
+
+func TestPDFExtractor(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    Properties
+	}{
+		{
+			"page tree",
+			"%PDF-1.7\n1 0 obj << /Type /Pages /Kids [2 0 R 3 0 R] /Count 2 >> endobj\n" +
+				"2 0 obj << /Type /Page /Parent 1 0 R >> endobj\n3 0 obj << /Type /Page /Parent 1 0 R >> endobj\n",
+			Properties{"version": "1.7", "pages": 2, "encrypted": false},
+		},
+		{
+			"count before type",
+			"%PDF-1.4\n1 0 obj << /Count 12 /Type /Pages >> endobj\ntrailer << /Encrypt 5 0 R >>\n",
+			Properties{"version": "1.4", "pages": 12, "encrypted": true},
+		},
+		{
+			"page objects only",
+			"%PDF-2.0\n2 0 obj << /Type /Page >> endobj\n3 0 obj << /Type/Page >> endobj\n4 0 obj << /Type /Page >> endobj\n",
+			Properties{"version": "2.0", "pages": 3, "encrypted": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			props, err := pdfExtractor{}.Extract([]byte(tt.content))
+			if err != nil {
+				t.Fatalf("Extract: %v", err)
+			}
+			if !reflect.DeepEqual(props, tt.want) {
+				t.Errorf("properties = %v, want %v", props, tt.want)
+			}
+		})
+	}
+
+	if _, err := (pdfExtractor{}).Extract([]byte("1 0 obj << /Type /Page >>")); err == nil {
+		t.Error("Extract accepted content without a PDF header")
+	}
+}
+
+// stubExtractor returns fixed properties, or err, for one content type
+type stubExtractor struct {
+	name        string
+	contentType string
+	props       Properties
+	err         error
+}
+
+func (s stubExtractor) Name() string                     { return s.name }
+func (s stubExtractor) Supports(contentType string) bool { return contentType == s.contentType }
+func (s stubExtractor) Extract([]byte) (Properties, error) {
+	return s.props, s.err
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(imageExtractor{})
+	r.Register(stubExtractor{name: "text", contentType: "text/plain", props: Properties{"lines": 1}})
+	r.Register(stubExtractor{name: "broken", contentType: "text/plain", err: errors.New("boom")})
+	r.Register(stubExtractor{name: "empty", contentType: "text/plain"})
+
+	// Parameters and case are ignored; failing and empty extractors are
+	// left out
+	got := r.Run("Text/Plain; charset=utf-8", []byte("hello"))
+	want := map[string]interface{}{"text": Properties{"lines": 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Run = %v, want %v", got, want)
+	}
+
+	// An extractor with a known name replaces the registered one
+	r.Register(stubExtractor{name: "text", contentType: "text/plain", props: Properties{"lines": 2}})
+	if got := r.Run("text/plain", nil); !reflect.DeepEqual(got["text"], Properties{"lines": 2}) {
+		t.Errorf("Run after replacing = %v, want the new extractor's properties", got)
+	}
+
+	if got := r.Run("application/octet-stream", []byte("data")); got != nil {
+		t.Errorf("Run for an unsupported type = %v, want nil", got)
+	}
+	if got := r.Run("image/png", pngImage(t, 1, 1)); got["image"] == nil {
+		t.Errorf("Run for a PNG = %v, want image properties", got)
+	}
+}
+
+func TestDefaultRegistry(t *testing.T) {
+	got := Default().Run("image/jpeg", exifJPEG(binary.BigEndian, "Nikon", 1, "2020:01:02 03:04:05"))
+	// The truncated JPEG has no frame header, so only EXIF is found
+	if exif, ok := got["exif"].(Properties); !ok || exif["make"] != "Nikon" {
+		t.Errorf("Run = %v, want the EXIF properties", got)
+	}
+}
@@ -0,0 +1,37 @@
+package extract
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif" // registers the GIF decoder
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// imageExtractor reports the dimensions of PNG, JPEG and GIF images
+// without decoding their pixels
+type imageExtractor struct{}
+
+func (imageExtractor) Name() string {
+	return "image"
+}
+
+func (imageExtractor) Supports(contentType string) bool {
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif":
+		return true
+	}
+	return false
+}
+
+func (imageExtractor) Extract(content []byte) (Properties, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	return Properties{
+		"width":  config.Width,
+		"height": config.Height,
+		"format": format,
+	}, nil
+}
@@ -0,0 +1,58 @@
+package extract
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strconv"
+)
+
+var (
+	pdfVersion = regexp.MustCompile(`^%PDF-(\d+\.\d+)`)
+	// A page object is /Type /Page, not to be confused with /Type /Pages
+	pdfPage = regexp.MustCompile(`/Type\s*/Page\b`)
+	// Page tree nodes carry the number of pages below them
+	pdfPageCount = regexp.MustCompile(`/Type\s*/Pages\b[^>]*?/Count\s+(\d+)|/Count\s+(\d+)[^>]*?/Type\s*/Pages\b`)
+)
+
+// pdfExtractor reports the version and page count of PDF documents
+type pdfExtractor struct{}
+
+func (pdfExtractor) Name() string {
+	return "pdf"
+}
+
+func (pdfExtractor) Supports(contentType string) bool {
+	return contentType == "application/pdf"
+}
+
+func (pdfExtractor) Extract(content []byte) (Properties, error) {
+	match := pdfVersion.FindSubmatch(content)
+	if match == nil {
+		return nil, errors.New("missing PDF header")
+	}
+	props := Properties{"version": string(match[1])}
+
+	// The root of the page tree has the largest count. Page trees inside
+	// compressed object streams are invisible here, so fall back to
+	// counting uncompressed page objects.
+	pages := 0
+	for _, m := range pdfPageCount.FindAllSubmatch(content, -1) {
+		digits := m[1]
+		if len(digits) == 0 {
+			digits = m[2]
+		}
+		if n, err := strconv.Atoi(string(digits)); err == nil && n > pages {
+			pages = n
+		}
+	}
+	if pages == 0 {
+		pages = len(pdfPage.FindAll(content, -1))
+	}
+	if pages > 0 {
+		props["pages"] = pages
+	}
+
+	props["encrypted"] = bytes.Contains(content, []byte("/Encrypt"))
+	return props, nil
+}
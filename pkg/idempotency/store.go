@@ -0,0 +1,213 @@
+// Package idempotency remembers the responses to requests carrying an
+// Idempotency-Key, so that a client retrying after a timeout gets the
+// original result instead of repeating the request
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header is the request header carrying the key
+const Header = "Idempotency-Key"
+
+// MaxKeyLength is the longest accepted key
+const MaxKeyLength = 255
+
+// ErrInvalidKey is returned for keys that are empty, too long or not
+// printable ASCII
+var ErrInvalidKey = errors.New("Idempotency-Key must be 1 to 255 printable ASCII characters")
+
+// Record is the remembered outcome of a request
+type Record struct {
+	// Fingerprint identifies the request the key was first used with
+	Fingerprint string          `json:"fingerprint"`
+	StatusCode  int             `json:"status_code"`
+	Body        json.RawMessage `json:"body"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// Store keeps one record file per key for the configured window
+type Store struct {
+	dir string
+	ttl time.Duration
+
+	mu       sync.Mutex
+	inFlight map[string]bool
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewStore opens the records in dir. Records expire after ttl; a ttl of
+// zero disables the store, so keys are accepted but ignored.
+func NewStore(dir string, ttl time.Duration) (*Store, error) {
+	s := &Store{dir: dir, ttl: ttl, inFlight: make(map[string]bool), done: make(chan struct{})}
+	if ttl <= 0 {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.expire()
+	return s, nil
+}
+
+// Enabled reports whether keys are remembered
+func (s *Store) Enabled() bool {
+	return s.ttl > 0
+}
+
+// ValidateKey checks the format of a client-supplied key
+func ValidateKey(key string) error {
+	if key == "" || len(key) > MaxKeyLength {
+		return ErrInvalidKey
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
+
+// Begin claims key for a request. It returns false if another request
+// using the key is still running, and otherwise the function releasing
+// the claim once the request's outcome is recorded. Only that key is
+// held, so unrelated requests never wait on each other.
+func (s *Store) Begin(key string) (func(), bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inFlight[key] {
+		return nil, false
+	}
+	s.inFlight[key] = true
+	return func() {
+		s.mu.Lock()
+		delete(s.inFlight, key)
+		s.mu.Unlock()
+	}, true
+}
+
+// Get returns the live record for key, or nil if there is none
+func (s *Store) Get(key string) (*Record, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(s.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	if time.Since(record.CreatedAt) > s.ttl {
+		return nil, nil
+	}
+	return &record, nil
+}
+
+// Put remembers the outcome of a request under key
+func (s *Store) Put(key string, record *Record) error {
+	if !s.Enabled() {
+		return nil
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	path := s.path(key)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Close stops expiring records
+func (s *Store) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	s.wg.Wait()
+}
+
+// path returns the record file for key. Keys are hashed since they may
+// contain characters that are not valid in filenames.
+func (s *Store) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// expire removes expired records until Close is called
+func (s *Store) expire() {
+	defer s.wg.Done()
+
+	interval := s.ttl
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.removeExpired()
+		}
+	}
+}
+
+// removeExpired deletes record files older than the window. Records are
+// written once, so the modification time is their creation time.
+func (s *Store) removeExpired() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		log.Printf("Failed to list idempotency records: %v", err)
+		return
+	}
+
+	cutoff := time.Now().Add(-s.ttl)
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, entry.Name())); err == nil {
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Printf("Expired %d idempotency records", removed)
+	}
+}
@@ -0,0 +1,222 @@
+// Package imaging decodes PNG, JPEG and GIF images and renders resized
+// thumbnails using only the standard library
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+)
+
+// Fit modes controlling how an image is mapped onto the requested box
+const (
+	// FitContain scales the image to fit inside the box, keeping its
+	// aspect ratio and never enlarging it
+	FitContain = "contain"
+	// FitCover scales the image to cover the box and crops the overflow
+	FitCover = "cover"
+	// FitFill stretches the image to exactly the box
+	FitFill = "fill"
+)
+
+// MaxDimension caps the width and height of a thumbnail
+const MaxDimension = 4096
+
+// jpegQuality is used when encoding JPEG thumbnails
+const jpegQuality = 85
+
+// ErrUnsupportedFormat is returned for content that is not a PNG, JPEG or
+// GIF image
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+// ErrTooManyPixels is returned for images whose decoded size exceeds the
+// configured limit
+var ErrTooManyPixels = errors.New("image has too many pixels")
+
+// Options describe a thumbnail. Either Width or Height may be 0, in which
+// case it follows from the aspect ratio of the source.
+type Options struct {
+	Width  int
+	Height int
+	Fit    string
+	// MaxPixels rejects sources larger than this many pixels before they
+	// are decoded; 0 disables the check
+	MaxPixels int64
+}
+
+// Supported reports whether contentType can be thumbnailed
+func Supported(contentType string) bool {
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif":
+		return true
+	}
+	return false
+}
+
+// OutputType returns the content type a thumbnail of contentType is encoded
+// as. JPEGs stay JPEG; PNG and GIF become PNG to keep transparency.
+func OutputType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// ParseFit validates a fit mode, defaulting to FitContain
+func ParseFit(fit string) (string, error) {
+	switch fit {
+	case "":
+		return FitContain, nil
+	case FitContain, FitCover, FitFill:
+		return fit, nil
+	}
+	return "", fmt.Errorf("unknown fit %q", fit)
+}
+
+// Thumbnail decodes the image read from r, resizes it according to opts
+// and encodes the result to w as outputType
+func Thumbnail(r io.Reader, opts Options, outputType string, w io.Writer) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	// Check the declared size before allocating the decoded image
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	if opts.MaxPixels > 0 && int64(config.Width)*int64(config.Height) > opts.MaxPixels {
+		return fmt.Errorf("%w: %dx%d exceeds %d pixels", ErrTooManyPixels, config.Width, config.Height, opts.MaxPixels)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+
+	dst := resize(src, opts)
+	if outputType == "image/jpeg" {
+		return jpeg.Encode(w, dst, &jpeg.Options{Quality: jpegQuality})
+	}
+	return png.Encode(w, dst)
+}
+
+// resize maps src onto the box described by opts
+func resize(src image.Image, opts Options) image.Image {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	if sw == 0 || sh == 0 {
+		return src
+	}
+
+	width, height := opts.Width, opts.Height
+	fit := opts.Fit
+	if width == 0 || height == 0 {
+		// With one side free every mode reduces to keeping the aspect ratio
+		if width == 0 {
+			width = int(math.Round(float64(sw) * float64(height) / float64(sh)))
+		} else {
+			height = int(math.Round(float64(sh) * float64(width) / float64(sw)))
+		}
+		if fit != FitContain {
+			fit = FitFill
+		}
+	}
+
+	crop := bounds
+	switch fit {
+	case FitContain:
+		ratio := math.Min(float64(width)/float64(sw), float64(height)/float64(sh))
+		if ratio > 1 {
+			ratio = 1
+		}
+		width = int(math.Round(float64(sw) * ratio))
+		height = int(math.Round(float64(sh) * ratio))
+	case FitCover:
+		// Crop the source to the box's aspect ratio around its centre
+		if float64(sw)*float64(height) > float64(sh)*float64(width) {
+			cw := int(math.Round(float64(sh) * float64(width) / float64(height)))
+			crop.Min.X += (sw - cw) / 2
+			crop.Max.X = crop.Min.X + cw
+		} else {
+			ch := int(math.Round(float64(sw) * float64(height) / float64(width)))
+			crop.Min.Y += (sh - ch) / 2
+			crop.Max.Y = crop.Min.Y + ch
+		}
+	}
+	width = clamp(width, 1, MaxDimension)
+	height = clamp(height, 1, MaxDimension)
+
+	return scale(toRGBA(src), crop, width, height)
+}
+
+// toRGBA converts src to premultiplied RGBA so averaging does not bleed
+// colour out of transparent pixels
+func toRGBA(src image.Image) *image.RGBA {
+	if rgba, ok := src.(*image.RGBA); ok {
+		return rgba
+	}
+	rgba := image.NewRGBA(src.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), src, src.Bounds().Min, draw.Src)
+	return rgba
+}
+
+// scale resamples the crop rectangle of src to width x height. Each output
+// pixel is the average of the source pixels it covers, which degrades to
+// nearest-neighbour sampling when enlarging.
+func scale(src *image.RGBA, crop image.Rectangle, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	cw, ch := crop.Dx(), crop.Dy()
+
+	for y := 0; y < height; y++ {
+		y0 := crop.Min.Y + y*ch/height
+		y1 := crop.Min.Y + (y+1)*ch/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := crop.Min.X + x*cw/width
+			x1 := crop.Min.X + (x+1)*cw/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[src.PixOffset(x0, sy):src.PixOffset(x1, sy)]
+				for i := 0; i < len(row); i += 4 {
+					r += uint64(row[i])
+					g += uint64(row[i+1])
+					b += uint64(row[i+2])
+					a += uint64(row[i+3])
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
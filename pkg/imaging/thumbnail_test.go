@@ -0,0 +1,106 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// encodePNG returns a PNG of the given size
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// thumbnailSize renders a thumbnail and returns its decoded dimensions
+func thumbnailSize(t *testing.T, src []byte, opts Options, outputType string) (int, int) {
+	t.Helper()
+	var out bytes.Buffer
+	if err := Thumbnail(bytes.NewReader(src), opts, outputType, &out); err != nil {
+		t.Fatalf("Thumbnail: %v", err)
+	}
+	config, format, err := image.DecodeConfig(&out)
+	if err != nil {
+		t.Fatalf("decoding thumbnail: %v", err)
+	}
+	if want := outputType[len("image/"):]; format != want {
+		t.Errorf("thumbnail is %s, want %s", format, want)
+	}
+	return config.Width, config.Height
+}
+
+func TestThumbnailSize(t *testing.T) {
+	src := encodePNG(t, 400, 200)
+
+	tests := []struct {
+		name          string
+		opts          Options
+		width, height int
+	}{
+		{"contain wide box", Options{Width: 100, Height: 100, Fit: FitContain}, 100, 50},
+		{"contain tall box", Options{Width: 300, Height: 60, Fit: FitContain}, 120, 60},
+		{"contain never enlarges", Options{Width: 800, Height: 800, Fit: FitContain}, 400, 200},
+		{"width only", Options{Width: 100, Fit: FitContain}, 100, 50},
+		{"height only", Options{Height: 50, Fit: FitContain}, 100, 50},
+		{"height only cover", Options{Height: 50, Fit: FitCover}, 100, 50},
+		{"cover", Options{Width: 100, Height: 100, Fit: FitCover}, 100, 100},
+		{"fill", Options{Width: 100, Height: 100, Fit: FitFill}, 100, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height := thumbnailSize(t, src, tt.opts, "image/png")
+			if width != tt.width || height != tt.height {
+				t.Errorf("thumbnail is %dx%d, want %dx%d", width, height, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestThumbnailJPEG(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 90, 60))
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("jpeg.Encode: %v", err)
+	}
+
+	width, height := thumbnailSize(t, buf.Bytes(), Options{Width: 30, Fit: FitContain}, OutputType("image/jpeg"))
+	if width != 30 || height != 20 {
+		t.Errorf("thumbnail is %dx%d, want 30x20", width, height)
+	}
+}
+
+func TestThumbnailMaxPixels(t *testing.T) {
+	src := encodePNG(t, 100, 100)
+
+	opts := Options{Width: 10, Height: 10, Fit: FitContain, MaxPixels: 100*100 - 1}
+	err := Thumbnail(bytes.NewReader(src), opts, "image/png", &bytes.Buffer{})
+	if !errors.Is(err, ErrTooManyPixels) {
+		t.Errorf("Thumbnail over MaxPixels returned %v, want ErrTooManyPixels", err)
+	}
+
+	opts.MaxPixels = 100 * 100
+	if err := Thumbnail(bytes.NewReader(src), opts, "image/png", &bytes.Buffer{}); err != nil {
+		t.Errorf("Thumbnail at MaxPixels: %v", err)
+	}
+}
+
+func TestThumbnailUnsupported(t *testing.T) {
+	err := Thumbnail(bytes.NewReader([]byte("not an image")), Options{Width: 10}, "image/png", &bytes.Buffer{})
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Thumbnail of text returned %v, want ErrUnsupportedFormat", err)
+	}
+}
@@ -0,0 +1,178 @@
+// Package integrity verifies uploaded content against the digests a client
+// sends in Content-MD5, Digest, Repr-Digest and X-Checksum-CRC32C headers
+package integrity
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Supported algorithms, named as in the HTTP digest algorithm registry
+const (
+	MD5    = "md5"
+	SHA256 = "sha-256"
+	SHA512 = "sha-512"
+	CRC32C = "crc32c"
+)
+
+// Errors returned when parsing or verifying digests
+var (
+	ErrInvalidDigest = errors.New("invalid digest header")
+	ErrMismatch      = errors.New("content does not match digest")
+)
+
+// Expected maps algorithms to the digests a client expects
+type Expected map[string][]byte
+
+// digestSizes lists the digest length of every supported algorithm
+var digestSizes = map[string]int{
+	MD5:    md5.Size,
+	SHA256: sha256.Size,
+	SHA512: sha512.Size,
+	CRC32C: crc32.Size,
+}
+
+// ParseHeaders collects expected digests from Content-MD5, Digest
+// (RFC 3230), Repr-Digest (RFC 9530) and X-Checksum-CRC32C. Algorithms
+// this package does not implement are ignored; malformed values and
+// headers contradicting each other are errors.
+func ParseHeaders(header http.Header) (Expected, error) {
+	expected := make(Expected)
+
+	if value := header.Get("Content-MD5"); value != "" {
+		if err := expected.add(MD5, value, decodeBase64); err != nil {
+			return nil, err
+		}
+	}
+
+	// Digest: sha-256=X48E9q...=, md5=...
+	for _, value := range header.Values("Digest") {
+		for _, item := range strings.Split(value, ",") {
+			alg, encoded, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				return nil, fmt.Errorf("%w: Digest %q", ErrInvalidDigest, item)
+			}
+			if err := expected.add(strings.ToLower(alg), encoded, decodeBase64); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Repr-Digest: sha-256=:X48E9q...=:, sha-512=:...:
+	for _, value := range header.Values("Repr-Digest") {
+		for _, item := range strings.Split(value, ",") {
+			alg, encoded, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok || len(encoded) < 2 || encoded[0] != ':' || encoded[len(encoded)-1] != ':' {
+				return nil, fmt.Errorf("%w: Repr-Digest %q", ErrInvalidDigest, item)
+			}
+			if err := expected.add(strings.ToLower(alg), encoded[1:len(encoded)-1], decodeBase64); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if value := header.Get("X-Checksum-CRC32C"); value != "" {
+		if err := expected.add(CRC32C, value, decodeCRC32C); err != nil {
+			return nil, err
+		}
+	}
+
+	return expected, nil
+}
+
+// add records one expected digest, skipping unsupported algorithms
+func (e Expected) add(alg, encoded string, decode func(string) ([]byte, error)) error {
+	size, ok := digestSizes[alg]
+	if !ok {
+		return nil
+	}
+	sum, err := decode(strings.TrimSpace(encoded))
+	if err != nil || len(sum) != size {
+		return fmt.Errorf("%w: %s value %q", ErrInvalidDigest, alg, encoded)
+	}
+	if previous, ok := e[alg]; ok && !bytes.Equal(previous, sum) {
+		return fmt.Errorf("%w: conflicting %s values", ErrInvalidDigest, alg)
+	}
+	e[alg] = sum
+	return nil
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// decodeCRC32C accepts the big-endian checksum as base64, as in
+// x-goog-hash, or as 8 hex digits
+func decodeCRC32C(s string) ([]byte, error) {
+	if len(s) == 2*crc32.Size {
+		if sum, err := hex.DecodeString(s); err == nil {
+			return sum, nil
+		}
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// Hasher computes every supported digest of the bytes written to it
+type Hasher struct {
+	hashes map[string]hash.Hash
+	w      io.Writer
+}
+
+// NewHasher returns a Hasher with no bytes written
+func NewHasher() *Hasher {
+	h := &Hasher{hashes: map[string]hash.Hash{
+		MD5:    md5.New(),
+		SHA256: sha256.New(),
+		SHA512: sha512.New(),
+		CRC32C: crc32.New(crc32.MakeTable(crc32.Castagnoli)),
+	}}
+	writers := make([]io.Writer, 0, len(h.hashes))
+	for _, hash := range h.hashes {
+		writers = append(writers, hash)
+	}
+	h.w = io.MultiWriter(writers...)
+	return h
+}
+
+// Write adds p to every digest
+func (h *Hasher) Write(p []byte) (int, error) {
+	return h.w.Write(p)
+}
+
+// Verify compares the digests of the bytes written so far with expected,
+// naming the first algorithm that does not match
+func (h *Hasher) Verify(expected Expected) error {
+	algs := make([]string, 0, len(expected))
+	for alg := range expected {
+		algs = append(algs, alg)
+	}
+	sort.Strings(algs)
+
+	for _, alg := range algs {
+		if !bytes.Equal(h.hashes[alg].Sum(nil), expected[alg]) {
+			return fmt.Errorf("%w: %s", ErrMismatch, alg)
+		}
+	}
+	return nil
+}
+
+// Sums returns every digest, base64 encoded as in the request headers
+func (h *Hasher) Sums() map[string]string {
+	sums := make(map[string]string, len(h.hashes))
+	for alg, hash := range h.hashes {
+		sums[alg] = base64.StdEncoding.EncodeToString(hash.Sum(nil))
+	}
+	return sums
+}
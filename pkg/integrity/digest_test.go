@@ -0,0 +1,102 @@
+package integrity
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"net/http"
+	"testing"
+)
+
+var content = []byte("hello, world")
+
+func sha256Base64(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func crc32c(data []byte) []byte {
+	sum := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+	return []byte{byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum)}
+}
+
+func TestParseHeaders(t *testing.T) {
+	md5Sum := md5.Sum(content)
+	sha := sha256Base64(content)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		algs    []string
+		err     bool
+	}{
+		{"content-md5", map[string]string{"Content-MD5": base64.StdEncoding.EncodeToString(md5Sum[:])}, []string{MD5}, false},
+		{"digest", map[string]string{"Digest": "SHA-256=" + sha + ", unknown=abc"}, []string{SHA256}, false},
+		{"repr-digest", map[string]string{"Repr-Digest": "sha-256=:" + sha + ":"}, []string{SHA256}, false},
+		{"repr-digest upper case", map[string]string{"Repr-Digest": "SHA-256=:" + sha + ":"}, []string{SHA256}, false},
+		{"agreeing headers", map[string]string{"Digest": "sha-256=" + sha, "Repr-Digest": "sha-256=:" + sha + ":"}, []string{SHA256}, false},
+		{"crc32c hex", map[string]string{"X-Checksum-CRC32C": hex.EncodeToString(crc32c(content))}, []string{CRC32C}, false},
+		{"crc32c base64", map[string]string{"X-Checksum-CRC32C": base64.StdEncoding.EncodeToString(crc32c(content))}, []string{CRC32C}, false},
+		{"conflicting headers", map[string]string{"Digest": "sha-256=" + sha, "Repr-Digest": "sha-256=:" + sha256Base64([]byte("other")) + ":"}, nil, true},
+		{"bad base64", map[string]string{"Content-MD5": "not base64!"}, nil, true},
+		{"wrong length", map[string]string{"Digest": "sha-256=" + base64.StdEncoding.EncodeToString(md5Sum[:])}, nil, true},
+		{"digest without value", map[string]string{"Digest": "sha-256"}, nil, true},
+		{"repr-digest without colons", map[string]string{"Repr-Digest": "sha-256=" + sha}, nil, true},
+		{"bad crc32c", map[string]string{"X-Checksum-CRC32C": "xyz"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := make(http.Header)
+			for key, value := range tt.headers {
+				header.Set(key, value)
+			}
+			expected, err := ParseHeaders(header)
+			if tt.err {
+				if !errors.Is(err, ErrInvalidDigest) {
+					t.Errorf("ParseHeaders returned %v, want ErrInvalidDigest", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseHeaders: %v", err)
+			}
+			if len(expected) != len(tt.algs) {
+				t.Errorf("ParseHeaders found %d digests, want %v", len(expected), tt.algs)
+			}
+			for _, alg := range tt.algs {
+				if _, ok := expected[alg]; !ok {
+					t.Errorf("ParseHeaders did not find %s", alg)
+				}
+			}
+
+			hasher := NewHasher()
+			hasher.Write(content)
+			if err := hasher.Verify(expected); err != nil {
+				t.Errorf("Verify: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	header := make(http.Header)
+	header.Set("X-Checksum-CRC32C", hex.EncodeToString(crc32c(content)))
+	header.Set("Repr-Digest", "sha-256=:"+sha256Base64(content)+":")
+	expected, err := ParseHeaders(header)
+	if err != nil {
+		t.Fatalf("ParseHeaders: %v", err)
+	}
+
+	hasher := NewHasher()
+	hasher.Write([]byte("hello, world!"))
+	if err := hasher.Verify(expected); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Verify of other content returned %v, want ErrMismatch", err)
+	}
+
+	if sums := hasher.Sums(); len(sums) != 4 || sums[SHA256] != sha256Base64([]byte("hello, world!")) {
+		t.Errorf("Sums = %v, want the four digests of what was written", sums)
+	}
+}
@@ -0,0 +1,31 @@
+package models
+
+// ArchiveEntry selects a stored file for an archive. Path defaults to the
+// file's original name.
+type ArchiveEntry struct {
+	ID   string `json:"id"`
+	Path string `json:"path,omitempty"`
+}
+
+// ArchiveRequest represents a request to download several files as one
+// archive
+type ArchiveRequest struct {
+	Format string         `json:"format,omitempty"`
+	Name   string         `json:"name,omitempty"`
+	Files  []ArchiveEntry `json:"files"`
+}
+
+// ExtractedFile is a file unpacked from an uploaded archive, with its path
+// relative to the archive root
+type ExtractedFile struct {
+	Path string `json:"path"`
+	*FileUploadResponse
+}
+
+// ExtractResponse lists the files created by unpacking an archive
+type ExtractResponse struct {
+	Files []*ExtractedFile `json:"files"`
+	Count int              `json:"count"`
+	// Digests are those of the uploaded archive
+	Digests map[string]string `json:"digests,omitempty"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "github.com/dvfs/storage-node/pkg/changes"
+
+// ChangesResponse is a page of the change log. Cursor is the since value
+// for the next page; More is set when further changes are already waiting.
+type ChangesResponse struct {
+	Changes []changes.Event `json:"changes"`
+	Cursor  uint64          `json:"cursor"`
+	More    bool            `json:"more"`
+}
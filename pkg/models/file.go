@@ -1,6 +1,7 @@
 package models
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -13,13 +14,85 @@ type FileMetadata struct {
 	Extension   string    `json:"extension"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	// Disk is the data directory holding the file; empty means the
+	// primary storage path
+	Disk string `json:"disk,omitempty"`
+	// Packed is set when the content lives in a packed volume file
+	Packed bool `json:"packed,omitempty"`
+	// Tier is the storage tier holding the file, "hot" or "cold"
+	Tier string `json:"tier,omitempty"`
+	// Compressed is set when the data file is gzipped
+	Compressed bool `json:"compressed,omitempty"`
+	// LastAccessedAt is updated on reads, at most once per hour
+	LastAccessedAt time.Time `json:"last_accessed_at"`
+
+	// UserMetadata holds X-Meta-* values supplied by the client
+	UserMetadata map[string]string `json:"metadata,omitempty"`
+	// Tags holds searchable key/value labels
+	Tags map[string]string `json:"tags,omitempty"`
+	// CacheControl is sent as the Cache-Control header on downloads
+	CacheControl string `json:"cache_control,omitempty"`
+
+	// Checksum is the hex SHA-256 of the content
+	Checksum string `json:"checksum,omitempty"`
+	// Appendable objects accept appends until they are sealed
+	Appendable bool `json:"appendable,omitempty"`
+	Sealed     bool `json:"sealed,omitempty"`
+	// HashState is the serialized SHA-256 state of an unsealed appendable
+	// object, so appends can extend Checksum without rereading the file
+	HashState []byte `json:"hash_state,omitempty"`
+
+	// DetectedType is the content type sniffed from the leading bytes
+	DetectedType string `json:"detected_type,omitempty"`
+	// TypeMismatch is set when DetectedType contradicts ContentType or the
+	// extension of OriginalName
+	TypeMismatch bool `json:"type_mismatch,omitempty"`
+
+	// Properties holds fields derived from the content, such as image
+	// dimensions, keyed by the extractor that produced them
+	Properties map[string]interface{} `json:"properties,omitempty"`
+
+	// ScanStatus is the malware scan state: "pending", "clean",
+	// "infected" or "failed"; empty when scanning is disabled
+	ScanStatus string `json:"scan_status,omitempty"`
+	// ScanSignature names the malware found in an infected file
+	ScanSignature string `json:"scan_signature,omitempty"`
+
+	// Copies is the replication factor of a file uploaded to this node,
+	// which keeps its Copies-1 replicas on peers up to date
+	Copies int `json:"copies,omitempty"`
+	// ReplicaVersion is set on a copy written by a peer node to the ETag
+	// the file had there; a replica of another version replaces it
+	ReplicaVersion string `json:"replica_version,omitempty"`
+}
+
+// ETag returns the entity tag identifying the current version of the file.
+// It changes whenever the content or metadata is updated.
+func (m *FileMetadata) ETag() string {
+	return fmt.Sprintf("\"%x-%x\"", m.UpdatedAt.UnixNano(), m.Size)
 }
 
 // FileUploadRequest represents the request structure for file upload
 type FileUploadRequest struct {
-	Content     []byte `json:"-"`
-	ContentType string `json:"content_type,omitempty"`
-	FileName    string `json:"file_name,omitempty"`
+	Content     []byte            `json:"-"`
+	ContentType string            `json:"content_type,omitempty"`
+	FileName    string            `json:"file_name,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Appendable  bool              `json:"appendable,omitempty"`
+	// CacheControl and Sealed carry the state of a replicated file
+	CacheControl string `json:"cache_control,omitempty"`
+	Sealed       bool   `json:"sealed,omitempty"`
+	// ID is a client-chosen file ID; empty assigns a new UUID
+	ID string `json:"-"`
+	// RequireScan scans the content before it is stored, whatever the
+	// configured scan mode
+	RequireScan bool `json:"-"`
+	// Copies is the replication factor recorded for the file
+	Copies int `json:"-"`
+	// ReplicaVersion marks the file as a copy of the given version of a
+	// file on a peer node
+	ReplicaVersion string `json:"-"`
 }
 
 // FileUploadResponse represents the response structure for file upload
@@ -30,6 +103,19 @@ type FileUploadResponse struct {
 	Size        int64  `json:"size"`
 	Extension   string `json:"extension"`
 	URL         string `json:"url"`
+	Checksum    string `json:"checksum,omitempty"`
+	Appendable  bool   `json:"appendable,omitempty"`
+	ScanStatus  string `json:"scan_status,omitempty"`
+	// Digests holds the digests computed while receiving an upload, keyed
+	// by algorithm and base64 encoded
+	Digests map[string]string `json:"digests,omitempty"`
+	// StagingToken commits or aborts a staged upload, which expires at
+	// StagingExpiresAt
+	StagingToken     string     `json:"staging_token,omitempty"`
+	StagingExpiresAt *time.Time `json:"staging_expires_at,omitempty"`
+	// Replicas lists the instances holding a copy of the file, starting
+	// with the one that received it
+	Replicas []string `json:"replicas,omitempty"`
 }
 
 // FileInfoResponse represents file information response
@@ -42,6 +128,67 @@ type FileInfoResponse struct {
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 	URL         string    `json:"url"`
+
+	Tier           string    `json:"tier,omitempty"`
+	LastAccessedAt time.Time `json:"last_accessed_at"`
+
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	CacheControl string            `json:"cache_control,omitempty"`
+
+	Checksum   string `json:"checksum,omitempty"`
+	Appendable bool   `json:"appendable,omitempty"`
+	Sealed     bool   `json:"sealed,omitempty"`
+
+	DetectedType string                 `json:"detected_type,omitempty"`
+	TypeMismatch bool                   `json:"type_mismatch,omitempty"`
+	Properties   map[string]interface{} `json:"properties,omitempty"`
+
+	ScanStatus    string `json:"scan_status,omitempty"`
+	ScanSignature string `json:"scan_signature,omitempty"`
+}
+
+// FileListResponse represents the response for a file listing
+type FileListResponse struct {
+	Files []*FileInfoResponse `json:"files"`
+	Count int                 `json:"count"`
+}
+
+// FilePatchRequest updates the descriptive attributes of a file. Omitted
+// fields are left unchanged.
+type FilePatchRequest struct {
+	OriginalName *string `json:"original_name,omitempty"`
+	ContentType  *string `json:"content_type,omitempty"`
+	CacheControl *string `json:"cache_control,omitempty"`
+}
+
+// MetadataPatchRequest edits user metadata and tags. Keys mapped to null
+// are removed, all other keys are added or replaced.
+type MetadataPatchRequest struct {
+	Metadata map[string]*string `json:"metadata,omitempty"`
+	Tags     map[string]*string `json:"tags,omitempty"`
+}
+
+// CopyRequest represents a server-side copy of a file
+type CopyRequest struct {
+	OriginalName string `json:"original_name,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+}
+
+// ComposeRequest concatenates existing files into a new one
+type ComposeRequest struct {
+	Sources      []string `json:"sources"`
+	OriginalName string   `json:"original_name,omitempty"`
+	ContentType  string   `json:"content_type,omitempty"`
+}
+
+// SliceRequest creates a new file from a byte range of an existing one. A
+// missing length extends to the end of the source.
+type SliceRequest struct {
+	Offset       int64  `json:"offset"`
+	Length       *int64 `json:"length,omitempty"`
+	OriginalName string `json:"original_name,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
 }
 
 // ErrorResponse represents error response structure
@@ -50,3 +197,12 @@ type ErrorResponse struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 }
+
+// PolicyErrorResponse is returned when the upload policy rejects a file
+type PolicyErrorResponse struct {
+	ErrorResponse
+	Action string `json:"action"`
+	Rule   string `json:"rule,omitempty"`
+	// Path names the archive entry that was rejected during extraction
+	Path string `json:"path,omitempty"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// ReconcileRequest lists the files a client believes are live on the node
+type ReconcileRequest struct {
+	IDs []string `json:"ids"`
+	// CreatedBefore is when the client took its snapshot; files created
+	// later are never reported as extras
+	CreatedBefore *time.Time `json:"created_before,omitempty"`
+	// DeleteExtras deletes stored files missing from IDs
+	DeleteExtras bool `json:"delete_extras,omitempty"`
+}
@@ -0,0 +1,11 @@
+package models
+
+// ReplicaResponse confirms a replica written by a peer node
+type ReplicaResponse struct {
+	ID         string `json:"id"`
+	InstanceID string `json:"instance_id"`
+	Checksum   string `json:"checksum"`
+	// ETag identifies the replica's version so a rollback only removes
+	// what was written
+	ETag string `json:"etag"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "github.com/dvfs/storage-node/pkg/webhook"
+
+// WebhookRequest creates a webhook subscription. A missing ID or secret is
+// generated; the secret is only returned in the response to this request.
+type WebhookRequest struct {
+	ID     string   `json:"id,omitempty"`
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+// WebhookListResponse lists the webhook subscriptions, without secrets
+type WebhookListResponse struct {
+	Webhooks []*webhook.Subscription `json:"webhooks"`
+	Count    int                     `json:"count"`
+}
+
+// WebhookDeliveriesResponse lists recent delivery attempts, newest first
+type WebhookDeliveriesResponse struct {
+	Deliveries []webhook.Attempt `json:"deliveries"`
+	Count      int               `json:"count"`
+}
@@ -0,0 +1,113 @@
+package policy
+
+import (
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Engine holds the active policy and reloads it when its file changes
+type Engine struct {
+	path    string
+	current atomic.Pointer[Policy]
+	modTime time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewEngine loads the policy at path. An empty path yields an engine that
+// allows every upload. With a positive reloadInterval the file is checked
+// for changes in the background until Close is called.
+func NewEngine(path string, reloadInterval time.Duration) (*Engine, error) {
+	e := &Engine{path: path, done: make(chan struct{})}
+	if path == "" {
+		e.current.Store(&Policy{Default: ActionAllow})
+		return e, nil
+	}
+
+	if err := e.reload(); err != nil {
+		return nil, err
+	}
+	if reloadInterval > 0 {
+		e.wg.Add(1)
+		go e.watch(reloadInterval)
+	}
+	return e, nil
+}
+
+// Evaluate decides an upload against the active policy
+func (e *Engine) Evaluate(upload Upload) Decision {
+	return e.current.Load().Evaluate(upload)
+}
+
+// SizeLimit returns the size limit of the active policy for an upload
+// whose attributes are partly known; see Policy.SizeLimit
+func (e *Engine) SizeLimit(upload Upload) (int64, bool) {
+	return e.current.Load().SizeLimit(upload)
+}
+
+// Close stops watching the policy file
+func (e *Engine) Close() {
+	e.closeOnce.Do(func() {
+		close(e.done)
+	})
+	e.wg.Wait()
+}
+
+// watch polls the policy file and reloads it when its modification time
+// changes. A policy that fails to parse is logged and the previous one
+// stays active.
+func (e *Engine) watch(interval time.Duration) {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(e.path)
+			if err != nil {
+				log.Printf("Failed to check upload policy %s: %v", e.path, err)
+				continue
+			}
+			if info.ModTime().Equal(e.modTime) {
+				continue
+			}
+			if err := e.reload(); err != nil {
+				log.Printf("Keeping previous upload policy: %v", err)
+				continue
+			}
+			log.Printf("Reloaded upload policy from %s", e.path)
+		}
+	}
+}
+
+// reload reads and activates the policy file
+func (e *Engine) reload() error {
+	info, err := os.Stat(e.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(e.path)
+	if err != nil {
+		return err
+	}
+
+	// Record the modification time even on failure so a broken file is
+	// reported once rather than on every poll
+	e.modTime = info.ModTime()
+
+	p, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	e.current.Store(p)
+	return nil
+}
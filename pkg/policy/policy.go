@@ -0,0 +1,318 @@
+// Package policy decides whether an upload may be stored, based on
+// declarative rules matching its content type, extension, sniffed type,
+// size and tags
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/dvfs/storage-node/pkg/utils"
+)
+
+// Actions a rule can take
+const (
+	ActionAllow       = "allow"
+	ActionDeny        = "deny"
+	ActionRequireScan = "require-scan"
+)
+
+// Policy is an ordered list of rules. The first matching rule decides;
+// uploads matching no rule get the Default action.
+type Policy struct {
+	Default string `json:"default,omitempty"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Rule matches uploads on every condition it specifies. A rule without
+// conditions matches everything.
+type Rule struct {
+	Name string `json:"name,omitempty"`
+	// ContentTypes and DetectedTypes accept exact types and wildcards
+	// such as "video/*" or "*"
+	ContentTypes  []string          `json:"content_types,omitempty"`
+	DetectedTypes []string          `json:"detected_types,omitempty"`
+	Extensions    []string          `json:"extensions,omitempty"`
+	SizeAbove     ByteSize          `json:"size_above,omitempty"`
+	SizeBelow     ByteSize          `json:"size_below,omitempty"`
+	Tags          map[string]string `json:"tags,omitempty"`
+	Action        string            `json:"action"`
+}
+
+// Upload describes a file about to be stored
+type Upload struct {
+	ContentType  string
+	DetectedType string
+	FileName     string
+	Size         int64
+	Tags         map[string]string
+}
+
+// Decision is the outcome of evaluating an upload
+type Decision struct {
+	Action string
+	// Rule names the rule that decided, or is empty for the default
+	Rule string
+	// SizeLimited is set when the deciding rule has a size condition, so
+	// a denial is about size rather than type
+	SizeLimited bool
+}
+
+// Allowed reports whether the upload may be stored without further checks
+func (d Decision) Allowed() bool {
+	return d.Action == ActionAllow
+}
+
+// Parse decodes and validates a policy document
+func Parse(data []byte) (*Policy, error) {
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	if p.Default == "" {
+		p.Default = ActionAllow
+	}
+	if !validAction(p.Default) {
+		return nil, fmt.Errorf("invalid policy: unknown default action %q", p.Default)
+	}
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Name == "" {
+			rule.Name = "rule-" + strconv.Itoa(i+1)
+		}
+		if !validAction(rule.Action) {
+			return nil, fmt.Errorf("invalid policy: rule %s has unknown action %q", rule.Name, rule.Action)
+		}
+	}
+	return &p, nil
+}
+
+// Evaluate returns the decision for an upload
+func (p *Policy) Evaluate(upload Upload) Decision {
+	for _, rule := range p.Rules {
+		if rule.matches(upload) {
+			return Decision{
+				Action:      rule.Action,
+				Rule:        rule.Name,
+				SizeLimited: rule.SizeAbove > 0 || rule.SizeBelow > 0,
+			}
+		}
+	}
+	return Decision{Action: p.Default}
+}
+
+// SizeLimit returns the largest size an upload with the given attributes
+// could have and still not be denied, so oversized requests can be turned
+// away before their body is read. Attributes left empty are not known yet
+// and the detected type never is; rules depending on them are assumed to
+// match or not, whichever allows more. ok is false if the size is not
+// limited.
+func (p *Policy) SizeLimit(upload Upload) (limit int64, ok bool) {
+	// The decision only changes at the sizes rules compare against
+	bounds := []int64{0}
+	for _, rule := range p.Rules {
+		if rule.SizeAbove > 0 {
+			bounds = append(bounds, int64(rule.SizeAbove)+1)
+		}
+		if rule.SizeBelow > 0 {
+			bounds = append(bounds, int64(rule.SizeBelow))
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+
+	// Each bound starts a range of sizes that share a decision
+	next := int64(-1)
+	for i := len(bounds) - 1; i >= 0; i-- {
+		if i < len(bounds)-1 && bounds[i] == bounds[i+1] {
+			continue
+		}
+		if p.mayAllow(upload, bounds[i]) {
+			if next < 0 {
+				return 0, false
+			}
+			return next - 1, true
+		}
+		next = bounds[i]
+	}
+	return 0, true
+}
+
+// mayAllow reports whether some values of the unknown attributes of
+// upload lead to a decision other than deny for the given size
+func (p *Policy) mayAllow(upload Upload, size int64) bool {
+	upload.Size = size
+	for _, rule := range p.Rules {
+		may, sure := rule.mayMatch(upload)
+		if !may {
+			continue
+		}
+		if rule.Action != ActionDeny {
+			return true
+		}
+		if sure {
+			return false
+		}
+	}
+	return p.Default != ActionDeny
+}
+
+// mayMatch is matches for an upload whose empty attributes are unknown.
+// may reports whether the rule matches for some values of them, sure
+// whether it matches for all.
+func (r *Rule) mayMatch(upload Upload) (may, sure bool) {
+	sure = true
+	if len(r.ContentTypes) > 0 {
+		if upload.ContentType == "" {
+			sure = false
+		} else if !matchType(r.ContentTypes, upload.ContentType) {
+			return false, false
+		}
+	}
+	if len(r.DetectedTypes) > 0 {
+		sure = false
+	}
+	if len(r.Extensions) > 0 {
+		if upload.FileName == "" {
+			sure = false
+		} else if !matchExtension(r.Extensions, upload.FileName) {
+			return false, false
+		}
+	}
+	if (r.SizeAbove > 0 && upload.Size <= int64(r.SizeAbove)) || (r.SizeBelow > 0 && upload.Size >= int64(r.SizeBelow)) {
+		return false, false
+	}
+	if len(r.Tags) > 0 {
+		if upload.Tags == nil {
+			sure = false
+		} else {
+			for key, value := range r.Tags {
+				if actual, ok := upload.Tags[key]; !ok || (value != "*" && actual != value) {
+					return false, false
+				}
+			}
+		}
+	}
+	return true, sure
+}
+
+func (r *Rule) matches(upload Upload) bool {
+	if len(r.ContentTypes) > 0 && !matchType(r.ContentTypes, upload.ContentType) {
+		return false
+	}
+	if len(r.DetectedTypes) > 0 && !matchType(r.DetectedTypes, upload.DetectedType) {
+		return false
+	}
+	if len(r.Extensions) > 0 && !matchExtension(r.Extensions, upload.FileName) {
+		return false
+	}
+	if r.SizeAbove > 0 && upload.Size <= int64(r.SizeAbove) {
+		return false
+	}
+	if r.SizeBelow > 0 && upload.Size >= int64(r.SizeBelow) {
+		return false
+	}
+	for key, value := range r.Tags {
+		if actual, ok := upload.Tags[key]; !ok || (value != "*" && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchType matches a content type against patterns such as "image/png",
+// "video/*" or "*"
+func matchType(patterns []string, contentType string) bool {
+	if contentType == "" {
+		return false
+	}
+	contentType = utils.MIME().Canonical(contentType)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		switch {
+		case pattern == "*" || pattern == "*/*":
+			return true
+		case strings.HasSuffix(pattern, "/*"):
+			if strings.HasPrefix(contentType, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		case utils.MIME().Canonical(pattern) == contentType:
+			return true
+		}
+	}
+	return false
+}
+
+// matchExtension matches the extension of name, case-insensitively
+func matchExtension(extensions []string, name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return false
+	}
+	for _, candidate := range extensions {
+		candidate = strings.ToLower(strings.TrimSpace(candidate))
+		if !strings.HasPrefix(candidate, ".") {
+			candidate = "." + candidate
+		}
+		if candidate == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func validAction(action string) bool {
+	switch action {
+	case ActionAllow, ActionDeny, ActionRequireScan:
+		return true
+	}
+	return false
+}
+
+// ByteSize is a size in bytes that can be written in JSON either as a
+// number or as a string such as "500MB" or "2GiB"
+type ByteSize int64
+
+// byteUnits maps size suffixes to multipliers
+var byteUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"kb":  1000,
+	"mb":  1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+// UnmarshalJSON accepts a number of bytes or a string with a unit
+func (s *ByteSize) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*s = ByteSize(n)
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("size must be a number or a string such as \"10MB\"")
+	}
+	text = strings.TrimSpace(text)
+	split := strings.IndexFunc(text, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+	if split < 0 {
+		split = len(text)
+	}
+	value, err := strconv.ParseFloat(text[:split], 64)
+	unit, ok := byteUnits[strings.ToLower(strings.TrimSpace(text[split:]))]
+	if err != nil || !ok || value < 0 {
+		return fmt.Errorf("invalid size %q", text)
+	}
+	*s = ByteSize(value * float64(unit))
+	return nil
+}
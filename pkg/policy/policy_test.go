@@ -0,0 +1,120 @@
+package policy
+
+import "testing"
+
+func mustParse(t *testing.T, document string) *Policy {
+	t.Helper()
+	p, err := Parse([]byte(document))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return p
+}
+
+func TestEvaluate(t *testing.T) {
+	p := mustParse(t, `{
+		"default": "deny",
+		"rules": [
+			{"name": "no-exe", "extensions": ["exe"], "action": "deny"},
+			{"name": "big-video", "content_types": ["video/*"], "size_above": "10MB", "action": "deny"},
+			{"name": "video", "content_types": ["video/*"], "action": "allow"},
+			{"name": "text", "detected_types": ["text/plain"], "size_below": 1024, "action": "allow"}
+		]
+	}`)
+
+	tests := []struct {
+		upload Upload
+		action string
+		rule   string
+	}{
+		{Upload{FileName: "setup.exe", ContentType: "video/mp4"}, ActionDeny, "no-exe"},
+		{Upload{FileName: "a.mp4", ContentType: "video/mp4", Size: 20_000_000}, ActionDeny, "big-video"},
+		{Upload{FileName: "a.mp4", ContentType: "video/mp4", Size: 10_000_000}, ActionAllow, "video"},
+		{Upload{FileName: "a.txt", DetectedType: "text/plain", Size: 1023}, ActionAllow, "text"},
+		{Upload{FileName: "a.txt", DetectedType: "text/plain", Size: 1024}, ActionDeny, ""},
+	}
+	for _, tt := range tests {
+		decision := p.Evaluate(tt.upload)
+		if decision.Action != tt.action || decision.Rule != tt.rule {
+			t.Errorf("Evaluate(%+v) = %s by %q, want %s by %q", tt.upload, decision.Action, decision.Rule, tt.action, tt.rule)
+		}
+	}
+}
+
+func TestSizeLimit(t *testing.T) {
+	p := mustParse(t, `{
+		"rules": [
+			{"name": "big-video", "content_types": ["video/*"], "size_above": 1000, "action": "deny"},
+			{"name": "small-images", "content_types": ["image/*"], "size_below": 500, "action": "allow"},
+			{"name": "images", "content_types": ["image/*"], "action": "deny"},
+			{"name": "tagged", "tags": {"class": "archive"}, "size_above": 5000, "action": "deny"},
+			{"name": "huge", "size_above": 100000, "action": "deny"}
+		]
+	}`)
+
+	tests := []struct {
+		name    string
+		upload  Upload
+		limit   int64
+		limited bool
+	}{
+		{"video", Upload{ContentType: "video/mp4", Tags: map[string]string{}}, 1000, true},
+		{"image", Upload{ContentType: "image/png", Tags: map[string]string{}}, 499, true},
+		{"other", Upload{ContentType: "text/plain", Tags: map[string]string{}}, 100000, true},
+		{"tagged", Upload{ContentType: "text/plain", Tags: map[string]string{"class": "archive"}}, 5000, true},
+		// With the type unknown the largest limit of any type applies
+		{"unknown type", Upload{Tags: map[string]string{}}, 100000, true},
+		// With the tags unknown the tagged rule may not apply
+		{"unknown tags", Upload{ContentType: "text/plain"}, 100000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, limited := p.SizeLimit(tt.upload)
+			if limit != tt.limit || limited != tt.limited {
+				t.Errorf("SizeLimit = %d, %v, want %d, %v", limit, limited, tt.limit, tt.limited)
+			}
+			if !limited {
+				return
+			}
+			// The limit is exact for known attributes
+			at, above := tt.upload, tt.upload
+			at.Size, above.Size = limit, limit+1
+			if tt.upload.ContentType != "" && tt.upload.Tags != nil {
+				if d := p.Evaluate(at); d.Action == ActionDeny {
+					t.Errorf("upload of %d bytes is denied by %s", limit, d.Rule)
+				}
+			}
+			if d := p.Evaluate(above); d.Action != ActionDeny {
+				t.Errorf("upload of %d bytes is allowed", limit+1)
+			}
+		})
+	}
+}
+
+func TestSizeLimitUnlimited(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		upload   Upload
+	}{
+		{"no rules", `{}`, Upload{}},
+		{"type rules only", `{"rules": [{"content_types": ["image/*"], "action": "deny"}]}`, Upload{ContentType: "text/plain"}},
+		{"other type limited", `{"rules": [{"content_types": ["video/*"], "size_above": 10, "action": "deny"}]}`, Upload{ContentType: "text/plain"}},
+		{"unknown type", `{"rules": [{"content_types": ["video/*"], "size_above": 10, "action": "deny"}]}`, Upload{}},
+		{"detected type", `{"default": "deny", "rules": [{"detected_types": ["text/plain"], "action": "allow"}]}`, Upload{ContentType: "text/plain"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if limit, limited := mustParse(t, tt.document).SizeLimit(tt.upload); limited {
+				t.Errorf("SizeLimit = %d, want no limit", limit)
+			}
+		})
+	}
+}
+
+func TestSizeLimitDeniedType(t *testing.T) {
+	p := mustParse(t, `{"rules": [{"content_types": ["application/x-msdownload"], "action": "deny"}]}`)
+	if limit, limited := p.SizeLimit(Upload{ContentType: "application/x-msdownload"}); !limited || limit != 0 {
+		t.Errorf("SizeLimit of a denied type = %d, %v, want 0, true", limit, limited)
+	}
+}
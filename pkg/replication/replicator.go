@@ -0,0 +1,368 @@
+// Package replication copies uploaded files to peer storage nodes, and
+// keeps the copies up to date as the files change, so that a file survives
+// the loss of the node that received it
+package replication
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// Headers of the internal replication endpoint
+const (
+	// FactorHeader selects the number of copies of an upload, counting
+	// the one on the receiving node
+	FactorHeader = "X-Replication-Factor"
+	// RequestHeader carries the base64 encoded JSON attributes of a replica
+	RequestHeader = "X-Replica-Request"
+	// ChecksumHeader carries the hex SHA-256 of a replica's content
+	ChecksumHeader = "X-Replica-Checksum"
+	// VersionHeader carries the ETag of the version a replica copies
+	VersionHeader = "X-Replica-Version"
+)
+
+// Path is the prefix of the internal replication endpoint
+const Path = "/internal/v1/replicas/"
+
+// maxResponseError is how much of a failed peer response is reported
+const maxResponseError = 256
+
+// Errors returned by Replicate
+var (
+	ErrQuorumNotReached = errors.New("write quorum not reached")
+	ErrInvalidFactor    = errors.New("replication factor exceeds the number of nodes")
+)
+
+// Replicator sends replicas to a fixed list of peers
+type Replicator struct {
+	peers  []string
+	secret string
+	client *http.Client
+
+	// wg tracks replicas still being written or rolled back after
+	// Replicate returned
+	wg sync.WaitGroup
+
+	// syncing holds the files whose replicas are being brought up to
+	// date, mapped to whether they changed again meanwhile
+	mu      sync.Mutex
+	syncing map[string]bool
+}
+
+// Replication records the replicas written of one file so they can be
+// removed again
+type Replication struct {
+	// Instances are the instance IDs holding the replicas written by the
+	// time Replicate returned
+	Instances []string
+
+	r       *Replicator
+	fileID  string
+	written []result
+	// done is closed once the replicas still in flight when Replicate
+	// returned are written or have failed
+	done chan struct{}
+}
+
+// result is the outcome of sending one replica
+type result struct {
+	peer    string
+	replica *models.ReplicaResponse
+	err     error
+}
+
+// NewReplicator creates a replicator for peers, the base URLs of the other
+// nodes. secret authenticates it to them when set.
+func NewReplicator(peers []string, secret string, timeout time.Duration) *Replicator {
+	trimmed := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		trimmed = append(trimmed, strings.TrimRight(peer, "/"))
+	}
+	return &Replicator{
+		peers:   trimmed,
+		secret:  secret,
+		client:  &http.Client{Timeout: timeout},
+		syncing: make(map[string]bool),
+	}
+}
+
+// MaxFactor returns the largest usable replication factor: one copy here
+// and one on every peer
+func (r *Replicator) MaxFactor() int {
+	return len(r.peers) + 1
+}
+
+// Close waits for replicas still in flight after their uploads returned
+func (r *Replicator) Close() {
+	r.wg.Wait()
+}
+
+// Replicate writes copies-1 replicas of a file stored on this node to its
+// peers and returns them once quorum copies, counting the local one, are
+// written. Replicas still in flight at that point carry on in the
+// background. If the quorum cannot be reached, ErrQuorumNotReached is
+// returned and the replicas that were written are removed again; removing
+// the local copy is up to the caller.
+func (r *Replicator) Replicate(metadata *models.FileMetadata, content []byte, copies, quorum int) (*Replication, error) {
+	if copies > r.MaxFactor() {
+		return nil, ErrInvalidFactor
+	}
+	rep := &Replication{r: r, fileID: metadata.ID, done: make(chan struct{})}
+	targets := r.targets(metadata.ID, copies-1)
+	if len(targets) == 0 {
+		close(rep.done)
+		return rep, nil
+	}
+
+	attributes, err := replicaAttributes(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(chan result, len(targets))
+	for _, peer := range targets {
+		go func(peer string) {
+			replica, err := r.send(peer, metadata, attributes, content)
+			results <- result{peer: peer, replica: replica, err: err}
+		}(peer)
+	}
+
+	// The local copy counts towards the quorum
+	needed := quorum - 1
+	failures := 0
+	received := 0
+	for len(rep.written) < needed && failures <= len(targets)-needed {
+		res := <-results
+		received++
+		if res.err != nil {
+			log.Printf("Failed to replicate %s to %s: %v", metadata.ID, res.peer, res.err)
+			failures++
+			continue
+		}
+		rep.written = append(rep.written, res)
+	}
+	for _, res := range rep.written {
+		rep.Instances = append(rep.Instances, res.replica.InstanceID)
+	}
+	reached := len(rep.written) >= needed
+
+	// Collect the replicas that land after this returns
+	pending := len(targets) - received
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer close(rep.done)
+		for i := 0; i < pending; i++ {
+			if res := <-results; res.err != nil {
+				log.Printf("Failed to replicate %s to %s: %v", metadata.ID, res.peer, res.err)
+			} else {
+				rep.written = append(rep.written, res)
+			}
+		}
+	}()
+
+	if !reached {
+		rep.Undo()
+		return nil, fmt.Errorf("%w: %d of %d copies written", ErrQuorumNotReached, len(rep.Instances)+1, quorum)
+	}
+	return rep, nil
+}
+
+// Undo removes every replica of the replication in the background,
+// including those still in flight when Replicate returned
+func (rep *Replication) Undo() {
+	r := rep.r
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		<-rep.done
+		for _, res := range rep.written {
+			if err := r.remove(res.peer, rep.fileID, res.replica.ETag); err != nil {
+				log.Printf("Failed to roll back replica of %s on %s: %v", rep.fileID, res.peer, err)
+			}
+		}
+	}()
+}
+
+// Sync brings the replicas of a file uploaded to this node up to date with
+// its current version in the background. load returns that version. Syncs
+// of the same file run one at a time, and a file that changes while its
+// replicas are written is loaded and sent again, so peers end up with the
+// latest version rather than whichever write arrives last.
+func (r *Replicator) Sync(fileID string, load func() ([]byte, *models.FileMetadata, error)) {
+	r.mu.Lock()
+	if _, running := r.syncing[fileID]; running {
+		r.syncing[fileID] = true
+		r.mu.Unlock()
+		return
+	}
+	r.syncing[fileID] = false
+	r.wg.Add(1)
+	r.mu.Unlock()
+
+	go func() {
+		defer r.wg.Done()
+		for {
+			r.sync(fileID, load)
+
+			r.mu.Lock()
+			if !r.syncing[fileID] {
+				delete(r.syncing, fileID)
+				r.mu.Unlock()
+				return
+			}
+			r.syncing[fileID] = false
+			r.mu.Unlock()
+		}
+	}()
+}
+
+// sync sends the current version of a file to every peer that should hold
+// a replica of it
+func (r *Replicator) sync(fileID string, load func() ([]byte, *models.FileMetadata, error)) {
+	content, metadata, err := load()
+	if err != nil {
+		// A deleted file has nothing left to send
+		if !strings.Contains(err.Error(), "not found") {
+			log.Printf("Failed to read %s for replication: %v", fileID, err)
+		}
+		return
+	}
+	attributes, err := replicaAttributes(metadata)
+	if err != nil {
+		log.Printf("Failed to replicate %s: %v", fileID, err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, peer := range r.targets(fileID, min(metadata.Copies-1, len(r.peers))) {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			if _, err := r.send(peer, metadata, attributes, content); err != nil {
+				log.Printf("Failed to update replica of %s on %s: %v", fileID, peer, err)
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// replicaAttributes encodes the attributes of a file sent with its replicas
+func replicaAttributes(metadata *models.FileMetadata) ([]byte, error) {
+	return json.Marshal(&models.FileUploadRequest{
+		ContentType:  metadata.ContentType,
+		FileName:     metadata.OriginalName,
+		Metadata:     metadata.UserMetadata,
+		Tags:         metadata.Tags,
+		Appendable:   metadata.Appendable,
+		CacheControl: metadata.CacheControl,
+		Sealed:       metadata.Sealed,
+	})
+}
+
+// targets picks n peers for a file. Peers are taken in order from a
+// position derived from the file ID so replicas spread across the cluster.
+func (r *Replicator) targets(fileID string, n int) []string {
+	if n <= 0 || len(r.peers) == 0 {
+		return nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(fileID))
+	start := int(h.Sum32() % uint32(len(r.peers)))
+
+	targets := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		targets = append(targets, r.peers[(start+i)%len(r.peers)])
+	}
+	return targets
+}
+
+// send writes one replica to peer
+func (r *Replicator) send(peer string, metadata *models.FileMetadata, attributes, content []byte) (*models.ReplicaResponse, error) {
+	req, err := http.NewRequest(http.MethodPut, peer+Path+url.PathEscape(metadata.ID), bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(RequestHeader, base64.StdEncoding.EncodeToString(attributes))
+	req.Header.Set(ChecksumHeader, metadata.Checksum)
+	req.Header.Set(VersionHeader, metadata.ETag())
+	r.authorize(req)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+
+	var replica models.ReplicaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&replica); err != nil {
+		return nil, fmt.Errorf("invalid replica response: %w", err)
+	}
+	if replica.Checksum != metadata.Checksum {
+		return nil, fmt.Errorf("peer stored checksum %s, expected %s", replica.Checksum, metadata.Checksum)
+	}
+	return &replica, nil
+}
+
+// remove deletes a replica written by send, provided it is still the
+// version that was written
+func (r *Replicator) remove(peer, fileID, etag string) error {
+	req, err := http.NewRequest(http.MethodDelete, peer+Path+url.PathEscape(fileID), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("If-Match", etag)
+	r.authorize(req)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// authorize adds the shared secret to a request to a peer
+func (r *Replicator) authorize(req *http.Request) {
+	if r.secret != "" {
+		req.Header.Set("Authorization", "Bearer "+r.secret)
+	}
+}
+
+// responseError describes an unexpected response from a peer
+func responseError(resp *http.Response) error {
+	excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseError))
+	message := strings.TrimSpace(string(excerpt))
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+	return fmt.Errorf("peer returned %d: %s", resp.StatusCode, message)
+}
+
+// Checksum returns the hex SHA-256 of content, as sent in ChecksumHeader
+func Checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,175 @@
+package replication
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// fakePeer stands in for a node's replication endpoint
+type fakePeer struct {
+	// status answers replicas; zero stores them
+	status int
+	// release, if set, holds replicas until it is closed
+	release chan struct{}
+
+	mu       sync.Mutex
+	auth     string
+	replicas int
+	removed  []string
+}
+
+func (p *fakePeer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.auth = r.Header.Get("Authorization")
+	p.mu.Unlock()
+
+	if r.Method == http.MethodDelete {
+		p.mu.Lock()
+		p.removed = append(p.removed, r.Header.Get("If-Match"))
+		p.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	content, _ := io.ReadAll(r.Body)
+	if p.release != nil {
+		<-p.release
+	}
+	if p.status != 0 {
+		http.Error(w, "unavailable", p.status)
+		return
+	}
+	p.mu.Lock()
+	p.replicas++
+	p.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&models.ReplicaResponse{
+		ID:         strings.TrimPrefix(r.URL.Path, Path),
+		InstanceID: "peer",
+		Checksum:   Checksum(content),
+		ETag:       `"v1"`,
+	})
+}
+
+// startPeers serves each fake peer and returns their base URLs
+func startPeers(t *testing.T, peers ...*fakePeer) []string {
+	t.Helper()
+	urls := make([]string, len(peers))
+	for i, peer := range peers {
+		server := httptest.NewServer(peer)
+		t.Cleanup(server.Close)
+		urls[i] = server.URL
+	}
+	return urls
+}
+
+func testFile(content string) *models.FileMetadata {
+	return &models.FileMetadata{
+		ID:           "file",
+		OriginalName: "file.txt",
+		ContentType:  "text/plain",
+		Checksum:     Checksum([]byte(content)),
+	}
+}
+
+func TestReplicateQuorum(t *testing.T) {
+	tests := []struct {
+		name    string
+		failing int
+		quorum  int
+		written int
+		err     error
+	}{
+		{"all written", 0, 4, 3, nil},
+		{"quorum despite a failure", 1, 3, 2, nil},
+		{"majority despite failures", 2, 2, 1, nil},
+		{"too many failures", 2, 3, 0, ErrQuorumNotReached},
+		{"every peer failing", 3, 2, 0, ErrQuorumNotReached},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peers := make([]*fakePeer, 3)
+			for i := range peers {
+				peers[i] = &fakePeer{}
+				if i < tt.failing {
+					peers[i].status = http.StatusServiceUnavailable
+				}
+			}
+			r := NewReplicator(startPeers(t, peers...), "s3cret", time.Second)
+
+			rep, err := r.Replicate(testFile("content"), []byte("content"), 4, tt.quorum)
+			r.Close()
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Replicate returned %v, want %v", err, tt.err)
+			}
+			if tt.err == nil && len(rep.Instances) < tt.written {
+				t.Errorf("Replicate reported %d replicas, want at least %d", len(rep.Instances), tt.written)
+			}
+
+			for _, peer := range peers {
+				if peer.auth != "Bearer s3cret" {
+					t.Errorf("peer was sent Authorization %q", peer.auth)
+				}
+				// A failed upload leaves no replica behind
+				if tt.err != nil && peer.replicas != len(peer.removed) {
+					t.Errorf("peer stored %d replicas and had %d removed", peer.replicas, len(peer.removed))
+				}
+				if tt.err == nil && len(peer.removed) != 0 {
+					t.Errorf("replica removed after a successful upload")
+				}
+			}
+		})
+	}
+}
+
+func TestReplicateRollsBackLateReplicas(t *testing.T) {
+	late := &fakePeer{release: make(chan struct{})}
+	failing := &fakePeer{status: http.StatusInternalServerError}
+	r := NewReplicator(startPeers(t, late, failing), "s3cret", 5*time.Second)
+
+	// With one peer failing, the quorum of three cannot be reached
+	// whatever the other one does
+	_, err := r.Replicate(testFile("content"), []byte("content"), 3, 3)
+	if !errors.Is(err, ErrQuorumNotReached) {
+		t.Fatalf("Replicate returned %v, want ErrQuorumNotReached", err)
+	}
+
+	// The replica written after Replicate returned is removed, by version
+	close(late.release)
+	r.Close()
+	if late.replicas != 1 || len(late.removed) != 1 || late.removed[0] != `"v1"` {
+		t.Errorf("late peer stored %d replicas and had %v removed, want one removed by its ETag", late.replicas, late.removed)
+	}
+}
+
+func TestReplicateRejectsWrongChecksum(t *testing.T) {
+	r := NewReplicator(startPeers(t, &fakePeer{}), "s3cret", time.Second)
+	defer r.Close()
+
+	// The peer reports the checksum of what it received
+	metadata := testFile("other content")
+	if _, err := r.Replicate(metadata, []byte("content"), 2, 2); !errors.Is(err, ErrQuorumNotReached) {
+		t.Errorf("Replicate of mismatched content returned %v, want ErrQuorumNotReached", err)
+	}
+}
+
+func TestReplicateFactor(t *testing.T) {
+	r := NewReplicator(startPeers(t, &fakePeer{}), "s3cret", time.Second)
+	defer r.Close()
+
+	if _, err := r.Replicate(testFile("content"), []byte("content"), 3, 2); !errors.Is(err, ErrInvalidFactor) {
+		t.Errorf("Replicate beyond the cluster returned %v, want ErrInvalidFactor", err)
+	}
+	if rep, err := r.Replicate(testFile("content"), []byte("content"), 1, 1); err != nil || len(rep.Instances) != 0 {
+		t.Errorf("Replicate of a single copy = %v, %v, want nothing sent", rep, err)
+	}
+}
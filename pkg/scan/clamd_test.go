@@ -0,0 +1,160 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveClamd answers INSTREAM commands on listener with the reply chosen
+// by respond for the streamed content
+func serveClamd(t *testing.T, listener net.Listener, respond func(content []byte) string) {
+	t.Helper()
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				command := make([]byte, len("zINSTREAM\x00"))
+				if _, err := io.ReadFull(conn, command); err != nil || string(command) != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+				var content bytes.Buffer
+				var size [4]byte
+				for {
+					if _, err := io.ReadFull(conn, size[:]); err != nil {
+						return
+					}
+					n := binary.BigEndian.Uint32(size[:])
+					if n == 0 {
+						break
+					}
+					if _, err := io.CopyN(&content, conn, int64(n)); err != nil {
+						return
+					}
+				}
+				conn.Write([]byte(respond(content.Bytes()) + "\x00"))
+			}()
+		}
+	}()
+}
+
+// eicar flags content containing "EICAR" and passes everything else
+func eicar(content []byte) string {
+	if bytes.Contains(content, []byte("EICAR")) {
+		return "stream: Eicar-Signature FOUND"
+	}
+	return "stream: OK"
+}
+
+func TestParseReply(t *testing.T) {
+	tests := []struct {
+		reply  string
+		result Result
+		err    bool
+	}{
+		{"stream: OK", Result{}, false},
+		{"stream: Eicar-Signature FOUND", Result{Infected: true, Signature: "Eicar-Signature"}, false},
+		{"stream: Win.Test.EICAR_HDB-1 FOUND", Result{Infected: true, Signature: "Win.Test.EICAR_HDB-1"}, false},
+		{"INSTREAM size limit exceeded. ERROR", Result{}, true},
+		{"stream: Can't allocate memory ERROR", Result{}, true},
+		{"", Result{}, true},
+		{"PONG", Result{}, true},
+	}
+	for _, tt := range tests {
+		result, err := parseReply(tt.reply)
+		if result != tt.result || (err != nil) != tt.err {
+			t.Errorf("parseReply(%q) = %+v, %v, want %+v and error %v", tt.reply, result, err, tt.result, tt.err)
+		}
+	}
+}
+
+func TestNewClamdAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		network string
+		target  string
+	}{
+		{"unix:///run/clamd.sock", "unix", "/run/clamd.sock"},
+		{"/run/clamd.sock", "unix", "/run/clamd.sock"},
+		{"tcp://127.0.0.1:3310", "tcp", "127.0.0.1:3310"},
+		{"clamd:3310", "tcp", "clamd:3310"},
+	}
+	for _, tt := range tests {
+		c, err := NewClamd(tt.address, 0)
+		if err != nil {
+			t.Errorf("NewClamd(%q): %v", tt.address, err)
+			continue
+		}
+		if c.network != tt.network || c.address != tt.target {
+			t.Errorf("NewClamd(%q) dials %s %s, want %s %s", tt.address, c.network, c.address, tt.network, tt.target)
+		}
+	}
+	for _, address := range []string{"", "tcp://", "unix://"} {
+		if _, err := NewClamd(address, 0); err == nil {
+			t.Errorf("NewClamd(%q) succeeded", address)
+		}
+	}
+}
+
+func TestClamdScan(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveClamd(t, tcp, eicar)
+	socket := filepath.Join(t.TempDir(), "clamd.sock")
+	unix, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveClamd(t, unix, eicar)
+
+	for _, address := range []string{"tcp://" + tcp.Addr().String(), "unix://" + socket} {
+		c, err := NewClamd(address, 5*time.Second)
+		if err != nil {
+			t.Fatalf("NewClamd: %v", err)
+		}
+
+		// Content larger than a chunk arrives whole
+		infected := strings.Repeat("x", clamdChunkSize) + "EICAR"
+		if result, err := c.Scan(context.Background(), strings.NewReader(infected)); err != nil || !result.Infected || result.Signature != "Eicar-Signature" {
+			t.Errorf("%s: Scan of infected content = %+v, %v", address, result, err)
+		}
+		for _, clean := range []string{"", "clean", strings.Repeat("y", 2*clamdChunkSize)} {
+			if result, err := c.Scan(context.Background(), strings.NewReader(clean)); err != nil || result.Infected {
+				t.Errorf("%s: Scan of %d clean bytes = %+v, %v", address, len(clean), result, err)
+			}
+		}
+	}
+}
+
+func TestClamdScanErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveClamd(t, listener, func([]byte) string { return "INSTREAM size limit exceeded. ERROR" })
+
+	c, _ := NewClamd(listener.Addr().String(), 5*time.Second)
+	if _, err := c.Scan(context.Background(), strings.NewReader("content")); err == nil || !strings.Contains(err.Error(), "size limit") {
+		t.Errorf("Scan with a clamd error returned %v, want the clamd message", err)
+	}
+
+	// Nothing listens on a closed port
+	listener.Close()
+	if _, err := c.Scan(context.Background(), strings.NewReader("content")); err == nil {
+		t.Error("Scan without clamd succeeded")
+	}
+}
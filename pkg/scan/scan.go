@@ -0,0 +1,24 @@
+// Package scan checks file content for malware
+package scan
+
+import (
+	"context"
+	"io"
+)
+
+// Result is the verdict for one piece of content
+type Result struct {
+	Infected bool
+	// Signature names the detected malware when Infected is set
+	Signature string
+}
+
+// Scanner inspects content for malware. Implementations must be safe for
+// concurrent use.
+type Scanner interface {
+	// Name identifies the scanner in logs
+	Name() string
+	// Scan reads r to the end and reports whether it is infected. An error
+	// means no verdict could be reached.
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
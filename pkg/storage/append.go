@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/changes"
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// Errors returned by Append and Seal
+var (
+	ErrNotAppendable  = errors.New("file is not appendable")
+	ErrSealed         = errors.New("file is sealed")
+	ErrOffsetMismatch = errors.New("expected offset does not match file size")
+)
+
+// Append adds data to the end of an appendable file. When expectedOffset is
+// not negative it must equal the current size, so concurrent writers cannot
+// interleave. Size and checksum are updated incrementally; on any failure
+// the file is left exactly as it was. A non-nil check vets the metadata
+// the file would have afterwards; its error is returned as is.
+func (fs *FileStorage) Append(fileID string, expectedOffset int64, ifMatch string, data []byte, check func(*models.FileMetadata) error) (*models.FileMetadata, error) {
+	fs.locks.Lock(fileID)
+	defer fs.locks.Unlock(fileID)
+
+	metadata, err := fs.loadMetadata(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("metadata not found: %s", fileID)
+	}
+
+	switch {
+	case !metadata.Appendable:
+		return nil, ErrNotAppendable
+	case metadata.Sealed:
+		return nil, ErrSealed
+	case metadata.ScanStatus == ScanInfected:
+		return nil, ErrQuarantined
+	case !MatchETag(ifMatch, metadata):
+		return nil, ErrPreconditionFailed
+	case expectedOffset >= 0 && expectedOffset != metadata.Size:
+		return metadata, ErrOffsetMismatch
+	}
+	if check != nil {
+		grown := *metadata
+		grown.Size += int64(len(data))
+		if err := check(&grown); err != nil {
+			return nil, err
+		}
+	}
+
+	hash := sha256.New()
+	if err := hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(metadata.HashState); err != nil {
+		return nil, fmt.Errorf("failed to restore hash state: %w", err)
+	}
+	hash.Write(data)
+	state, err := hash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to save hash state: %w", err)
+	}
+
+	path := fs.dataPath(metadata)
+	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	// Write at the recorded size rather than O_APPEND so that leftovers of
+	// an interrupted append are overwritten
+	oldSize := metadata.Size
+	if _, err := file.WriteAt(data, oldSize); err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Truncate(oldSize)
+		return nil, fmt.Errorf("failed to append: %w", err)
+	}
+
+	metadata.Size = oldSize + int64(len(data))
+	metadata.Checksum = hex.EncodeToString(hash.Sum(nil))
+	metadata.HashState = state
+	metadata.Properties = nil
+	metadata.UpdatedAt = time.Now()
+	metadata.ScanStatus = fs.rescanStatus()
+
+	if err := fs.saveMetadata(metadata); err != nil {
+		file.Truncate(oldSize)
+		return nil, fmt.Errorf("failed to save metadata: %w", err)
+	}
+	if err := file.Truncate(metadata.Size); err != nil {
+		return nil, fmt.Errorf("failed to trim file: %w", err)
+	}
+	fs.removeDerivatives(metadata)
+	fs.recordChange(changes.Update, metadata)
+	if metadata.ScanStatus == ScanPending {
+		fs.queueScan(fileID)
+	}
+	return metadata, nil
+}
+
+// Seal makes an appendable file immutable. Sealing twice is a no-op.
+func (fs *FileStorage) Seal(fileID, ifMatch string) (*models.FileMetadata, error) {
+	fs.locks.Lock(fileID)
+	defer fs.locks.Unlock(fileID)
+
+	metadata, err := fs.loadMetadata(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("metadata not found: %s", fileID)
+	}
+
+	switch {
+	case !metadata.Appendable:
+		return nil, ErrNotAppendable
+	case metadata.Sealed:
+		return metadata, nil
+	case !MatchETag(ifMatch, metadata):
+		return nil, ErrPreconditionFailed
+	}
+
+	metadata.Sealed = true
+	metadata.HashState = nil
+	metadata.UpdatedAt = time.Now()
+	if err := fs.saveMetadata(metadata); err != nil {
+		return nil, fmt.Errorf("failed to save metadata: %w", err)
+	}
+	fs.recordChange(changes.Update, metadata)
+	return metadata, nil
+}
@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"log"
+
+	"github.com/dvfs/storage-node/pkg/changes"
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// Changes returns the log of files created, updated and deleted
+func (fs *FileStorage) Changes() *changes.Log {
+	return fs.changes
+}
+
+// recordChange appends a change to the log. It is called under the file
+// lock once the change is on disk, so events for a file are in the order
+// the changes happened; a failure is logged since the change itself stands.
+func (fs *FileStorage) recordChange(eventType string, metadata *models.FileMetadata) {
+	etag := ""
+	if eventType != changes.Delete {
+		etag = metadata.ETag()
+	}
+	if _, err := fs.changes.Append(eventType, metadata.ID, etag); err != nil {
+		log.Printf("Failed to record %s of %s: %v", eventType, metadata.ID, err)
+	}
+}
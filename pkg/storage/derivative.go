@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// derivativesDir is the directory, next to the data files of a disk, that
+// holds cached renditions such as thumbnails
+const derivativesDir = "derivatives"
+
+// Derivative returns a rendition of a file identified by name, such as
+// "thumb-200x200-contain.jpg". A cached copy is returned when one exists for
+// the current content; otherwise render produces it from the original and
+// the result is cached next to it.
+func (fs *FileStorage) Derivative(fileID, name string, render func(src io.Reader, w io.Writer) error) ([]byte, *models.FileMetadata, error) {
+	fs.locks.RLock(fileID)
+	defer fs.locks.RUnlock(fileID)
+
+	metadata, err := fs.loadMetadata(fileID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("metadata not found: %s", fileID)
+	}
+	if err := fs.Servable(metadata); err != nil {
+		return nil, nil, err
+	}
+
+	// Keying on the checksum means a changed original never hits a stale
+	// rendition, even if an earlier invalidation failed
+	path := filepath.Join(fs.derivativesPath(metadata), metadata.Checksum+"-"+name)
+	if cached, err := os.ReadFile(path); err == nil {
+		return cached, metadata, nil
+	}
+
+	src, err := fs.openContent(metadata)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create derivatives directory: %w", err)
+	}
+
+	// Concurrent renders of the same rendition each use their own temp file
+	tmp, err := os.CreateTemp(filepath.Dir(path), name+".*.tmp")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create derivative: %w", err)
+	}
+	err = render(src, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, nil, err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read derivative: %w", err)
+	}
+	return content, metadata, nil
+}
+
+// derivativesPath returns the directory caching renditions of a file, on
+// the same disk as its data
+func (fs *FileStorage) derivativesPath(metadata *models.FileMetadata) string {
+	root := metadata.Disk
+	if root == "" {
+		root = fs.basePath
+	}
+	return filepath.Join(root, derivativesDir, metadata.ID)
+}
+
+// removeDerivatives drops every cached rendition of a file. Callers hold
+// the file's write lock.
+func (fs *FileStorage) removeDerivatives(metadata *models.FileMetadata) {
+	if err := os.RemoveAll(fs.derivativesPath(metadata)); err != nil {
+		log.Printf("Failed to remove derivatives of %s: %v", metadata.ID, err)
+	}
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// MaxComposeSources caps the number of ranges combined into one file
+const MaxComposeSources = 32
+
+// Errors returned by Compose
+var (
+	// ErrInvalidRange is returned when a byte range falls outside its source
+	ErrInvalidRange = errors.New("invalid range")
+	// ErrComposeTooLarge is returned when the ranges add up to more than
+	// the configured maximum size
+	ErrComposeTooLarge = errors.New("composed file too large")
+)
+
+// ByteRange selects Length bytes of a stored file starting at Offset. A
+// negative Length extends to the end of the file.
+type ByteRange struct {
+	FileID string
+	Offset int64
+	Length int64
+}
+
+// Open returns a reader over the content of a file. Callers must close it.
+func (fs *FileStorage) Open(fileID string) (io.ReadCloser, *models.FileMetadata, error) {
+	fs.locks.RLock(fileID)
+	defer fs.locks.RUnlock(fileID)
+
+	metadata, err := fs.loadMetadata(fileID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("metadata not found: %s", fileID)
+	}
+	if err := fs.Servable(metadata); err != nil {
+		return nil, nil, err
+	}
+
+	reader, err := fs.openContent(metadata)
+	if err != nil {
+		if os.IsNotExist(err) || errors.Is(err, errNeedleNotFound) {
+			return nil, nil, fmt.Errorf("file not found: %s", fileID)
+		}
+		return nil, nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return reader, metadata, nil
+}
+
+// Compose stores a new file made of the given ranges in order. Name and
+// content type come from req, falling back to the first source. Copying a
+// single whole file also carries over its custom metadata and tags unless
+// req sets its own. The content is assembled in memory, so the ranges may
+// add up to at most the configured maximum. A non-nil check vets the
+// assembled request before it is stored; its error is returned as is.
+func (fs *FileStorage) Compose(ranges []ByteRange, req *models.FileUploadRequest, check func(*models.FileUploadRequest) error) (*models.FileMetadata, error) {
+	if len(ranges) == 0 || len(ranges) > MaxComposeSources {
+		return nil, fmt.Errorf("%w: between 1 and %d sources are required", ErrInvalidRange, MaxComposeSources)
+	}
+
+	var buf bytes.Buffer
+	var first *models.FileMetadata
+	for _, rng := range ranges {
+		metadata, err := fs.readRange(&buf, rng)
+		if err != nil {
+			return nil, err
+		}
+		if first == nil {
+			first = metadata
+		}
+	}
+
+	derived := *req
+	derived.Content = buf.Bytes()
+	if derived.FileName == "" {
+		derived.FileName = first.OriginalName
+	}
+	if derived.ContentType == "" {
+		derived.ContentType = first.ContentType
+	}
+	if len(ranges) == 1 && ranges[0].Offset == 0 && ranges[0].Length < 0 {
+		if derived.Metadata == nil {
+			derived.Metadata = first.UserMetadata
+		}
+		if derived.Tags == nil {
+			derived.Tags = first.Tags
+		}
+	}
+	if check != nil {
+		if err := check(&derived); err != nil {
+			return nil, err
+		}
+	}
+
+	return fs.Store(&derived)
+}
+
+// readRange appends the selected bytes of a file to buf. Ranges that would
+// take buf over the maximum compose size are rejected before being read.
+func (fs *FileStorage) readRange(buf *bytes.Buffer, rng ByteRange) (*models.FileMetadata, error) {
+	reader, metadata, err := fs.Open(rng.FileID)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	if rng.Offset < 0 || rng.Offset > metadata.Size {
+		return nil, fmt.Errorf("%w: offset %d is outside %s (size %d)", ErrInvalidRange, rng.Offset, rng.FileID, metadata.Size)
+	}
+	length := rng.Length
+	if length < 0 {
+		length = metadata.Size - rng.Offset
+	}
+	if length > metadata.Size-rng.Offset {
+		return nil, fmt.Errorf("%w: %d bytes at offset %d exceed %s (size %d)", ErrInvalidRange, length, rng.Offset, rng.FileID, metadata.Size)
+	}
+	if fs.composeMaxBytes > 0 && int64(buf.Len())+length > fs.composeMaxBytes {
+		return nil, fmt.Errorf("%w: the result would exceed %d bytes", ErrComposeTooLarge, fs.composeMaxBytes)
+	}
+
+	if _, err := io.CopyN(io.Discard, reader, rng.Offset); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", rng.FileID, err)
+	}
+	if _, err := io.CopyN(buf, reader, length); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", rng.FileID, err)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+func TestComposeLimit(t *testing.T) {
+	fs := newTestStorage(t)
+	fs.composeMaxBytes = 10
+
+	a := mustStore(t, fs, &models.FileUploadRequest{Content: []byte("123456"), ContentType: "text/plain", FileName: "a.txt"})
+	b := mustStore(t, fs, &models.FileUploadRequest{Content: []byte("7890"), ContentType: "text/plain", FileName: "b.txt"})
+	c := mustStore(t, fs, &models.FileUploadRequest{Content: []byte("!"), ContentType: "text/plain", FileName: "c.txt"})
+
+	metadata, err := fs.Compose([]ByteRange{{FileID: a.ID, Length: -1}, {FileID: b.ID, Length: -1}}, &models.FileUploadRequest{}, nil)
+	if err != nil {
+		t.Fatalf("Compose at the limit: %v", err)
+	}
+	content, _, err := fs.Retrieve(metadata.ID)
+	if err != nil {
+		t.Fatalf("Retrieve: %v", err)
+	}
+	if string(content) != "1234567890" {
+		t.Errorf("composed content = %q", content)
+	}
+
+	_, err = fs.Compose([]ByteRange{{FileID: a.ID, Length: -1}, {FileID: b.ID, Length: -1}, {FileID: c.ID, Length: -1}}, &models.FileUploadRequest{}, nil)
+	if !errors.Is(err, ErrComposeTooLarge) {
+		t.Errorf("Compose over the limit returned %v, want ErrComposeTooLarge", err)
+	}
+
+	// A slice only counts the bytes it selects
+	if _, err := fs.Compose([]ByteRange{{FileID: metadata.ID, Offset: 2, Length: 8}}, &models.FileUploadRequest{}, nil); err != nil {
+		t.Errorf("slice within the limit: %v", err)
+	}
+}
+
+func TestComposeRejectsRangesPastEnd(t *testing.T) {
+	fs := newTestStorage(t)
+	a := mustStore(t, fs, &models.FileUploadRequest{Content: []byte("123456"), ContentType: "text/plain", FileName: "a.txt"})
+
+	for _, rng := range []ByteRange{
+		{FileID: a.ID, Offset: 2, Length: 5},
+		{FileID: a.ID, Offset: 7, Length: -1},
+		// Offset plus length overflows
+		{FileID: a.ID, Offset: 2, Length: math.MaxInt64},
+	} {
+		if _, err := fs.Compose([]ByteRange{rng}, &models.FileUploadRequest{}, nil); !errors.Is(err, ErrInvalidRange) {
+			t.Errorf("Compose of %d bytes at %d returned %v, want ErrInvalidRange", rng.Length, rng.Offset, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Disk states reported by DiskStatus
+const (
+	DiskHealthy  = "healthy"
+	DiskDegraded = "degraded"
+)
+
+// Disk is a single data directory managed by the node
+type Disk struct {
+	path string
+
+	mu         sync.RWMutex
+	degraded   bool
+	reason     string
+	degradedAt time.Time
+}
+
+// DiskStatus describes the state of a disk for monitoring endpoints
+type DiskStatus struct {
+	Path       string     `json:"path"`
+	Tier       string     `json:"tier"`
+	Status     string     `json:"status"`
+	Reason     string     `json:"reason,omitempty"`
+	DegradedAt *time.Time `json:"degraded_at,omitempty"`
+	FreeBytes  uint64     `json:"free_bytes,omitempty"`
+	TotalBytes uint64     `json:"total_bytes,omitempty"`
+}
+
+// newDisk prepares a data directory, marking it degraded if it is unusable
+func newDisk(path string) *Disk {
+	d := &Disk{path: path}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		d.markDegraded(fmt.Errorf("failed to create data directory: %w", err))
+		return d
+	}
+	if err := d.probe(); err != nil {
+		d.markDegraded(err)
+	}
+	return d
+}
+
+// Path returns the root directory of the disk
+func (d *Disk) Path() string {
+	return d.path
+}
+
+// Healthy reports whether the disk accepts new objects
+func (d *Disk) Healthy() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return !d.degraded
+}
+
+// Status returns a snapshot of the disk state
+func (d *Disk) Status() DiskStatus {
+	d.mu.RLock()
+	status := DiskStatus{Path: d.path, Status: DiskHealthy}
+	if d.degraded {
+		degradedAt := d.degradedAt
+		status.Status = DiskDegraded
+		status.Reason = d.reason
+		status.DegradedAt = &degradedAt
+	}
+	d.mu.RUnlock()
+
+	if free, total, err := diskUsage(d.path); err == nil {
+		status.FreeBytes = free
+		status.TotalBytes = total
+	}
+	return status
+}
+
+// markDegraded takes the disk out of placement after an I/O failure
+func (d *Disk) markDegraded(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.degraded {
+		d.degraded = true
+		d.degradedAt = time.Now()
+	}
+	d.reason = err.Error()
+}
+
+// markHealthy returns a recovered disk to placement
+func (d *Disk) markHealthy() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.degraded = false
+	d.reason = ""
+	d.degradedAt = time.Time{}
+}
+
+// probe verifies the disk is writable by creating and removing a small file
+func (d *Disk) probe() error {
+	probePath := filepath.Join(d.path, ".probe")
+	if err := os.WriteFile(probePath, []byte("ok"), 0644); err != nil {
+		return fmt.Errorf("disk not writable: %w", err)
+	}
+	if err := os.Remove(probePath); err != nil {
+		return fmt.Errorf("disk not writable: %w", err)
+	}
+	return nil
+}
+
+// freeBytes returns the available space on the disk, or 0 if unknown
+func (d *Disk) freeBytes() uint64 {
+	free, _, err := diskUsage(d.path)
+	if err != nil {
+		return 0
+	}
+	return free
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dvfs/storage-node/pkg/config"
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// jbodConfig returns a configuration spreading files over n disks
+func jbodConfig(t *testing.T, n int, placement string) *config.Config {
+	t.Helper()
+	base := t.TempDir()
+	cfg := &config.Config{StoragePath: base, PackVolumeSize: 1 << 20, Placement: placement}
+	for i := 0; i < n; i++ {
+		cfg.StoragePaths = append(cfg.StoragePaths, filepath.Join(base, fmt.Sprintf("disk%d", i)))
+	}
+	return cfg
+}
+
+func openStorage(t *testing.T, cfg *config.Config) *FileStorage {
+	t.Helper()
+	fs, err := NewFileStorage(cfg)
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+func storeText(t *testing.T, fs *FileStorage, content string) *models.FileMetadata {
+	t.Helper()
+	return mustStore(t, fs, &models.FileUploadRequest{Content: []byte(content), FileName: content + ".txt", ContentType: "text/plain"})
+}
+
+func TestRoundRobinPlacement(t *testing.T) {
+	cfg := jbodConfig(t, 3, config.PlacementRoundRobin)
+	fs := openStorage(t, cfg)
+
+	used := make(map[string]int)
+	for _, content := range []string{"a", "b", "c", "d", "e", "f"} {
+		used[storeText(t, fs, content).Disk]++
+	}
+	for _, path := range cfg.StoragePaths {
+		if used[path] != 2 {
+			t.Errorf("disk %s holds %d files, want 2 (placement %v)", path, used[path], used)
+		}
+	}
+}
+
+func TestPlacementSkipsFailedDisk(t *testing.T) {
+	cfg := jbodConfig(t, 2, config.PlacementRoundRobin)
+	fs := openStorage(t, cfg)
+
+	// The first disk stops accepting writes, as a full or read-only one
+	// would
+	broken := cfg.StoragePaths[0]
+	if err := os.RemoveAll(broken); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(broken, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, content := range []string{"a", "b", "c"} {
+		if metadata := storeText(t, fs, content); metadata.Disk != cfg.StoragePaths[1] {
+			t.Errorf("%s placed on %s, want the working disk", content, metadata.Disk)
+		}
+	}
+	if !fs.Degraded() {
+		t.Error("failed disk not reported as degraded")
+	}
+	for _, status := range fs.Disks() {
+		if status.Path == broken && (status.Status != DiskDegraded || status.Reason == "") {
+			t.Errorf("failed disk status = %+v, want degraded with a reason", status)
+		}
+	}
+}
+
+func TestDegradedDiskAtStartup(t *testing.T) {
+	cfg := jbodConfig(t, 2, config.PlacementFreeSpace)
+	if err := os.WriteFile(cfg.StoragePaths[0], nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The node starts on the disks that work
+	fs := openStorage(t, cfg)
+	if metadata := storeText(t, fs, "a"); metadata.Disk != cfg.StoragePaths[1] {
+		t.Errorf("file placed on %s, want the working disk", metadata.Disk)
+	}
+}
+
+func TestLookupAcrossDisksAfterRestart(t *testing.T) {
+	cfg := jbodConfig(t, 2, config.PlacementRoundRobin)
+	fs, err := NewFileStorage(cfg)
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	stored := make(map[string]string)
+	disks := make(map[string]bool)
+	for _, content := range []string{"a", "b", "c", "d"} {
+		metadata := storeText(t, fs, content)
+		stored[metadata.ID] = content
+		disks[metadata.Disk] = true
+	}
+	fs.Close()
+	if len(disks) != 2 {
+		t.Fatalf("files landed on %d disks, want 2", len(disks))
+	}
+
+	// Reopened with the disks listed in another order
+	cfg.StoragePaths[0], cfg.StoragePaths[1] = cfg.StoragePaths[1], cfg.StoragePaths[0]
+	reopened := openStorage(t, cfg)
+	for fileID, want := range stored {
+		content, _, err := reopened.Retrieve(fileID)
+		if err != nil || string(content) != want {
+			t.Errorf("Retrieve(%s) = %q, %v, want %q", fileID, content, err, want)
+		}
+	}
+}
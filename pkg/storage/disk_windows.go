@@ -0,0 +1,11 @@
+//go:build windows
+
+package storage
+
+import "errors"
+
+// diskUsage is not implemented on Windows; free-space placement falls back
+// to round-robin
+func diskUsage(path string) (free, total uint64, err error) {
+	return 0, 0, errors.New("disk usage not supported on windows")
+}
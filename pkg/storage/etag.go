@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"strings"
+
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// MatchETag evaluates an If-Match header value against the file. An empty
+// header or "*" always matches an existing file; otherwise any listed tag
+// must equal the current ETag. Weak tags are compared by their opaque value.
+func MatchETag(ifMatch string, metadata *models.FileMetadata) bool {
+	ifMatch = strings.TrimSpace(ifMatch)
+	if ifMatch == "" || ifMatch == "*" {
+		return true
+	}
+
+	current := metadata.ETag()
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == current {
+			return true
+		}
+	}
+	return false
+}
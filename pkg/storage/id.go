@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"errors"
+)
+
+// MaxIDLength is the longest client-chosen file ID
+const MaxIDLength = 128
+
+// Errors returned for client-chosen file IDs
+var (
+	ErrInvalidID  = errors.New("file ID must be 1 to 128 letters, digits, '-' or '_', starting with a letter or digit")
+	ErrFileExists = errors.New("file ID is already in use")
+)
+
+// ValidID reports whether id may be chosen by a client. IDs become part of
+// file names, so only characters that are safe on every filesystem are
+// allowed, and dots are excluded so an ID never looks like an extension.
+func ValidID(id string) bool {
+	if id == "" || len(id) > MaxIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case (c == '-' || c == '_') && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/dvfs/storage-node/pkg/config"
+	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/utils"
+)
+
+// ErrContentTypeMismatch is returned under the reject policy when content
+// does not match its declared type or filename extension
+var ErrContentTypeMismatch = errors.New("content does not match its declared type")
+
+// maxPropertiesSize bounds how much of a replaced file is read back to
+// refresh its properties; larger files simply have none
+const maxPropertiesSize = 64 << 20
+
+// typeMismatch reports whether the sniffed type contradicts the declared
+// content type or the type implied by the file name's extension
+func typeMismatch(contentType, name, detected string) bool {
+	if !utils.ContentTypeMatches(contentType, detected) {
+		return true
+	}
+	return !utils.ContentTypeMatches(utils.GetContentTypeFromExtension(name), detected)
+}
+
+// reconcileType applies the content type policy to an upload labelled
+// contentType whose content sniffed as detected. It returns the content
+// type to store and whether a mismatch remains to be flagged.
+func (fs *FileStorage) reconcileType(contentType, name, detected string) (string, bool, error) {
+	if !typeMismatch(contentType, name, detected) {
+		return contentType, false, nil
+	}
+
+	switch fs.typePolicy {
+	case config.TypePolicyReject:
+		return "", false, fmt.Errorf("%w: declared %q, detected %q", ErrContentTypeMismatch, contentType, detected)
+	case config.TypePolicyCorrect:
+		if utils.ContentTypeMatches(detected, contentType) {
+			// Only the extension disagrees; the declared type is right
+			return contentType, true, nil
+		}
+		return detected, true, nil
+	}
+	return contentType, true, nil
+}
+
+// inspect refreshes the fields derived from a file's content after it was
+// rewritten in place
+func (fs *FileStorage) inspect(metadata *models.FileMetadata) {
+	metadata.DetectedType = ""
+	metadata.TypeMismatch = false
+	metadata.Properties = nil
+
+	reader, err := fs.openContent(metadata)
+	if err != nil {
+		log.Printf("Failed to read %s for inspection: %v", metadata.ID, err)
+		return
+	}
+	defer reader.Close()
+
+	limit := int64(utils.SniffLen)
+	if metadata.Size <= maxPropertiesSize {
+		limit = metadata.Size
+	}
+	content, err := io.ReadAll(io.LimitReader(reader, limit))
+	if err != nil {
+		log.Printf("Failed to read %s for inspection: %v", metadata.ID, err)
+		return
+	}
+
+	metadata.DetectedType = utils.DetectContentType(content)
+	metadata.TypeMismatch = typeMismatch(metadata.ContentType, metadata.OriginalName, metadata.DetectedType)
+	if int64(len(content)) == metadata.Size {
+		metadata.Properties = fs.extractors.Run(metadata.ContentType, content)
+	}
+}
@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dvfs/storage-node/pkg/config"
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+func TestRejectPolicyAcceptsTextualTypes(t *testing.T) {
+	fs := newTestStorage(t)
+	fs.typePolicy = config.TypePolicyReject
+
+	for _, req := range []*models.FileUploadRequest{
+		{Content: []byte("{\"a\":1}\n{\"a\":2}\n"), ContentType: "application/x-ndjson", FileName: "events.ndjson"},
+		{Content: []byte("a,b\n1,2\n"), ContentType: "text/csv; charset=utf-8", FileName: "table.csv"},
+		{Content: []byte("a,b\n1,2\n"), ContentType: "application/csv", FileName: "table.csv"},
+	} {
+		if _, err := fs.Store(req); err != nil {
+			t.Errorf("Store(%s as %s): %v", req.FileName, req.ContentType, err)
+		}
+	}
+
+	_, err := fs.Store(&models.FileUploadRequest{Content: []byte("plain words"), ContentType: "image/png", FileName: "fake.png"})
+	if !errors.Is(err, ErrContentTypeMismatch) {
+		t.Errorf("Store of text as image/png returned %v, want ErrContentTypeMismatch", err)
+	}
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// inventorySkew is how far an inventory cursor lags behind the listing it
+// precedes, covering metadata that was being written as the listing started
+const inventorySkew = time.Second
+
+// InventoryCursor returns the since value for the pull after an inventory
+// that is about to start. Files may be repeated across pulls, never skipped.
+func InventoryCursor() time.Time {
+	return time.Now().Add(-inventorySkew)
+}
+
+// Inventory calls fn with the metadata of every stored file whose metadata
+// was written at or after since, in no particular order. A zero since
+// includes every file. Staged uploads are not included until they are
+// committed.
+func (fs *FileStorage) Inventory(since time.Time, fn func(*models.FileMetadata) error) error {
+	entries, err := os.ReadDir(filepath.Join(fs.basePath, "metadata"))
+	if err != nil {
+		return fmt.Errorf("failed to list metadata: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		// Metadata is written under a new name and renamed into place, so
+		// the modification time tracks every update
+		if !since.IsZero() {
+			info, err := entry.Info()
+			if err != nil || info.ModTime().Before(since) {
+				continue
+			}
+		}
+		metadata, err := fs.loadMetadata(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		if err := fn(metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReconcileReport compares the files a client believes are live with the
+// files actually stored
+type ReconcileReport struct {
+	// Stored is the number of files on this node, replicas included
+	Stored int `json:"stored"`
+	// Extras are stored files missing from the live list
+	Extras []string `json:"extras"`
+	// Replicas are stored replicas of files held for other nodes that are
+	// missing from the live list. They are never extras, nor deleted.
+	Replicas []string `json:"replicas"`
+	// Missing are live files that are not stored, or whose content is gone
+	Missing []string `json:"missing"`
+	// Deleted are the extras that were removed
+	Deleted []string `json:"deleted"`
+}
+
+// Reconcile compares live with the stored files. Files created at or after
+// createdBefore are never extras, so uploads racing with the client's
+// snapshot are left alone; a zero createdBefore considers every file. With
+// deleteExtras set, extras are deleted unless they changed while being
+// reconciled, and the metadata of the deleted files is returned. Replicas
+// belong to the node that received the file and are only reported.
+func (fs *FileStorage) Reconcile(live []string, createdBefore time.Time, deleteExtras bool) (*ReconcileReport, []*models.FileMetadata, error) {
+	wanted := make(map[string]bool, len(live))
+	for _, fileID := range live {
+		wanted[fileID] = true
+	}
+
+	report := &ReconcileReport{Extras: []string{}, Replicas: []string{}, Missing: []string{}, Deleted: []string{}}
+	stored := make(map[string]bool)
+	extras := make(map[string]*models.FileMetadata)
+	err := fs.walkMetadata(func(metadata *models.FileMetadata) error {
+		report.Stored++
+		stored[metadata.ID] = true
+		if wanted[metadata.ID] {
+			return nil
+		}
+		if metadata.ReplicaVersion != "" {
+			report.Replicas = append(report.Replicas, metadata.ID)
+			return nil
+		}
+		if !createdBefore.IsZero() && !metadata.CreatedAt.Before(createdBefore) {
+			return nil
+		}
+		report.Extras = append(report.Extras, metadata.ID)
+		extras[metadata.ID] = metadata
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list metadata: %w", err)
+	}
+
+	for fileID := range wanted {
+		if !stored[fileID] || !fs.Exists(fileID) {
+			report.Missing = append(report.Missing, fileID)
+		}
+	}
+	sort.Strings(report.Extras)
+	sort.Strings(report.Replicas)
+	sort.Strings(report.Missing)
+
+	var deleted []*models.FileMetadata
+	if deleteExtras {
+		for _, fileID := range report.Extras {
+			switch err := fs.Delete(fileID, extras[fileID].ETag()); {
+			case err == nil:
+				report.Deleted = append(report.Deleted, fileID)
+				deleted = append(deleted, extras[fileID])
+			case errors.Is(err, ErrPreconditionFailed):
+				// Changed since it was listed, so it is kept
+			default:
+				log.Printf("Failed to delete extra file %s: %v", fileID, err)
+			}
+		}
+	}
+	return report, deleted, nil
+}
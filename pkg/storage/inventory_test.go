@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+func TestReconcileKeepsReplicas(t *testing.T) {
+	fs := newTestStorage(t)
+	live := mustStore(t, fs, &models.FileUploadRequest{Content: []byte("live"), FileName: "live.txt", ContentType: "text/plain"})
+	extra := mustStore(t, fs, &models.FileUploadRequest{Content: []byte("extra"), FileName: "extra.txt", ContentType: "text/plain"})
+	replica := mustStore(t, fs, &models.FileUploadRequest{Content: []byte("replica"), FileName: "replica.txt", ContentType: "text/plain", ReplicaVersion: `"v1"`})
+
+	report, deleted, err := fs.Reconcile([]string{live.ID}, time.Time{}, true)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if report.Stored != 3 {
+		t.Errorf("Stored = %d, want 3", report.Stored)
+	}
+	if !reflect.DeepEqual(report.Extras, []string{extra.ID}) || !reflect.DeepEqual(report.Deleted, []string{extra.ID}) || len(deleted) != 1 {
+		t.Errorf("extras = %v, deleted = %v, want only %s", report.Extras, report.Deleted, extra.ID)
+	}
+	// Replicas held for other nodes are reported but never deleted
+	if !reflect.DeepEqual(report.Replicas, []string{replica.ID}) {
+		t.Errorf("Replicas = %v, want %s", report.Replicas, replica.ID)
+	}
+	if !fs.Exists(replica.ID) {
+		t.Error("reconciliation deleted a replica")
+	}
+}
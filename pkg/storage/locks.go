@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// lockStripes is the number of RW locks shared by all file IDs. Two IDs that
+// hash to the same stripe serialize against each other, which is harmless.
+const lockStripes = 256
+
+// lockManager hands out per-file-ID locks from a fixed set of stripes
+type lockManager struct {
+	stripes [lockStripes]sync.RWMutex
+}
+
+// stripe returns the lock guarding fileID
+func (lm *lockManager) stripe(fileID string) *sync.RWMutex {
+	h := fnv.New32a()
+	h.Write([]byte(fileID))
+	return &lm.stripes[h.Sum32()%lockStripes]
+}
+
+// Lock acquires exclusive access to fileID
+func (lm *lockManager) Lock(fileID string) {
+	lm.stripe(fileID).Lock()
+}
+
+// Unlock releases exclusive access to fileID
+func (lm *lockManager) Unlock(fileID string) {
+	lm.stripe(fileID).Unlock()
+}
+
+// RLock acquires shared access to fileID
+func (lm *lockManager) RLock(fileID string) {
+	lm.stripe(fileID).RLock()
+}
+
+// RUnlock releases shared access to fileID
+func (lm *lockManager) RUnlock(fileID string) {
+	lm.stripe(fileID).RUnlock()
+}
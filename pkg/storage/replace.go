@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/changes"
+	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/utils"
+)
+
+// Replace stores a new version of an existing file under the same ID. write
+// receives the current content and produces the new content; it may be
+// called more than once if a disk fails. The new version is written to a
+// temporary file and renamed into place, so readers see either the old or
+// the new content. A non-empty ifMatch must match the current ETag. A
+// non-nil check vets the metadata of the new version before it is
+// installed; its error is returned as is.
+func (fs *FileStorage) Replace(fileID, ifMatch string, write func(base io.ReaderAt, baseSize int64, w io.Writer) error, check func(*models.FileMetadata) error) (*models.FileMetadata, error) {
+	fs.locks.Lock(fileID)
+	defer fs.locks.Unlock(fileID)
+
+	metadata, err := fs.loadMetadata(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("metadata not found: %s", fileID)
+	}
+	if !MatchETag(ifMatch, metadata) {
+		return nil, ErrPreconditionFailed
+	}
+	if metadata.ScanStatus == ScanInfected {
+		return nil, ErrQuarantined
+	}
+
+	base, closeBase, err := fs.openBase(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open current version: %w", err)
+	}
+	defer closeBase()
+
+	hash := sha256.New()
+	var size int64
+	var head []byte
+	tmpName := metadata.ID + metadata.Extension + ".tmp"
+	disk, err := fs.streamToDisk(tmpName, func(w io.Writer) error {
+		hash.Reset()
+		size = 0
+		head = head[:0]
+		counter := writerFunc(func(p []byte) (int, error) {
+			size += int64(len(p))
+			if room := utils.SniffLen - len(head); room > 0 {
+				head = append(head, p[:min(room, len(p))]...)
+			}
+			return len(p), nil
+		})
+		return write(base, metadata.Size, io.MultiWriter(w, hash, counter))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// New versions always land uncompressed on the hot tier
+	updated := *metadata
+	updated.Disk = disk.Path()
+	updated.Packed = false
+	updated.Compressed = false
+	updated.Tier = TierHot
+	updated.Size = size
+	updated.Checksum = hex.EncodeToString(hash.Sum(nil))
+	updated.UpdatedAt = time.Now()
+	updated.ScanStatus = fs.rescanStatus()
+	updated.ScanSignature = ""
+	if updated.Appendable && !updated.Sealed {
+		if updated.HashState, err = hash.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+			os.Remove(filepath.Join(disk.Path(), tmpName))
+			return nil, fmt.Errorf("failed to save hash state: %w", err)
+		}
+	}
+
+	if check != nil {
+		candidate := updated
+		candidate.DetectedType = utils.DetectContentType(head)
+		if err := check(&candidate); err != nil {
+			os.Remove(filepath.Join(disk.Path(), tmpName))
+			return nil, err
+		}
+	}
+
+	newPath := fs.dataPath(&updated)
+	if err := os.Rename(filepath.Join(disk.Path(), tmpName), newPath); err != nil {
+		os.Remove(filepath.Join(disk.Path(), tmpName))
+		return nil, fmt.Errorf("failed to install new version: %w", err)
+	}
+	fs.inspect(&updated)
+
+	if err := fs.saveMetadata(&updated); err != nil {
+		if newPath != fs.dataPath(metadata) {
+			os.Remove(newPath)
+		}
+		return nil, fmt.Errorf("failed to save metadata: %w", err)
+	}
+
+	// Drop the old content unless the rename already replaced it
+	if metadata.Packed || fs.dataPath(metadata) != newPath {
+		if err := fs.removeContent(metadata); err != nil {
+			log.Printf("Failed to remove previous version of %s: %v", fileID, err)
+		}
+	}
+	fs.removeDerivatives(metadata)
+	fs.recordChange(changes.Update, &updated)
+	if updated.ScanStatus == ScanPending {
+		fs.queueScan(fileID)
+	}
+	return &updated, nil
+}
+
+// openBase returns random access to the current content of a file
+func (fs *FileStorage) openBase(metadata *models.FileMetadata) (io.ReaderAt, func(), error) {
+	if metadata.Packed || metadata.Compressed {
+		content, err := fs.readContent(metadata)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bytes.NewReader(content), func() {}, nil
+	}
+
+	file, err := os.Open(fs.dataPath(metadata))
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, func() { file.Close() }, nil
+}
+
+// writerFunc adapts a function to io.Writer
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
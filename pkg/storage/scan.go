@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/changes"
+	"github.com/dvfs/storage-node/pkg/config"
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// Scan statuses recorded in FileMetadata.ScanStatus
+const (
+	ScanPending  = "pending"
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+	ScanFailed   = "failed"
+)
+
+// scanSweepInterval is how often files still awaiting a verdict are queued
+// again, which also retries failed scans
+const scanSweepInterval = 5 * time.Minute
+
+// scanQueueSize bounds the files waiting for a background scan; overflow is
+// picked up by the next sweep
+const scanQueueSize = 1024
+
+// Errors returned for malware scanning
+var (
+	ErrInfected    = errors.New("malware detected")
+	ErrQuarantined = errors.New("file is quarantined")
+	ErrNotScanned  = errors.New("file has not passed a malware scan")
+	ErrScanFailed  = errors.New("malware scan failed")
+)
+
+// Scanning reports whether a malware scanner is configured
+func (fs *FileStorage) Scanning() bool {
+	return fs.scanner != nil
+}
+
+// Servable returns nil if the content of a file may be served, or the
+// reason it may not: ErrQuarantined for infected files and ErrNotScanned
+// for files without a clean verdict when unscanned files are blocked
+func (fs *FileStorage) Servable(metadata *models.FileMetadata) error {
+	switch metadata.ScanStatus {
+	case ScanInfected:
+		return ErrQuarantined
+	case ScanPending, ScanFailed:
+		if fs.blockUnscanned {
+			return ErrNotScanned
+		}
+	}
+	return nil
+}
+
+// scanUpload scans new content before it is stored when the upload has to
+// wait for its verdict, returning the status and signature to record
+func (fs *FileStorage) scanUpload(req *models.FileUploadRequest) (string, string, error) {
+	if fs.scanner == nil {
+		return "", "", nil
+	}
+	if fs.scanMode != config.ScanModeSync && !req.RequireScan {
+		return ScanPending, "", nil
+	}
+
+	result, err := fs.scanner.Scan(context.Background(), bytes.NewReader(req.Content))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+	if result.Infected {
+		return ScanInfected, result.Signature, nil
+	}
+	return ScanClean, "", nil
+}
+
+// rescanStatus is the status of a file whose content was just rewritten
+func (fs *FileStorage) rescanStatus() string {
+	if fs.scanner == nil {
+		return ""
+	}
+	return ScanPending
+}
+
+// queueScan hands a file to the background scanners without blocking
+func (fs *FileStorage) queueScan(fileID string) {
+	select {
+	case fs.scanQueue <- fileID:
+	default:
+		log.Printf("Scan queue is full, %s will be scanned by the next sweep", fileID)
+	}
+}
+
+// scanFiles runs a background scanner until Close is called
+func (fs *FileStorage) scanFiles() {
+	defer fs.wg.Done()
+
+	for {
+		select {
+		case <-fs.done:
+			return
+		case fileID := <-fs.scanQueue:
+			fs.scanFile(fileID)
+		}
+	}
+}
+
+// sweepScans queues every file still awaiting a verdict, at startup and
+// then every scanSweepInterval, until Close is called
+func (fs *FileStorage) sweepScans() {
+	defer fs.wg.Done()
+
+	ticker := time.NewTicker(scanSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		all, err := fs.listMetadata()
+		if err != nil {
+			log.Printf("Scan sweep failed: %v", err)
+		}
+		for _, metadata := range all {
+			if metadata.ScanStatus != ScanPending && metadata.ScanStatus != ScanFailed {
+				continue
+			}
+			select {
+			case fs.scanQueue <- metadata.ID:
+			case <-fs.done:
+				return
+			}
+		}
+
+		select {
+		case <-fs.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scanFile scans a stored file and records the verdict. The lock is not
+// held while scanning; if the content changes meanwhile the verdict is
+// dropped, since the new version is queued on its own.
+func (fs *FileStorage) scanFile(fileID string) {
+	fs.locks.RLock(fileID)
+	metadata, err := fs.loadMetadata(fileID)
+	if err != nil || (metadata.ScanStatus != ScanPending && metadata.ScanStatus != ScanFailed) {
+		fs.locks.RUnlock(fileID)
+		return
+	}
+	reader, err := fs.openContent(metadata)
+	fs.locks.RUnlock(fileID)
+	if err != nil {
+		log.Printf("Failed to open %s for scanning: %v", fileID, err)
+		return
+	}
+
+	result, scanErr := fs.scanner.Scan(context.Background(), io.LimitReader(reader, metadata.Size))
+	reader.Close()
+
+	fs.locks.Lock(fileID)
+	defer fs.locks.Unlock(fileID)
+
+	current, err := fs.loadMetadata(fileID)
+	if err != nil || current.Checksum != metadata.Checksum || current.Size != metadata.Size {
+		return
+	}
+
+	switch {
+	case scanErr != nil:
+		log.Printf("Malware scan of %s failed: %v", fileID, scanErr)
+		current.ScanStatus = ScanFailed
+	case result.Infected:
+		log.Printf("Malware found in %s: %s", fileID, result.Signature)
+		if err := fs.quarantine(current, result.Signature); err != nil {
+			log.Printf("Failed to quarantine %s: %v", fileID, err)
+		}
+		return
+	default:
+		current.ScanStatus = ScanClean
+	}
+	if err := fs.saveMetadata(current); err != nil {
+		log.Printf("Failed to record scan result for %s: %v", fileID, err)
+		return
+	}
+	fs.recordChange(changes.Update, current)
+}
+
+// quarantine moves an infected file's content to the quarantine directory
+// and records the verdict. The file keeps its ID and metadata so it can be
+// inspected or deleted, but is never served again.
+func (fs *FileStorage) quarantine(metadata *models.FileMetadata, signature string) error {
+	moved := *metadata
+	moved.Disk = fs.quarantinePath
+	moved.Packed = false
+	moved.ScanStatus = ScanInfected
+	moved.ScanSignature = signature
+	dst := fs.dataPath(&moved)
+
+	if metadata.Packed {
+		content, err := fs.volumes.Get(metadata.ID)
+		if err != nil {
+			return err
+		}
+		err = writeFile(dst, func(w io.Writer) error {
+			_, err := w.Write(content)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	} else if err := copyToTier(fs.dataPath(metadata), dst, false); err != nil {
+		return err
+	}
+
+	if err := fs.saveMetadata(&moved); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+	if err := fs.removeContent(metadata); err != nil {
+		log.Printf("Failed to remove infected content of %s: %v", metadata.ID, err)
+	}
+	fs.removeDerivatives(metadata)
+	fs.recordChange(changes.Update, &moved)
+	return nil
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/config"
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// startClamd serves a fake clamd that finds "EICAR" in streamed content
+// and returns its address
+func startClamd(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.ReadFull(conn, make([]byte, len("zINSTREAM\x00")))
+				var content bytes.Buffer
+				var size [4]byte
+				for {
+					if _, err := io.ReadFull(conn, size[:]); err != nil {
+						return
+					}
+					n := binary.BigEndian.Uint32(size[:])
+					if n == 0 {
+						break
+					}
+					io.CopyN(&content, conn, int64(n))
+				}
+				reply := "stream: OK\x00"
+				if bytes.Contains(content.Bytes(), []byte("EICAR")) {
+					reply = "stream: Eicar-Signature FOUND\x00"
+				}
+				conn.Write([]byte(reply))
+			}()
+		}
+	}()
+	return "tcp://" + listener.Addr().String()
+}
+
+// newScanningStorage creates a storage scanning uploads with the clamd at
+// address in the given mode
+func newScanningStorage(t *testing.T, address, mode string, blockUnscanned bool) *FileStorage {
+	t.Helper()
+	dir := t.TempDir()
+	fs, err := NewFileStorage(&config.Config{
+		StoragePath:        dir,
+		StoragePaths:       []string{dir},
+		PackVolumeSize:     1 << 20,
+		PackThreshold:      1 << 10,
+		Scanner:            config.ScannerClamd,
+		ClamdAddress:       address,
+		ScanMode:           mode,
+		ScanTimeout:        5 * time.Second,
+		ScanWorkers:        1,
+		ScanBlockUnscanned: blockUnscanned,
+	})
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+// waitForScan waits until the background scan of fileID reaches a verdict
+func waitForScan(t *testing.T, fs *FileStorage, fileID string) *models.FileMetadata {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		metadata, err := fs.GetMetadata(fileID)
+		if err != nil {
+			t.Fatalf("GetMetadata: %v", err)
+		}
+		if metadata.ScanStatus != ScanPending {
+			return metadata
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is still pending a scan", fileID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// checkQuarantined verifies that an infected file is recorded, kept in the
+// quarantine directory and never served
+func checkQuarantined(t *testing.T, fs *FileStorage, metadata *models.FileMetadata) {
+	t.Helper()
+	if metadata.ScanStatus != ScanInfected || metadata.ScanSignature != "Eicar-Signature" {
+		t.Errorf("scan status %q with signature %q, want infected by Eicar-Signature", metadata.ScanStatus, metadata.ScanSignature)
+	}
+	if metadata.Disk != fs.quarantinePath || metadata.Packed {
+		t.Errorf("content kept on %q (packed %v), want the quarantine directory", metadata.Disk, metadata.Packed)
+	}
+	if content, err := os.ReadFile(fs.dataPath(metadata)); err != nil || !bytes.Contains(content, []byte("EICAR")) {
+		t.Errorf("quarantined content = %q, %v", content, err)
+	}
+	if _, _, err := fs.Retrieve(metadata.ID); !errors.Is(err, ErrQuarantined) {
+		t.Errorf("Retrieve returned %v, want ErrQuarantined", err)
+	}
+	if _, _, err := fs.Open(metadata.ID); !errors.Is(err, ErrQuarantined) {
+		t.Errorf("Open returned %v, want ErrQuarantined", err)
+	}
+}
+
+func TestSyncScanQuarantinesInfectedUpload(t *testing.T) {
+	fs := newScanningStorage(t, startClamd(t), config.ScanModeSync, false)
+
+	_, err := fs.Store(&models.FileUploadRequest{Content: []byte("X5O EICAR test"), FileName: "bad.txt", ContentType: "text/plain"})
+	if !errors.Is(err, ErrInfected) {
+		t.Fatalf("Store of infected content returned %v, want ErrInfected", err)
+	}
+	all, err := fs.List(nil)
+	if err != nil || len(all) != 1 {
+		t.Fatalf("List = %d files, %v, want the quarantined one", len(all), err)
+	}
+	checkQuarantined(t, fs, all[0])
+
+	clean := mustStore(t, fs, &models.FileUploadRequest{Content: []byte("clean"), FileName: "good.txt", ContentType: "text/plain"})
+	if clean.ScanStatus != ScanClean {
+		t.Errorf("clean upload has scan status %q, want clean", clean.ScanStatus)
+	}
+	if content, _, err := fs.Retrieve(clean.ID); err != nil || string(content) != "clean" {
+		t.Errorf("Retrieve of a clean file = %q, %v", content, err)
+	}
+}
+
+func TestAsyncScanQuarantinesStoredFile(t *testing.T) {
+	fs := newScanningStorage(t, startClamd(t), config.ScanModeAsync, false)
+
+	// A small file is packed before the scan moves it out of its volume
+	infected := mustStore(t, fs, &models.FileUploadRequest{Content: []byte("X5O EICAR test"), FileName: "bad.txt", ContentType: "text/plain"})
+	if infected.ScanStatus != ScanPending || !infected.Packed {
+		t.Fatalf("upload has scan status %q (packed %v), want a packed pending file", infected.ScanStatus, infected.Packed)
+	}
+	checkQuarantined(t, fs, waitForScan(t, fs, infected.ID))
+	if _, err := fs.volumes.Get(infected.ID); err == nil {
+		t.Error("infected content is still in its volume")
+	}
+
+	clean := mustStore(t, fs, &models.FileUploadRequest{Content: []byte("clean"), FileName: "good.txt", ContentType: "text/plain"})
+	if metadata := waitForScan(t, fs, clean.ID); metadata.ScanStatus != ScanClean {
+		t.Errorf("clean file has scan status %q, want clean", metadata.ScanStatus)
+	}
+}
+
+func TestBlockUnscannedFiles(t *testing.T) {
+	// Nothing listens here, so scans keep failing
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := "tcp://" + listener.Addr().String()
+	listener.Close()
+
+	for _, block := range []bool{false, true} {
+		fs := newScanningStorage(t, address, config.ScanModeAsync, block)
+		metadata := mustStore(t, fs, &models.FileUploadRequest{Content: []byte("content"), FileName: "a.txt", ContentType: "text/plain"})
+		if metadata = waitForScan(t, fs, metadata.ID); metadata.ScanStatus != ScanFailed {
+			t.Fatalf("scan status %q with clamd down, want failed", metadata.ScanStatus)
+		}
+
+		_, _, err := fs.Retrieve(metadata.ID)
+		switch {
+		case block && !errors.Is(err, ErrNotScanned):
+			t.Errorf("Retrieve of a blocked file returned %v, want ErrNotScanned", err)
+		case !block && err != nil:
+			t.Errorf("Retrieve of an unscanned file returned %v with blocking off", err)
+		}
+	}
+
+	// Uploads that must wait for their verdict fail when clamd is down
+	fs := newScanningStorage(t, address, config.ScanModeSync, false)
+	if _, err := fs.Store(&models.FileUploadRequest{Content: []byte("content"), FileName: "a.txt", ContentType: "text/plain"}); !errors.Is(err, ErrScanFailed) {
+		t.Errorf("Store with clamd down returned %v, want ErrScanFailed", err)
+	}
+}
+
+func TestScanServable(t *testing.T) {
+	fs := newTestStorage(t)
+	fs.blockUnscanned = true
+	for status, want := range map[string]error{
+		"":           nil,
+		ScanClean:    nil,
+		ScanPending:  ErrNotScanned,
+		ScanFailed:   ErrNotScanned,
+		ScanInfected: ErrQuarantined,
+	} {
+		if err := fs.Servable(&models.FileMetadata{ScanStatus: status}); err != want {
+			t.Errorf("Servable with status %q = %v, want %v", status, err, want)
+		}
+	}
+}
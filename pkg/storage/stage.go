@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/changes"
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// stagingSweepInterval is how often expired staged uploads are aborted
+const stagingSweepInterval = time.Minute
+
+// ErrStagedNotFound is returned for a staging token that is unknown, has
+// expired, or was already committed or aborted
+var ErrStagedNotFound = errors.New("staged upload not found")
+
+// StagedUpload is an upload whose content is stored but which stays
+// invisible until it is committed with its token
+type StagedUpload struct {
+	Token     string               `json:"token"`
+	ExpiresAt time.Time            `json:"expires_at"`
+	Metadata  *models.FileMetadata `json:"metadata"`
+}
+
+// Stage stores a file like Store, but the file cannot be read, listed or
+// modified until CommitStaged is called with the returned token. Uploads
+// that are neither committed nor aborted within the staging timeout are
+// aborted automatically.
+func (fs *FileStorage) Stage(req *models.FileUploadRequest) (*StagedUpload, error) {
+	var staged *StagedUpload
+	_, err := fs.store(req, func(metadata *models.FileMetadata) error {
+		token, err := newStagingToken(metadata.ID)
+		if err != nil {
+			return err
+		}
+		staged = &StagedUpload{
+			Token:     token,
+			ExpiresAt: time.Now().Add(fs.stagingTimeout),
+			Metadata:  metadata,
+		}
+		return fs.saveStaged(staged)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return staged, nil
+}
+
+// CommitStaged publishes a staged upload, making the file visible under the
+// ID it was given when it was staged
+func (fs *FileStorage) CommitStaged(token string) (*models.FileMetadata, error) {
+	fileID, ok := stagingTokenID(token)
+	if !ok {
+		return nil, ErrStagedNotFound
+	}
+
+	fs.locks.Lock(fileID)
+	defer fs.locks.Unlock(fileID)
+
+	staged, err := fs.loadStaged(fileID, token)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(staged.ExpiresAt) {
+		if discarded, err := fs.abortStaged(staged); err == nil && discarded {
+			fs.stagedExpired(staged.Metadata)
+		}
+		return nil, ErrStagedNotFound
+	}
+
+	metadata := staged.Metadata
+	if err := fs.saveMetadata(metadata); err != nil {
+		return nil, fmt.Errorf("failed to save metadata: %w", err)
+	}
+	if err := os.Remove(fs.getStagedPath(fileID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove staging record %s: %v", fileID, err)
+	}
+
+	fs.recordChange(changes.Create, metadata)
+	if metadata.ScanStatus == ScanPending {
+		fs.queueScan(fileID)
+	}
+	return metadata, nil
+}
+
+// AbortStaged discards a staged upload and its content
+func (fs *FileStorage) AbortStaged(token string) error {
+	fileID, ok := stagingTokenID(token)
+	if !ok {
+		return ErrStagedNotFound
+	}
+
+	fs.locks.Lock(fileID)
+	defer fs.locks.Unlock(fileID)
+
+	staged, err := fs.loadStaged(fileID, token)
+	if err != nil {
+		return err
+	}
+	_, err = fs.abortStaged(staged)
+	return err
+}
+
+// OnStagedExpired registers fn to be called with the metadata of every
+// staged upload discarded because it was not committed in time
+func (fs *FileStorage) OnStagedExpired(fn func(*models.FileMetadata)) {
+	fs.hookMu.Lock()
+	defer fs.hookMu.Unlock()
+	fs.onExpired = fn
+}
+
+// stagedExpired runs the OnStagedExpired callback, if any
+func (fs *FileStorage) stagedExpired(metadata *models.FileMetadata) {
+	fs.hookMu.RLock()
+	fn := fs.onExpired
+	fs.hookMu.RUnlock()
+	if fn != nil {
+		fn(metadata)
+	}
+}
+
+// abortStaged removes the content and record of a staged upload. Content is
+// left alone if the upload was committed but its record survived, e.g.
+// after a crash during the commit; staged IDs are reserved, so metadata
+// under the same ID can only come from that commit. It reports whether the
+// content was discarded. The caller must hold the file lock.
+func (fs *FileStorage) abortStaged(staged *StagedUpload) (bool, error) {
+	fileID := staged.Metadata.ID
+	discarded := false
+	if _, err := os.Stat(fs.getMetadataPath(fileID)); os.IsNotExist(err) {
+		if err := fs.removeContent(staged.Metadata); err != nil {
+			return false, fmt.Errorf("failed to delete staged content: %w", err)
+		}
+		discarded = true
+	} else if err != nil {
+		return false, fmt.Errorf("failed to check file ID: %w", err)
+	}
+
+	if err := os.Remove(fs.getStagedPath(fileID)); err != nil && !os.IsNotExist(err) {
+		return discarded, fmt.Errorf("failed to delete staging record: %w", err)
+	}
+	return discarded, nil
+}
+
+// checkIDFree returns ErrFileExists if fileID belongs to a stored or staged
+// file. The caller must hold the file lock.
+func (fs *FileStorage) checkIDFree(fileID string) error {
+	for _, path := range []string{fs.getMetadataPath(fileID), fs.getStagedPath(fileID)} {
+		if _, err := os.Stat(path); err == nil {
+			return ErrFileExists
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to check file ID: %w", err)
+		}
+	}
+	return nil
+}
+
+// expireStaged aborts expired staged uploads every stagingSweepInterval
+// until Close is called
+func (fs *FileStorage) expireStaged() {
+	defer fs.wg.Done()
+
+	ticker := time.NewTicker(stagingSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-fs.done:
+			return
+		case <-ticker.C:
+			fs.abortExpired()
+		}
+	}
+}
+
+// abortExpired aborts every staged upload past its expiry
+func (fs *FileStorage) abortExpired() {
+	entries, err := os.ReadDir(fs.stagingPath())
+	if err != nil {
+		log.Printf("Failed to list staged uploads: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		fileID := strings.TrimSuffix(name, ".json")
+
+		fs.locks.Lock(fileID)
+		staged, err := fs.readStaged(fileID)
+		if err == nil && now.After(staged.ExpiresAt) {
+			if discarded, err := fs.abortStaged(staged); err != nil {
+				log.Printf("Failed to abort staged upload %s: %v", fileID, err)
+			} else if discarded {
+				log.Printf("Aborted expired staged upload %s", fileID)
+				fs.stagedExpired(staged.Metadata)
+			}
+		}
+		fs.locks.Unlock(fileID)
+	}
+}
+
+// loadStaged returns the staged upload of fileID if token matches it
+func (fs *FileStorage) loadStaged(fileID, token string) (*StagedUpload, error) {
+	staged, err := fs.readStaged(fileID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrStagedNotFound
+		}
+		return nil, fmt.Errorf("failed to load staging record: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(staged.Token), []byte(token)) != 1 {
+		return nil, ErrStagedNotFound
+	}
+	return staged, nil
+}
+
+// readStaged reads the staging record of fileID
+func (fs *FileStorage) readStaged(fileID string) (*StagedUpload, error) {
+	data, err := os.ReadFile(fs.getStagedPath(fileID))
+	if err != nil {
+		return nil, err
+	}
+	var staged StagedUpload
+	if err := json.Unmarshal(data, &staged); err != nil {
+		return nil, err
+	}
+	if staged.Metadata == nil || staged.Metadata.ID != fileID {
+		return nil, fmt.Errorf("staging record %s is corrupt", fileID)
+	}
+	return &staged, nil
+}
+
+// saveStaged writes a staging record through a temporary file
+func (fs *FileStorage) saveStaged(staged *StagedUpload) error {
+	path := fs.getStagedPath(staged.Metadata.ID)
+
+	data, err := json.MarshalIndent(staged, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// stagingPath returns the directory holding staging records
+func (fs *FileStorage) stagingPath() string {
+	return filepath.Join(fs.basePath, "staging")
+}
+
+// getStagedPath returns the path of the staging record of fileID
+func (fs *FileStorage) getStagedPath(fileID string) string {
+	return filepath.Join(fs.stagingPath(), fileID+".json")
+}
+
+// newStagingToken returns a token naming fileID and carrying a random
+// secret, so that only the client that staged the upload can finish it
+func newStagingToken(fileID string) (string, error) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate staging token: %w", err)
+	}
+	return fileID + "." + hex.EncodeToString(secret), nil
+}
+
+// stagingTokenID returns the file ID named by a staging token
+func stagingTokenID(token string) (string, bool) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 || !ValidID(token[:i]) {
+		return "", false
+	}
+	return token[:i], true
+}
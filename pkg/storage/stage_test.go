@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// stage stages content and returns the upload
+func stage(t *testing.T, fs *FileStorage, content string) *StagedUpload {
+	t.Helper()
+	staged, err := fs.Stage(&models.FileUploadRequest{Content: []byte(content), FileName: "staged.txt", ContentType: "text/plain"})
+	if err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+	return staged
+}
+
+func TestCommitStaged(t *testing.T) {
+	fs := newTestStorage(t)
+	fs.stagingTimeout = time.Hour
+	staged := stage(t, fs, "content")
+	fileID := staged.Metadata.ID
+
+	if _, _, err := fs.Retrieve(fileID); err == nil {
+		t.Fatal("staged file readable before its commit")
+	}
+	// The ID stays reserved while the upload is staged
+	if _, err := fs.Store(&models.FileUploadRequest{ID: fileID, Content: []byte("other"), FileName: "other.txt", ContentType: "text/plain"}); !errors.Is(err, ErrFileExists) {
+		t.Errorf("Store under a staged ID returned %v, want ErrFileExists", err)
+	}
+
+	// A wrong token, even one naming the same file, commits nothing
+	for _, token := range []string{"", "garbage", staged.Token + "x", staged.Token[:len(staged.Token)-1]} {
+		if _, err := fs.CommitStaged(token); !errors.Is(err, ErrStagedNotFound) {
+			t.Errorf("CommitStaged(%q) returned %v, want ErrStagedNotFound", token, err)
+		}
+		if err := fs.AbortStaged(token); !errors.Is(err, ErrStagedNotFound) {
+			t.Errorf("AbortStaged(%q) returned %v, want ErrStagedNotFound", token, err)
+		}
+	}
+
+	metadata, err := fs.CommitStaged(staged.Token)
+	if err != nil {
+		t.Fatalf("CommitStaged: %v", err)
+	}
+	if metadata.ID != fileID {
+		t.Errorf("committed file has ID %s, want %s", metadata.ID, fileID)
+	}
+	content, _, err := fs.Retrieve(fileID)
+	if err != nil || string(content) != "content" {
+		t.Errorf("committed file holds %q, %v", content, err)
+	}
+
+	// The token is spent
+	if _, err := fs.CommitStaged(staged.Token); !errors.Is(err, ErrStagedNotFound) {
+		t.Errorf("second CommitStaged returned %v, want ErrStagedNotFound", err)
+	}
+	if err := fs.AbortStaged(staged.Token); !errors.Is(err, ErrStagedNotFound) {
+		t.Errorf("AbortStaged after the commit returned %v, want ErrStagedNotFound", err)
+	}
+	if !fs.Exists(fileID) {
+		t.Error("abort after the commit removed the file")
+	}
+}
+
+func TestAbortStaged(t *testing.T) {
+	fs := newTestStorage(t)
+	fs.stagingTimeout = time.Hour
+	staged := stage(t, fs, "content")
+
+	if err := fs.AbortStaged(staged.Token); err != nil {
+		t.Fatalf("AbortStaged: %v", err)
+	}
+	if _, err := fs.CommitStaged(staged.Token); !errors.Is(err, ErrStagedNotFound) {
+		t.Errorf("CommitStaged after the abort returned %v, want ErrStagedNotFound", err)
+	}
+	// The ID is free again
+	mustStore(t, fs, &models.FileUploadRequest{ID: staged.Metadata.ID, Content: []byte("new"), FileName: "new.txt", ContentType: "text/plain"})
+}
+
+func TestStagedUploadsExpire(t *testing.T) {
+	fs := newTestStorage(t)
+	var expired []string
+	fs.OnStagedExpired(func(metadata *models.FileMetadata) {
+		expired = append(expired, metadata.ID)
+	})
+
+	fs.stagingTimeout = -time.Second
+	late := stage(t, fs, "late")
+	swept := stage(t, fs, "swept")
+	fs.stagingTimeout = time.Hour
+	live := stage(t, fs, "live")
+
+	// A commit after the deadline discards the upload
+	if _, err := fs.CommitStaged(late.Token); !errors.Is(err, ErrStagedNotFound) {
+		t.Errorf("CommitStaged after expiry returned %v, want ErrStagedNotFound", err)
+	}
+
+	// The sweep discards the rest and keeps uploads still in time
+	fs.abortExpired()
+	if len(expired) != 2 || expired[0] != late.Metadata.ID || expired[1] != swept.Metadata.ID {
+		t.Errorf("expired %v, want %s then %s", expired, late.Metadata.ID, swept.Metadata.ID)
+	}
+	if _, err := fs.CommitStaged(swept.Token); !errors.Is(err, ErrStagedNotFound) {
+		t.Errorf("CommitStaged of a swept upload returned %v, want ErrStagedNotFound", err)
+	}
+	if _, err := fs.CommitStaged(live.Token); err != nil {
+		t.Errorf("CommitStaged of an upload in time: %v", err)
+	}
+}
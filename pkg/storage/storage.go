@@ -1,24 +1,82 @@
 package storage
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/dvfs/storage-node/pkg/changes"
+	"github.com/dvfs/storage-node/pkg/config"
+	"github.com/dvfs/storage-node/pkg/extract"
 	"github.com/dvfs/storage-node/pkg/models"
+	"github.com/dvfs/storage-node/pkg/scan"
 	"github.com/dvfs/storage-node/pkg/utils"
 	"github.com/google/uuid"
 )
 
+// diskProbeInterval is how often degraded disks are re-checked
+const diskProbeInterval = 30 * time.Second
+
+// ErrPreconditionFailed is returned when an If-Match precondition does not
+// match the current version of a file
+var ErrPreconditionFailed = errors.New("precondition failed")
+
 // FileStorage handles local file operations with metadata
 type FileStorage struct {
-	basePath string
+	basePath  string
+	locks     lockManager
+	disks     []*Disk
+	placement string
+	next      uint64
+
+	volumes         *volumeStore
+	packThreshold   int64
+	compactInterval time.Duration
+	compactRatio    float64
+
+	cold             *Disk
+	coldAfter        time.Duration
+	coldCompress     bool
+	tierMoveInterval time.Duration
+
+	typePolicy string
+	extractors *extract.Registry
+
+	composeMaxBytes int64
+
+	scanner        scan.Scanner
+	scanMode       string
+	blockUnscanned bool
+	quarantinePath string
+	scanQueue      chan string
+
+	stagingTimeout time.Duration
+	hookMu         sync.RWMutex
+	onExpired      func(*models.FileMetadata)
+
+	changes *changes.Log
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
 }
 
 // NewFileStorage creates a new FileStorage instance
-func NewFileStorage(basePath string) (*FileStorage, error) {
+func NewFileStorage(cfg *config.Config) (*FileStorage, error) {
+	basePath := cfg.StoragePath
+
 	// Create the base directory if it doesn't exist
 	if err := os.MkdirAll(basePath, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create storage directory: %w", err)
@@ -26,49 +84,203 @@ func NewFileStorage(basePath string) (*FileStorage, error) {
 
 	// Create metadata directory
 	metadataPath := filepath.Join(basePath, "metadata")
-	if err := os.MkdirAll(metadataPath, 0755); err != nil {
+	err := os.MkdirAll(metadataPath, 0755)
+	if err != nil {
 		return nil, fmt.Errorf("failed to create metadata directory: %w", err)
 	}
 
-	return &FileStorage{
-		basePath: basePath,
-	}, nil
+	// A failing disk is degraded rather than fatal so the node keeps serving
+	disks := make([]*Disk, 0, len(cfg.StoragePaths))
+	for _, path := range cfg.StoragePaths {
+		disk := newDisk(path)
+		if !disk.Healthy() {
+			log.Printf("Disk %s is degraded: %s", path, disk.Status().Reason)
+		}
+		disks = append(disks, disk)
+	}
+	if len(disks) == 0 {
+		disks = append(disks, newDisk(basePath))
+	}
+
+	fs := &FileStorage{
+		basePath:         basePath,
+		disks:            disks,
+		placement:        cfg.Placement,
+		packThreshold:    cfg.PackThreshold,
+		compactInterval:  cfg.CompactInterval,
+		compactRatio:     cfg.CompactRatio,
+		coldAfter:        cfg.ColdAfter,
+		coldCompress:     cfg.ColdCompress,
+		tierMoveInterval: cfg.TierMoveInterval,
+		typePolicy:       cfg.ContentTypePolicy,
+		extractors:       extract.Default(),
+		composeMaxBytes:  cfg.ComposeMaxBytes,
+		scanMode:         cfg.ScanMode,
+		blockUnscanned:   cfg.ScanBlockUnscanned,
+		quarantinePath:   cfg.QuarantinePath,
+		stagingTimeout:   cfg.StagingTimeout,
+		done:             make(chan struct{}),
+	}
+
+	if cfg.Scanner == config.ScannerClamd {
+		fs.scanner, err = scan.NewClamd(cfg.ClamdAddress, cfg.ScanTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure scanner: %w", err)
+		}
+	}
+	if fs.scanner != nil {
+		if fs.quarantinePath == "" {
+			fs.quarantinePath = filepath.Join(basePath, "quarantine")
+		}
+		if err := os.MkdirAll(fs.quarantinePath, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create quarantine directory: %w", err)
+		}
+		fs.scanQueue = make(chan string, scanQueueSize)
+	}
+
+	fs.changes, err = changes.Open(filepath.Join(basePath, "changes"), cfg.ChangesRetention)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(fs.stagingPath(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	if cfg.ColdStoragePath != "" {
+		fs.cold = newDisk(cfg.ColdStoragePath)
+		if !fs.cold.Healthy() {
+			log.Printf("Cold tier %s is degraded: %s", cfg.ColdStoragePath, fs.cold.Status().Reason)
+		}
+	}
+
+	// Volumes are opened even with packing disabled so that objects packed
+	// under a previous configuration stay readable
+	fs.volumes, err = openVolumeStore(filepath.Join(basePath, "volumes"), cfg.PackVolumeSize)
+	if err != nil {
+		return nil, err
+	}
+	fs.pruneVolumes()
+
+	fs.wg.Add(2)
+	go fs.probeDisks()
+	go fs.expireStaged()
+
+	if fs.compactInterval > 0 {
+		fs.wg.Add(1)
+		go fs.compactVolumes()
+	}
+
+	if fs.cold != nil && fs.coldAfter > 0 && fs.tierMoveInterval > 0 {
+		fs.wg.Add(1)
+		go fs.moveColdFiles()
+	}
+
+	if fs.scanner != nil {
+		workers := cfg.ScanWorkers
+		if workers < 1 {
+			workers = 1
+		}
+		fs.wg.Add(workers + 1)
+		for i := 0; i < workers; i++ {
+			go fs.scanFiles()
+		}
+		go fs.sweepScans()
+	}
+
+	return fs, nil
 }
 
-// Store saves a file with metadata and returns file information
-func (fs *FileStorage) Store(content []byte, originalName, contentType string) (*models.FileMetadata, error) {
-	// Generate a new UUID for the file
-	fileID := uuid.New().String()
-	
-	// Determine file extension
-	var extension string
-	if contentType != "" {
-		extension = utils.GetExtensionFromContentType(contentType)
-	} else if originalName != "" {
-		extension = filepath.Ext(originalName)
-	} else {
-		extension = ".bin"
+// Close stops background maintenance and releases volume files
+func (fs *FileStorage) Close() error {
+	fs.closeOnce.Do(func() {
+		close(fs.done)
+		fs.wg.Wait()
+		fs.volumes.close()
+		fs.changes.Close()
+	})
+	return nil
+}
+
+// Disks returns the status of every data directory
+func (fs *FileStorage) Disks() []DiskStatus {
+	statuses := make([]DiskStatus, 0, len(fs.disks)+1)
+	for _, disk := range fs.allDisks() {
+		status := disk.Status()
+		status.Tier = TierHot
+		if disk == fs.cold {
+			status.Tier = TierCold
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Degraded reports whether any disk is currently degraded
+func (fs *FileStorage) Degraded() bool {
+	for _, disk := range fs.allDisks() {
+		if !disk.Healthy() {
+			return true
+		}
+	}
+	return false
+}
+
+// allDisks returns the hot disks followed by the cold tier, if configured
+func (fs *FileStorage) allDisks() []*Disk {
+	if fs.cold == nil {
+		return fs.disks
+	}
+	return append(fs.disks[:len(fs.disks):len(fs.disks)], fs.cold)
+}
+
+// Store saves a file with metadata and returns file information. The file
+// gets req.ID if set, which must be valid and unused, or a new UUID.
+func (fs *FileStorage) Store(req *models.FileUploadRequest) (*models.FileMetadata, error) {
+	return fs.store(req, nil)
+}
+
+// store writes the content of an upload and publishes its metadata. A
+// non-nil stage is called instead of saving the metadata, to keep the file
+// invisible until it is committed.
+func (fs *FileStorage) store(req *models.FileUploadRequest, stage func(*models.FileMetadata) error) (*models.FileMetadata, error) {
+	content, originalName, contentType := req.Content, req.FileName, req.ContentType
+	if req.ID != "" && !ValidID(req.ID) {
+		return nil, ErrInvalidID
 	}
-	
-	// Create filename with extension
-	filename := fileID + extension
-	filePath := filepath.Join(fs.basePath, filename)
 
-	// Create the file
-	file, err := os.Create(filePath)
+	// Check the declared type against what the content actually is
+	detected := utils.DetectContentType(content)
+	contentType, mismatch, err := fs.reconcileType(contentType, originalName, detected)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create file: %w", err)
+		return nil, err
 	}
-	defer file.Close()
 
-	// Write content to file
-	_, err = file.Write(content)
+	// Uploads that wait for their verdict are scanned before anything is
+	// written
+	scanStatus, signature, err := fs.scanUpload(req)
 	if err != nil {
-		// Clean up the file if write fails
-		os.Remove(filePath)
-		return nil, fmt.Errorf("failed to write file content: %w", err)
+		return nil, err
 	}
 
+	// Generate a new UUID for the file unless the client chose its ID
+	fileID := req.ID
+	if fileID == "" {
+		fileID = uuid.New().String()
+	}
+
+	fs.locks.Lock(fileID)
+	defer fs.locks.Unlock(fileID)
+
+	if req.ID != "" {
+		if err := fs.checkIDFree(fileID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Determine file extension
+	extension := utils.ExtensionFor(contentType, originalName)
+
 	// Create metadata
 	now := time.Now()
 	metadata := &models.FileMetadata{
@@ -79,64 +291,182 @@ func (fs *FileStorage) Store(content []byte, originalName, contentType string) (
 		Extension:    extension,
 		CreatedAt:    now,
 		UpdatedAt:    now,
+		Tier:         TierHot,
+
+		LastAccessedAt: now,
+		UserMetadata:   req.Metadata,
+		Tags:           req.Tags,
+		CacheControl:   req.CacheControl,
+		Appendable:     req.Appendable,
+		Sealed:         req.Appendable && req.Sealed,
+
+		DetectedType: detected,
+		TypeMismatch: mismatch,
+
+		ScanStatus:    scanStatus,
+		ScanSignature: signature,
+
+		Copies:         req.Copies,
+		ReplicaVersion: req.ReplicaVersion,
+	}
+
+	hash := sha256.New()
+	hash.Write(content)
+	metadata.Checksum = hex.EncodeToString(hash.Sum(nil))
+	if metadata.Appendable && !metadata.Sealed {
+		state, err := hash.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("failed to save hash state: %w", err)
+		}
+		metadata.HashState = state
+	}
+	metadata.Properties = fs.extractors.Run(contentType, content)
+
+	// Small objects are packed into volumes, everything else gets its own
+	// file on the first disk that accepts it. Appendable objects always get
+	// their own file so they can grow in place, and so do staged uploads so
+	// that committing only has to publish the metadata. Infected uploads go
+	// straight to quarantine.
+	if scanStatus == ScanInfected {
+		metadata.Disk = fs.quarantinePath
+		err := writeFile(fs.dataPath(metadata), func(w io.Writer) error {
+			_, err := w.Write(content)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to write quarantined content: %w", err)
+		}
+	} else if fs.packThreshold > 0 && metadata.Size < fs.packThreshold && !metadata.Appendable && stage == nil {
+		if err := fs.volumes.Put(fileID, content); err != nil {
+			return nil, fmt.Errorf("failed to write file content: %w", err)
+		}
+		metadata.Packed = true
+	} else {
+		disk, err := fs.writeToDisk(fileID+extension, content)
+		if err != nil {
+			return nil, err
+		}
+		metadata.Disk = disk.Path()
+	}
+
+	// Staged uploads are recorded for a later commit; quarantined ones are
+	// kept as regular records so they can be inspected
+	if stage != nil && scanStatus != ScanInfected {
+		if err := stage(metadata); err != nil {
+			fs.removeContent(metadata)
+			return nil, fmt.Errorf("failed to stage upload: %w", err)
+		}
+		return metadata, nil
 	}
 
 	// Save metadata
 	if err := fs.saveMetadata(metadata); err != nil {
-		// Clean up the file if metadata save fails
-		os.Remove(filePath)
+		// Clean up the content if metadata save fails
+		fs.removeContent(metadata)
 		return nil, fmt.Errorf("failed to save metadata: %w", err)
 	}
 
+	fs.recordChange(changes.Create, metadata)
+
+	switch scanStatus {
+	case ScanInfected:
+		return nil, fmt.Errorf("%w: %s (quarantined as %s)", ErrInfected, signature, fileID)
+	case ScanPending:
+		fs.queueScan(fileID)
+	}
 	return metadata, nil
 }
 
 // Retrieve returns file content and metadata for the given file ID
 func (fs *FileStorage) Retrieve(fileID string) ([]byte, *models.FileMetadata, error) {
+	fs.locks.RLock(fileID)
+
 	// Load metadata first
 	metadata, err := fs.loadMetadata(fileID)
 	if err != nil {
+		fs.locks.RUnlock(fileID)
 		return nil, nil, fmt.Errorf("metadata not found: %s", fileID)
 	}
+	if err := fs.Servable(metadata); err != nil {
+		fs.locks.RUnlock(fileID)
+		return nil, nil, err
+	}
 
-	// Construct file path
-	filename := fileID + metadata.Extension
-	filePath := filepath.Join(fs.basePath, filename)
-	
 	// Read file content
-	content, err := os.ReadFile(filePath)
+	content, err := fs.readContent(metadata)
+	fs.locks.RUnlock(fileID)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if os.IsNotExist(err) || errors.Is(err, errNeedleNotFound) {
 			return nil, nil, fmt.Errorf("file not found: %s", fileID)
 		}
 		return nil, nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
+	fs.touch(metadata)
+	return content, metadata, nil
+}
+
+// Snapshot returns the content and metadata of a file as one consistent
+// version, for replication. Unlike Retrieve it ignores the scan status,
+// since peers scan their replicas themselves, but never returns the
+// content of a quarantined file.
+func (fs *FileStorage) Snapshot(fileID string) ([]byte, *models.FileMetadata, error) {
+	fs.locks.RLock(fileID)
+	defer fs.locks.RUnlock(fileID)
+
+	metadata, err := fs.loadMetadata(fileID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("metadata not found: %s", fileID)
+	}
+	if metadata.ScanStatus == ScanInfected {
+		return nil, nil, ErrQuarantined
+	}
+	content, err := fs.readContent(metadata)
+	if err != nil {
+		if os.IsNotExist(err) || errors.Is(err, errNeedleNotFound) {
+			return nil, nil, fmt.Errorf("file not found: %s", fileID)
+		}
+		return nil, nil, fmt.Errorf("failed to read file: %w", err)
+	}
 	return content, metadata, nil
 }
 
 // GetMetadata returns only metadata for the given file ID
 func (fs *FileStorage) GetMetadata(fileID string) (*models.FileMetadata, error) {
-	return fs.loadMetadata(fileID)
+	fs.locks.RLock(fileID)
+	defer fs.locks.RUnlock(fileID)
+
+	metadata, err := fs.loadMetadata(fileID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("metadata not found: %s", fileID)
+		}
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+	return metadata, nil
 }
 
-// Delete removes a file and its metadata by ID
-func (fs *FileStorage) Delete(fileID string) error {
+// Delete removes a file and its metadata by ID. A non-empty ifMatch must
+// match the current ETag of the file.
+func (fs *FileStorage) Delete(fileID, ifMatch string) error {
+	fs.locks.Lock(fileID)
+	defer fs.locks.Unlock(fileID)
+
 	// Load metadata to get the extension
 	metadata, err := fs.loadMetadata(fileID)
 	if err != nil {
 		return fmt.Errorf("metadata not found: %s", fileID)
 	}
 
-	// Construct file path
-	filename := fileID + metadata.Extension
-	filePath := filepath.Join(fs.basePath, filename)
-	
+	if !MatchETag(ifMatch, metadata) {
+		return ErrPreconditionFailed
+	}
+
 	// Remove file
-	err = os.Remove(filePath)
-	if err != nil && !os.IsNotExist(err) {
+	if err := fs.removeContent(metadata); err != nil {
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
+	fs.removeDerivatives(metadata)
 
 	// Remove metadata
 	metadataPath := fs.getMetadataPath(fileID)
@@ -145,41 +475,334 @@ func (fs *FileStorage) Delete(fileID string) error {
 		return fmt.Errorf("failed to delete metadata: %w", err)
 	}
 
+	fs.recordChange(changes.Delete, metadata)
 	return nil
 }
 
+// UpdateMetadata applies update to the metadata of a file under its lock and
+// persists the result with a new UpdatedAt. A non-empty ifMatch must match
+// the current ETag of the file. Content is never touched.
+func (fs *FileStorage) UpdateMetadata(fileID, ifMatch string, update func(*models.FileMetadata) error) (*models.FileMetadata, error) {
+	fs.locks.Lock(fileID)
+	defer fs.locks.Unlock(fileID)
+
+	metadata, err := fs.loadMetadata(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("metadata not found: %s", fileID)
+	}
+
+	if !MatchETag(ifMatch, metadata) {
+		return nil, ErrPreconditionFailed
+	}
+
+	// The ID and storage location are owned by FileStorage
+	original := *metadata
+	if err := update(metadata); err != nil {
+		return nil, err
+	}
+	metadata.ID = original.ID
+	metadata.Extension = original.Extension
+	metadata.Disk = original.Disk
+	metadata.Packed = original.Packed
+	metadata.Tier = original.Tier
+	metadata.Compressed = original.Compressed
+	metadata.Size = original.Size
+	metadata.Checksum = original.Checksum
+	metadata.Appendable = original.Appendable
+	metadata.Sealed = original.Sealed
+	metadata.HashState = original.HashState
+	metadata.Properties = original.Properties
+	metadata.DetectedType = original.DetectedType
+	metadata.ScanStatus = original.ScanStatus
+	metadata.ScanSignature = original.ScanSignature
+	metadata.UpdatedAt = time.Now()
+
+	// A new content type or name is held to the same policy as uploads
+	if metadata.ContentType != original.ContentType || metadata.OriginalName != original.OriginalName {
+		mismatch := typeMismatch(metadata.ContentType, metadata.OriginalName, metadata.DetectedType)
+		if mismatch && fs.typePolicy == config.TypePolicyReject {
+			return nil, fmt.Errorf("%w: declared %q, detected %q", ErrContentTypeMismatch, metadata.ContentType, metadata.DetectedType)
+		}
+		metadata.TypeMismatch = mismatch
+	}
+
+	if err := fs.saveMetadata(metadata); err != nil {
+		return nil, fmt.Errorf("failed to save metadata: %w", err)
+	}
+	fs.recordChange(changes.Update, metadata)
+	return metadata, nil
+}
+
+// List returns the metadata of every file accepted by filter, oldest first.
+// A nil filter returns all files.
+func (fs *FileStorage) List(filter func(*models.FileMetadata) bool) ([]*models.FileMetadata, error) {
+	all, err := fs.listMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metadata: %w", err)
+	}
+
+	matched := make([]*models.FileMetadata, 0, len(all))
+	for _, metadata := range all {
+		if filter == nil || filter(metadata) {
+			matched = append(matched, metadata)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].CreatedAt.Before(matched[j].CreatedAt)
+	})
+	return matched, nil
+}
+
 // Exists checks if a file exists
 func (fs *FileStorage) Exists(fileID string) bool {
+	fs.locks.RLock(fileID)
+	defer fs.locks.RUnlock(fileID)
+
 	metadata, err := fs.loadMetadata(fileID)
 	if err != nil {
 		return false
 	}
 
-	filename := fileID + metadata.Extension
-	filePath := filepath.Join(fs.basePath, filename)
-	_, err = os.Stat(filePath)
+	if metadata.Packed {
+		return fs.volumes.Has(fileID)
+	}
+	_, err = os.Stat(fs.dataPath(metadata))
 	return !os.IsNotExist(err)
 }
 
-// saveMetadata saves file metadata to disk
+// readContent reads the content described by metadata from its file or volume
+func (fs *FileStorage) readContent(metadata *models.FileMetadata) ([]byte, error) {
+	if metadata.Packed {
+		return fs.volumes.Get(metadata.ID)
+	}
+	if metadata.Compressed {
+		return readCompressed(fs.dataPath(metadata))
+	}
+	return os.ReadFile(fs.dataPath(metadata))
+}
+
+// openContent opens a reader over the content described by metadata
+func (fs *FileStorage) openContent(metadata *models.FileMetadata) (io.ReadCloser, error) {
+	if metadata.Packed {
+		content, err := fs.volumes.Get(metadata.ID)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(content)), nil
+	}
+	if metadata.Compressed {
+		return openCompressed(fs.dataPath(metadata))
+	}
+	return os.Open(fs.dataPath(metadata))
+}
+
+// removeContent deletes the content described by metadata, ignoring content
+// that is already gone
+func (fs *FileStorage) removeContent(metadata *models.FileMetadata) error {
+	if metadata.Packed {
+		if err := fs.volumes.Delete(metadata.ID); err != nil && !errors.Is(err, errNeedleNotFound) {
+			return err
+		}
+		return nil
+	}
+	if err := os.Remove(fs.dataPath(metadata)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// dataPath returns the location of the data file described by metadata
+func (fs *FileStorage) dataPath(metadata *models.FileMetadata) string {
+	root := metadata.Disk
+	if root == "" {
+		root = fs.basePath
+	}
+	filename := metadata.ID + metadata.Extension
+	if metadata.Compressed {
+		filename += compressedExt
+	}
+	return filepath.Join(root, filename)
+}
+
+// writeToDisk writes content to a disk chosen by the placement strategy.
+// A disk that fails the write is marked degraded and the next one is tried.
+func (fs *FileStorage) writeToDisk(filename string, content []byte) (*Disk, error) {
+	return fs.streamToDisk(filename, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+}
+
+// streamToDisk is writeToDisk for content produced by write, which may be
+// called again for each disk that is tried
+func (fs *FileStorage) streamToDisk(filename string, write func(io.Writer) error) (*Disk, error) {
+	var lastErr error
+	for _, disk := range fs.placementOrder() {
+		filePath := filepath.Join(disk.Path(), filename)
+		if err := writeFile(filePath, write); err != nil {
+			var source *sourceError
+			if errors.As(err, &source) {
+				return nil, source.err
+			}
+			log.Printf("Disk %s failed, marking degraded: %v", disk.Path(), err)
+			disk.markDegraded(err)
+			lastErr = err
+			continue
+		}
+		return disk, nil
+	}
+	if lastErr != nil {
+		return nil, fmt.Errorf("failed to write file content: %w", lastErr)
+	}
+	return nil, errors.New("no healthy disks available")
+}
+
+// placementOrder returns the healthy disks in the order they should be tried
+func (fs *FileStorage) placementOrder() []*Disk {
+	healthy := make([]*Disk, 0, len(fs.disks))
+	for _, disk := range fs.disks {
+		if disk.Healthy() {
+			healthy = append(healthy, disk)
+		}
+	}
+	if len(healthy) < 2 {
+		return healthy
+	}
+
+	if fs.placement == config.PlacementFreeSpace {
+		free := make(map[*Disk]uint64, len(healthy))
+		for _, disk := range healthy {
+			free[disk] = disk.freeBytes()
+		}
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return free[healthy[i]] > free[healthy[j]]
+		})
+		// Unknown free space on every disk falls back to round-robin
+		if free[healthy[0]] > 0 {
+			return healthy
+		}
+	}
+
+	start := int(atomic.AddUint64(&fs.next, 1)-1) % len(healthy)
+	order := make([]*Disk, 0, len(healthy))
+	for i := range healthy {
+		order = append(order, healthy[(start+i)%len(healthy)])
+	}
+	return order
+}
+
+// probeDisks periodically re-checks degraded disks and restores them
+func (fs *FileStorage) probeDisks() {
+	defer fs.wg.Done()
+
+	ticker := time.NewTicker(diskProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-fs.done:
+			return
+		case <-ticker.C:
+			for _, disk := range fs.allDisks() {
+				if disk.Healthy() {
+					continue
+				}
+				if err := disk.probe(); err == nil {
+					log.Printf("Disk %s recovered", disk.Path())
+					disk.markHealthy()
+				}
+			}
+		}
+	}
+}
+
+// compactVolumes periodically rewrites volumes with too much dead space
+func (fs *FileStorage) compactVolumes() {
+	defer fs.wg.Done()
+
+	ticker := time.NewTicker(fs.compactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-fs.done:
+			return
+		case <-ticker.C:
+			fs.volumes.Compact(fs.compactRatio)
+		}
+	}
+}
+
+// pruneVolumes tombstones packed objects whose metadata no longer refers to
+// them, e.g. after a crash between deleting metadata and content
+func (fs *FileStorage) pruneVolumes() {
+	for _, fileID := range fs.volumes.IDs() {
+		metadata, err := fs.loadMetadata(fileID)
+		if err == nil && metadata.Packed || err != nil && !os.IsNotExist(err) {
+			continue
+		}
+		if err := fs.volumes.Delete(fileID); err != nil {
+			log.Printf("Failed to prune packed object %s: %v", fileID, err)
+		}
+	}
+}
+
+// writeFile creates path with the output of write, removing it again on
+// failure
+func writeFile(path string, write func(io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	tracked := NewTrackingWriter(file)
+	if err := write(tracked); err != nil {
+		file.Close()
+		os.Remove(path)
+		if tracked.err == nil {
+			return &sourceError{err}
+		}
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// saveMetadata saves file metadata to disk. The file is written to a
+// temporary name and renamed into place so readers never see a partial
+// document.
 func (fs *FileStorage) saveMetadata(metadata *models.FileMetadata) error {
 	metadataPath := fs.getMetadataPath(metadata.ID)
-	
-	file, err := os.Create(metadataPath)
+
+	file, err := os.CreateTemp(filepath.Dir(metadataPath), metadata.ID+".*.tmp")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	tmpPath := file.Name()
 
 	encoder := json.NewEncoder(file)
 	encoder.SetIndent("", "  ")
-	return encoder.Encode(metadata)
+	err = encoder.Encode(metadata)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, metadataPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
 }
 
 // loadMetadata loads file metadata from disk
 func (fs *FileStorage) loadMetadata(fileID string) (*models.FileMetadata, error) {
 	metadataPath := fs.getMetadataPath(fileID)
-	
+
 	file, err := os.Open(metadataPath)
 	if err != nil {
 		return nil, err
@@ -199,3 +822,37 @@ func (fs *FileStorage) loadMetadata(fileID string) (*models.FileMetadata, error)
 func (fs *FileStorage) getMetadataPath(fileID string) string {
 	return filepath.Join(fs.basePath, "metadata", fileID+".json")
 }
+
+// TrackingWriter remembers the first error of the underlying writer so that
+// disk failures can be told apart from failures producing the content
+type TrackingWriter struct {
+	w   io.Writer
+	err error
+}
+
+// NewTrackingWriter wraps w
+func NewTrackingWriter(w io.Writer) *TrackingWriter {
+	return &TrackingWriter{w: w}
+}
+
+func (t *TrackingWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	if err != nil && t.err == nil {
+		t.err = err
+	}
+	return n, err
+}
+
+// Err returns the first error of the underlying writer
+func (t *TrackingWriter) Err() error {
+	return t.err
+}
+
+// sourceError wraps a failure that is not the disk's fault
+type sourceError struct {
+	err error
+}
+
+func (e *sourceError) Error() string {
+	return e.err.Error()
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dvfs/storage-node/pkg/config"
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// newTestStorage opens a file storage in a temporary directory and closes
+// it when the test ends
+func newTestStorage(t *testing.T) *FileStorage {
+	t.Helper()
+	dir := t.TempDir()
+	fs, err := NewFileStorage(&config.Config{
+		StoragePath:    dir,
+		StoragePaths:   []string{dir},
+		PackVolumeSize: 1 << 20,
+	})
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+func mustStore(t *testing.T, fs *FileStorage, req *models.FileUploadRequest) *models.FileMetadata {
+	t.Helper()
+	metadata, err := fs.Store(req)
+	if err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	return metadata
+}
+
+func TestConcurrentOperations(t *testing.T) {
+	fs := newTestStorage(t)
+
+	const workers = 8
+	const rounds = 20
+
+	shared := mustStore(t, fs, &models.FileUploadRequest{
+		Content:     []byte("log:"),
+		ContentType: "text/plain",
+		FileName:    "shared.log",
+		Appendable:  true,
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*rounds*4)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				content := []byte(fmt.Sprintf("worker %d round %d", w, i))
+				metadata, err := fs.Store(&models.FileUploadRequest{
+					Content:     content,
+					ContentType: "text/plain",
+					FileName:    fmt.Sprintf("w%d-%d.txt", w, i),
+				})
+				if err != nil {
+					errs <- fmt.Errorf("Store: %w", err)
+					continue
+				}
+
+				got, _, err := fs.Retrieve(metadata.ID)
+				if err != nil {
+					errs <- fmt.Errorf("Retrieve: %w", err)
+				} else if string(got) != string(content) {
+					errs <- fmt.Errorf("Retrieve(%s) = %q, want %q", metadata.ID, got, content)
+				}
+
+				_, err = fs.UpdateMetadata(shared.ID, "", func(m *models.FileMetadata) error {
+					if m.UserMetadata == nil {
+						m.UserMetadata = map[string]string{}
+					}
+					m.UserMetadata[fmt.Sprintf("w%d", w)] = fmt.Sprint(i)
+					return nil
+				})
+				if err != nil {
+					errs <- fmt.Errorf("UpdateMetadata: %w", err)
+				}
+
+				if _, err := fs.Append(shared.ID, -1, "", []byte("x"), nil); err != nil {
+					errs <- fmt.Errorf("Append: %w", err)
+				}
+
+				// Readers of the shared file must see a consistent state
+				if data, m, err := fs.Retrieve(shared.ID); err != nil {
+					errs <- fmt.Errorf("Retrieve shared: %w", err)
+				} else if int64(len(data)) != m.Size {
+					errs <- fmt.Errorf("shared file has %d bytes, metadata says %d", len(data), m.Size)
+				}
+
+				if err := fs.Delete(metadata.ID, ""); err != nil {
+					errs <- fmt.Errorf("Delete: %w", err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	data, metadata, err := fs.Retrieve(shared.ID)
+	if err != nil {
+		t.Fatalf("Retrieve: %v", err)
+	}
+	want := "log:" + strings.Repeat("x", workers*rounds)
+	if string(data) != want {
+		t.Errorf("shared file has %d bytes, want %d", len(data), len(want))
+	}
+	if len(metadata.UserMetadata) != workers {
+		t.Errorf("shared file has %d metadata keys, want %d", len(metadata.UserMetadata), workers)
+	}
+}
+
+func TestConcurrentConditionalAppend(t *testing.T) {
+	fs := newTestStorage(t)
+	metadata := mustStore(t, fs, &models.FileUploadRequest{
+		Content:     []byte("start"),
+		ContentType: "text/plain",
+		FileName:    "cas.log",
+		Appendable:  true,
+	})
+
+	// Every writer races on the same ETag; exactly one may win
+	etag := metadata.ETag()
+	const writers = 8
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	won, failed := 0, 0
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := fs.Append(metadata.ID, -1, etag, []byte("!"), nil)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				won++
+			case errors.Is(err, ErrPreconditionFailed):
+				failed++
+			default:
+				t.Errorf("Append: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if won != 1 || failed != writers-1 {
+		t.Errorf("%d appends succeeded and %d failed the precondition, want 1 and %d", won, failed, writers-1)
+	}
+}
+
+func TestConcurrentDeleteAndUpdate(t *testing.T) {
+	fs := newTestStorage(t)
+
+	const files = 16
+	ids := make([]string, 0, files)
+	for i := 0; i < files; i++ {
+		metadata := mustStore(t, fs, &models.FileUploadRequest{
+			Content:     []byte(fmt.Sprintf("file %d", i)),
+			ContentType: "text/plain",
+			FileName:    fmt.Sprintf("f%d.txt", i),
+		})
+		ids = append(ids, metadata.ID)
+	}
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(2)
+		go func(id string) {
+			defer wg.Done()
+			if err := fs.Delete(id, ""); err != nil {
+				t.Errorf("Delete(%s): %v", id, err)
+			}
+		}(id)
+		go func(id string) {
+			defer wg.Done()
+			_, err := fs.UpdateMetadata(id, "", func(m *models.FileMetadata) error {
+				m.OriginalName = "renamed.txt"
+				return nil
+			})
+			if err != nil && !strings.Contains(err.Error(), "not found") {
+				t.Errorf("UpdateMetadata(%s): %v", id, err)
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	// An update that lost the race must not have brought the file back
+	for _, id := range ids {
+		if _, err := fs.GetMetadata(id); err == nil {
+			t.Errorf("%s survived its deletion", id)
+		}
+	}
+}
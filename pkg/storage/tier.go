@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// Storage tiers recorded in FileMetadata.Tier
+const (
+	TierHot  = "hot"
+	TierCold = "cold"
+)
+
+// accessGranularity limits how often reads rewrite LastAccessedAt
+const accessGranularity = time.Hour
+
+// compressedExt is appended to data files gzipped on the cold tier
+const compressedExt = ".gz"
+
+// touch records a read of the file, skipping the metadata write when the
+// previous access is recent enough
+func (fs *FileStorage) touch(metadata *models.FileMetadata) {
+	now := time.Now()
+	if now.Sub(metadata.LastAccessedAt) < accessGranularity {
+		return
+	}
+
+	fs.locks.Lock(metadata.ID)
+	defer fs.locks.Unlock(metadata.ID)
+
+	// Reload so a concurrent update or delete is not overwritten
+	current, err := fs.loadMetadata(metadata.ID)
+	if err != nil {
+		return
+	}
+	current.LastAccessedAt = now
+	if err := fs.saveMetadata(current); err != nil {
+		log.Printf("Failed to record access for %s: %v", metadata.ID, err)
+	}
+}
+
+// lastAccess returns when the file was last read, falling back to creation
+// time for files stored before access tracking existed
+func lastAccess(metadata *models.FileMetadata) time.Time {
+	if metadata.LastAccessedAt.IsZero() {
+		return metadata.CreatedAt
+	}
+	return metadata.LastAccessedAt
+}
+
+// coldCandidate reports whether a file may move to the cold tier. Packed
+// files stay in their volumes, appendable files stay hot until sealed and
+// quarantined files stay in quarantine.
+func coldCandidate(metadata *models.FileMetadata, cutoff time.Time) bool {
+	if metadata.Packed || metadata.Tier == TierCold || metadata.ScanStatus == ScanInfected {
+		return false
+	}
+	if metadata.Appendable && !metadata.Sealed {
+		return false
+	}
+	return !lastAccess(metadata).After(cutoff)
+}
+
+// moveColdFiles runs the lifecycle mover until Close is called
+func (fs *FileStorage) moveColdFiles() {
+	defer fs.wg.Done()
+
+	ticker := time.NewTicker(fs.tierMoveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-fs.done:
+			return
+		case <-ticker.C:
+			fs.runLifecycle()
+		}
+	}
+}
+
+// runLifecycle moves every hot file idle for longer than coldAfter to the
+// cold tier
+func (fs *FileStorage) runLifecycle() {
+	if !fs.cold.Healthy() {
+		return
+	}
+
+	all, err := fs.listMetadata()
+	if err != nil {
+		log.Printf("Lifecycle scan failed: %v", err)
+		return
+	}
+
+	cutoff := time.Now().Add(-fs.coldAfter)
+	moved := 0
+	for _, metadata := range all {
+		if !coldCandidate(metadata, cutoff) {
+			continue
+		}
+
+		select {
+		case <-fs.done:
+			return
+		default:
+		}
+
+		if err := fs.moveToCold(metadata); err != nil {
+			log.Printf("Failed to move %s to cold tier: %v", metadata.ID, err)
+			continue
+		}
+		moved++
+	}
+
+	if moved > 0 {
+		log.Printf("Lifecycle: moved %d files to cold tier", moved)
+	}
+}
+
+// moveToCold copies a hot file to the cold tier, switches its metadata and
+// then removes the hot copy
+func (fs *FileStorage) moveToCold(metadata *models.FileMetadata) error {
+	fs.locks.Lock(metadata.ID)
+	defer fs.locks.Unlock(metadata.ID)
+
+	// The file may have been read, moved or deleted since the scan
+	metadata, err := fs.loadMetadata(metadata.ID)
+	if err != nil {
+		return nil
+	}
+	if !coldCandidate(metadata, time.Now().Add(-fs.coldAfter)) {
+		return nil
+	}
+
+	src := fs.dataPath(metadata)
+
+	moved := *metadata
+	moved.Disk = fs.cold.Path()
+	moved.Tier = TierCold
+	moved.Compressed = fs.coldCompress
+	dst := fs.dataPath(&moved)
+
+	if err := copyToTier(src, dst, moved.Compressed); err != nil {
+		if !os.IsNotExist(err) {
+			fs.cold.markDegraded(err)
+		}
+		return err
+	}
+
+	if err := fs.saveMetadata(&moved); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+
+	if err := os.Remove(src); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove hot copy of %s: %v", metadata.ID, err)
+	}
+	fs.removeDerivatives(metadata)
+	return nil
+}
+
+// copyToTier copies src to dst through a temporary file, optionally gzipping
+func copyToTier(src, dst string, compress bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = out
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(out)
+		w = gz
+	}
+
+	_, err = io.Copy(w, in)
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// readCompressed reads and decompresses a gzipped data file
+func readCompressed(path string) ([]byte, error) {
+	reader, err := openCompressed(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// gzipFile closes both the decompressor and the underlying file
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+// openCompressed opens a decompressing reader over a gzipped data file
+func openCompressed(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open compressed file: %w", err)
+	}
+	return &gzipFile{Reader: gz, file: file}, nil
+}
+
+// listMetadata loads the metadata of every stored file
+func (fs *FileStorage) listMetadata() ([]*models.FileMetadata, error) {
+	var all []*models.FileMetadata
+	err := fs.walkMetadata(func(metadata *models.FileMetadata) error {
+		all = append(all, metadata)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// walkMetadata calls fn with the metadata of every stored file, one at a
+// time and in no particular order, stopping at the first error fn returns
+func (fs *FileStorage) walkMetadata(fn func(*models.FileMetadata) error) error {
+	entries, err := os.ReadDir(filepath.Join(fs.basePath, "metadata"))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		metadata, err := fs.loadMetadata(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		if err := fn(metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
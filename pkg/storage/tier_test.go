@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dvfs/storage-node/pkg/config"
+	"github.com/dvfs/storage-node/pkg/models"
+)
+
+// newTieredStorage creates a storage with a cold tier taking files idle
+// for a day. The mover is run by the tests themselves.
+func newTieredStorage(t *testing.T, compress bool) *FileStorage {
+	t.Helper()
+	base := t.TempDir()
+	fs, err := NewFileStorage(&config.Config{
+		StoragePath:     base,
+		StoragePaths:    []string{filepath.Join(base, "hot")},
+		ColdStoragePath: filepath.Join(base, "cold"),
+		ColdAfter:       24 * time.Hour,
+		ColdCompress:    compress,
+		PackVolumeSize:  1 << 20,
+	})
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+// idle stores content and backdates its last access by age
+func idle(t *testing.T, fs *FileStorage, req *models.FileUploadRequest, age time.Duration) *models.FileMetadata {
+	t.Helper()
+	metadata := mustStore(t, fs, req)
+	metadata.LastAccessedAt = time.Now().Add(-age)
+	if err := fs.saveMetadata(metadata); err != nil {
+		t.Fatal(err)
+	}
+	return metadata
+}
+
+func TestLifecycleMovesIdleFiles(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		fs := newTieredStorage(t, compress)
+		text := func(name string) *models.FileUploadRequest {
+			return &models.FileUploadRequest{Content: []byte("content of " + name), FileName: name, ContentType: "text/plain"}
+		}
+
+		old := idle(t, fs, text("old.txt"), 48*time.Hour)
+		recent := idle(t, fs, text("recent.txt"), time.Hour)
+		growing := text("log.txt")
+		growing.Appendable = true
+		open := idle(t, fs, growing, 48*time.Hour)
+
+		fs.runLifecycle()
+
+		moved, err := fs.GetMetadata(old.ID)
+		if err != nil {
+			t.Fatalf("GetMetadata: %v", err)
+		}
+		if moved.Tier != TierCold || moved.Disk != fs.cold.Path() || moved.Compressed != compress {
+			t.Errorf("idle file = tier %s on %s compressed %v, want the cold tier", moved.Tier, moved.Disk, moved.Compressed)
+		}
+		if _, err := os.Stat(fs.dataPath(old)); !os.IsNotExist(err) {
+			t.Errorf("hot copy of a moved file still exists: %v", err)
+		}
+		for _, id := range []string{recent.ID, open.ID} {
+			if metadata, _ := fs.GetMetadata(id); metadata.Tier == TierCold {
+				t.Errorf("%s moved to the cold tier", metadata.OriginalName)
+			}
+		}
+
+		// Reads work the same from either tier
+		content, _, err := fs.Retrieve(old.ID)
+		if err != nil || string(content) != "content of old.txt" {
+			t.Errorf("Retrieve from the cold tier = %q, %v", content, err)
+		}
+		reader, _, err := fs.Open(old.ID)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		streamed, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil || string(streamed) != "content of old.txt" {
+			t.Errorf("Open from the cold tier = %q, %v", streamed, err)
+		}
+	}
+}
+
+func TestReadsRecordAccess(t *testing.T) {
+	fs := newTieredStorage(t, false)
+	metadata := idle(t, fs, &models.FileUploadRequest{Content: []byte("x"), FileName: "x.txt", ContentType: "text/plain"}, 48*time.Hour)
+
+	// A read brings the file back into use before the mover sees it
+	if _, _, err := fs.Retrieve(metadata.ID); err != nil {
+		t.Fatalf("Retrieve: %v", err)
+	}
+	fs.runLifecycle()
+
+	current, err := fs.GetMetadata(metadata.ID)
+	if err != nil {
+		t.Fatalf("GetMetadata: %v", err)
+	}
+	if time.Since(current.LastAccessedAt) > time.Minute {
+		t.Errorf("last access = %v, want the read just made", current.LastAccessedAt)
+	}
+	if current.Tier == TierCold {
+		t.Error("a file read just now moved to the cold tier")
+	}
+}
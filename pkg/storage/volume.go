@@ -0,0 +1,529 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Packed volumes store many small objects back to back in a single
+// append-only file. Every record is laid out as
+//
+//	header: magic(4) flags(1) idLen(2) dataLen(8)
+//	body:   id(idLen) data(dataLen)
+//	footer: crc32(data)(4) magic(4)
+//
+// The footer lets a scan detect torn writes at the tail of a volume, and the
+// in-memory index is rebuilt by walking every record on startup.
+const (
+	needleMagic      uint32 = 0x44564653 // "DVFS"
+	needleHeaderSize        = 4 + 1 + 2 + 8
+	needleFooterSize        = 4 + 4
+
+	needleFlagData      byte = 0
+	needleFlagTombstone byte = 1
+
+	volumeExt = ".vol"
+)
+
+// errNeedleNotFound is returned when an ID has no live record in any volume
+var errNeedleNotFound = errors.New("packed object not found")
+
+// needle locates the data of a packed object
+type needle struct {
+	volume uint32
+	offset int64 // start of the record header
+	size   int64 // length of the data
+}
+
+// recordSize returns the on-disk size of the record holding the needle
+func (n needle) recordSize(idLen int) int64 {
+	return needleHeaderSize + int64(idLen) + n.size + needleFooterSize
+}
+
+// volume is a single append-only volume file
+type volume struct {
+	id   uint32
+	path string
+	file *os.File
+	size int64
+	dead int64 // bytes held by deleted or superseded records
+}
+
+// volumeStore manages the volume files and the index of packed objects
+type volumeStore struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.RWMutex
+	volumes map[uint32]*volume
+	active  *volume
+	index   map[string]needle
+}
+
+// openVolumeStore opens every volume in dir and rebuilds the index
+func openVolumeStore(dir string, maxSize int64) (*volumeStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create volume directory: %w", err)
+	}
+
+	vs := &volumeStore{
+		dir:     dir,
+		maxSize: maxSize,
+		volumes: make(map[uint32]*volume),
+		index:   make(map[string]needle),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+
+	var ids []uint32
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, volumeExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, volumeExt), 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+
+	// Later volumes win so a half-finished compaction is harmless
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		if err := vs.load(id); err != nil {
+			vs.close()
+			return nil, err
+		}
+	}
+
+	if len(ids) > 0 {
+		vs.active = vs.volumes[ids[len(ids)-1]]
+	}
+	return vs, nil
+}
+
+// load opens a volume and replays its records into the index
+func (vs *volumeStore) load(id uint32) error {
+	path := vs.volumePath(id)
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open volume %d: %w", id, err)
+	}
+
+	v := &volume{id: id, path: path, file: file}
+	vs.volumes[id] = v
+
+	var offset int64
+	for {
+		flags, fileID, dataLen, err := readNeedleHeader(file, offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Volume %d: truncating torn tail at offset %d: %v", id, offset, err)
+			if err := file.Truncate(offset); err != nil {
+				file.Close()
+				return fmt.Errorf("failed to truncate volume %d: %w", id, err)
+			}
+			break
+		}
+
+		n := needle{volume: id, offset: offset, size: dataLen}
+		recordSize := n.recordSize(len(fileID))
+
+		if old, ok := vs.index[fileID]; ok {
+			vs.volumes[old.volume].dead += old.recordSize(len(fileID))
+			delete(vs.index, fileID)
+		}
+		if flags == needleFlagTombstone {
+			v.dead += recordSize
+		} else {
+			vs.index[fileID] = n
+		}
+		offset += recordSize
+	}
+
+	v.size = offset
+	return nil
+}
+
+// readNeedleHeader reads and validates the record at offset
+func readNeedleHeader(file *os.File, offset int64) (byte, string, int64, error) {
+	header := make([]byte, needleHeaderSize)
+	n, err := file.ReadAt(header, offset)
+	if n == 0 && err == io.EOF {
+		return 0, "", 0, io.EOF
+	}
+	if err != nil {
+		return 0, "", 0, fmt.Errorf("short header: %w", err)
+	}
+	if binary.LittleEndian.Uint32(header[0:4]) != needleMagic {
+		return 0, "", 0, errors.New("bad header magic")
+	}
+	flags := header[4]
+	idLen := int64(binary.LittleEndian.Uint16(header[5:7]))
+	dataLen := int64(binary.LittleEndian.Uint64(header[7:15]))
+
+	id := make([]byte, idLen)
+	if _, err := file.ReadAt(id, offset+needleHeaderSize); err != nil {
+		return 0, "", 0, fmt.Errorf("short id: %w", err)
+	}
+
+	footer := make([]byte, needleFooterSize)
+	if _, err := file.ReadAt(footer, offset+needleHeaderSize+idLen+dataLen); err != nil {
+		return 0, "", 0, fmt.Errorf("short footer: %w", err)
+	}
+	if binary.LittleEndian.Uint32(footer[4:8]) != needleMagic {
+		return 0, "", 0, errors.New("bad footer magic")
+	}
+	return flags, string(id), dataLen, nil
+}
+
+// encodeNeedle builds a complete record
+func encodeNeedle(flags byte, fileID string, data []byte) []byte {
+	record := make([]byte, needleHeaderSize+len(fileID)+len(data)+needleFooterSize)
+	binary.LittleEndian.PutUint32(record[0:4], needleMagic)
+	record[4] = flags
+	binary.LittleEndian.PutUint16(record[5:7], uint16(len(fileID)))
+	binary.LittleEndian.PutUint64(record[7:15], uint64(len(data)))
+	copy(record[needleHeaderSize:], fileID)
+	copy(record[needleHeaderSize+len(fileID):], data)
+	footer := record[len(record)-needleFooterSize:]
+	binary.LittleEndian.PutUint32(footer[0:4], crc32.ChecksumIEEE(data))
+	binary.LittleEndian.PutUint32(footer[4:8], needleMagic)
+	return record
+}
+
+// Put appends an object to the active volume
+func (vs *volumeStore) Put(fileID string, data []byte) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	offset, v, err := vs.append(needleFlagData, fileID, data)
+	if err != nil {
+		return err
+	}
+
+	n := needle{volume: v.id, offset: offset, size: int64(len(data))}
+	if old, ok := vs.index[fileID]; ok {
+		vs.volumes[old.volume].dead += old.recordSize(len(fileID))
+	}
+	vs.index[fileID] = n
+	return nil
+}
+
+// Get reads an object and verifies its checksum
+func (vs *volumeStore) Get(fileID string) ([]byte, error) {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+
+	n, ok := vs.index[fileID]
+	if !ok {
+		return nil, errNeedleNotFound
+	}
+	v := vs.volumes[n.volume]
+
+	dataOffset := n.offset + needleHeaderSize + int64(len(fileID))
+	data := make([]byte, n.size)
+	if _, err := v.file.ReadAt(data, dataOffset); err != nil {
+		return nil, fmt.Errorf("failed to read volume %d: %w", v.id, err)
+	}
+
+	footer := make([]byte, needleFooterSize)
+	if _, err := v.file.ReadAt(footer, dataOffset+n.size); err != nil {
+		return nil, fmt.Errorf("failed to read volume %d: %w", v.id, err)
+	}
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(footer[0:4]) {
+		return nil, fmt.Errorf("checksum mismatch for %s in volume %d", fileID, v.id)
+	}
+	return data, nil
+}
+
+// Has reports whether an object is present
+func (vs *volumeStore) Has(fileID string) bool {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+	_, ok := vs.index[fileID]
+	return ok
+}
+
+// IDs returns the IDs of every packed object
+func (vs *volumeStore) IDs() []string {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+	ids := make([]string, 0, len(vs.index))
+	for id := range vs.index {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Delete writes a tombstone and drops the object from the index
+func (vs *volumeStore) Delete(fileID string) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	old, ok := vs.index[fileID]
+	if !ok {
+		return errNeedleNotFound
+	}
+
+	offset, v, err := vs.append(needleFlagTombstone, fileID, nil)
+	if err != nil {
+		return err
+	}
+	tombstone := needle{volume: v.id, offset: offset}
+	v.dead += tombstone.recordSize(len(fileID))
+	vs.volumes[old.volume].dead += old.recordSize(len(fileID))
+	delete(vs.index, fileID)
+	return nil
+}
+
+// append writes a record to the active volume, rolling over when it is full.
+// The caller must hold the write lock.
+func (vs *volumeStore) append(flags byte, fileID string, data []byte) (int64, *volume, error) {
+	record := encodeNeedle(flags, fileID, data)
+
+	if vs.active == nil || (vs.active.size > 0 && vs.active.size+int64(len(record)) > vs.maxSize) {
+		if err := vs.roll(); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	v := vs.active
+	offset := v.size
+	if _, err := v.file.WriteAt(record, offset); err != nil {
+		// Drop whatever part of the record made it to disk
+		v.file.Truncate(offset)
+		return 0, nil, fmt.Errorf("failed to append to volume %d: %w", v.id, err)
+	}
+	v.size += int64(len(record))
+	return offset, v, nil
+}
+
+// roll starts a new active volume. The caller must hold the write lock.
+func (vs *volumeStore) roll() error {
+	v, err := vs.create()
+	if err != nil {
+		return err
+	}
+	vs.active = v
+	return nil
+}
+
+// create adds an empty volume with the next free ID
+func (vs *volumeStore) create() (*volume, error) {
+	var next uint32 = 1
+	for id := range vs.volumes {
+		if id >= next {
+			next = id + 1
+		}
+	}
+
+	path := vs.volumePath(next)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create volume %d: %w", next, err)
+	}
+	v := &volume{id: next, path: path, file: file}
+	vs.volumes[next] = v
+	return v, nil
+}
+
+// Compact rewrites sealed volumes whose dead fraction reaches ratio
+func (vs *volumeStore) Compact(ratio float64) {
+	vs.mu.RLock()
+	var candidates []uint32
+	for id, v := range vs.volumes {
+		if v == vs.active || v.size == 0 {
+			continue
+		}
+		if float64(v.dead)/float64(v.size) >= ratio {
+			candidates = append(candidates, id)
+		}
+	}
+	vs.mu.RUnlock()
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i] < candidates[j] })
+	for _, id := range candidates {
+		if err := vs.compactVolume(id); err != nil {
+			log.Printf("Failed to compact volume %d: %v", id, err)
+		}
+	}
+}
+
+// compactVolume copies the live records of a volume into a fresh one and
+// removes the old file. Tombstones are carried over while an older volume
+// still holds a record they delete, since replaying that volume on startup
+// would otherwise bring the object back.
+func (vs *volumeStore) compactVolume(id uint32) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	old, ok := vs.volumes[id]
+	if !ok {
+		return nil
+	}
+
+	var live []string
+	for fileID, n := range vs.index {
+		if n.volume == id {
+			live = append(live, fileID)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool { return vs.index[live[i]].offset < vs.index[live[j]].offset })
+
+	tombstones, err := vs.neededTombstones(old)
+	if err != nil {
+		return err
+	}
+
+	// A volume left with only what it would keep has nothing to reclaim
+	var keep int64
+	for _, fileID := range live {
+		keep += vs.index[fileID].recordSize(len(fileID))
+	}
+	for _, fileID := range tombstones {
+		keep += needle{}.recordSize(len(fileID))
+	}
+	if keep >= old.size {
+		return nil
+	}
+
+	var target *volume
+	write := func(record []byte) (int64, error) {
+		if target == nil {
+			var err error
+			if target, err = vs.create(); err != nil {
+				return 0, err
+			}
+		}
+		offset := target.size
+		if _, err := target.file.WriteAt(record, offset); err != nil {
+			target.file.Close()
+			os.Remove(target.path)
+			delete(vs.volumes, target.id)
+			return 0, fmt.Errorf("failed to write compacted volume: %w", err)
+		}
+		target.size += int64(len(record))
+		return offset, nil
+	}
+
+	moved := make(map[string]needle, len(live))
+	for _, fileID := range live {
+		n := vs.index[fileID]
+		record := make([]byte, n.recordSize(len(fileID)))
+		if _, err := old.file.ReadAt(record, n.offset); err != nil {
+			return fmt.Errorf("failed to read record %s: %w", fileID, err)
+		}
+		offset, err := write(record)
+		if err != nil {
+			return err
+		}
+		moved[fileID] = needle{volume: target.id, offset: offset, size: n.size}
+	}
+	for _, fileID := range tombstones {
+		record := encodeNeedle(needleFlagTombstone, fileID, nil)
+		if _, err := write(record); err != nil {
+			return err
+		}
+		target.dead += int64(len(record))
+	}
+
+	if target != nil {
+		if err := target.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync compacted volume: %w", err)
+		}
+		// New writes must land in the newest volume so that replaying
+		// volumes in order on startup always sees the latest record last
+		vs.active = target
+	}
+	for fileID, n := range moved {
+		vs.index[fileID] = n
+	}
+
+	old.file.Close()
+	delete(vs.volumes, id)
+	if err := os.Remove(old.path); err != nil {
+		return fmt.Errorf("failed to remove old volume: %w", err)
+	}
+
+	log.Printf("Compacted volume %d: %d live objects, %d tombstones kept, reclaimed %d bytes", id, len(live), len(tombstones), old.dead)
+	return nil
+}
+
+// neededTombstones returns the IDs of the tombstones in v that still hide a
+// record in an older volume. The caller must hold the write lock.
+func (vs *volumeStore) neededTombstones(v *volume) ([]string, error) {
+	deleted := make(map[string]bool)
+	err := scanVolume(v, func(flags byte, fileID string) {
+		// An object stored again after its tombstone no longer needs it
+		if _, ok := vs.index[fileID]; flags == needleFlagTombstone && !ok {
+			deleted[fileID] = true
+		}
+	})
+	if err != nil || len(deleted) == 0 {
+		return nil, err
+	}
+
+	var needed []string
+	seen := make(map[string]bool)
+	for id, older := range vs.volumes {
+		if id >= v.id {
+			continue
+		}
+		err := scanVolume(older, func(flags byte, fileID string) {
+			if flags == needleFlagData && deleted[fileID] && !seen[fileID] {
+				seen[fileID] = true
+				needed = append(needed, fileID)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(needed)
+	return needed, nil
+}
+
+// scanVolume calls fn with the flags and ID of every record in v
+func scanVolume(v *volume, fn func(flags byte, fileID string)) error {
+	var offset int64
+	for offset < v.size {
+		flags, fileID, dataLen, err := readNeedleHeader(v.file, offset)
+		if err != nil {
+			return fmt.Errorf("failed to scan volume %d: %w", v.id, err)
+		}
+		fn(flags, fileID)
+		offset += needle{size: dataLen}.recordSize(len(fileID))
+	}
+	return nil
+}
+
+// close releases every volume file
+func (vs *volumeStore) close() {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	for _, v := range vs.volumes {
+		v.file.Close()
+	}
+}
+
+// volumePath returns the file path of a volume
+func (vs *volumeStore) volumePath(id uint32) string {
+	return filepath.Join(vs.dir, fmt.Sprintf("%08d%s", id, volumeExt))
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+// openTestVolumes opens a volume store in dir that starts a new volume for
+// every record
+func openTestVolumes(t *testing.T, dir string) *volumeStore {
+	t.Helper()
+	vs, err := openVolumeStore(dir, 1)
+	if err != nil {
+		t.Fatalf("openVolumeStore: %v", err)
+	}
+	return vs
+}
+
+// reopenVolumes closes vs and opens the same directory again
+func reopenVolumes(t *testing.T, vs *volumeStore) *volumeStore {
+	t.Helper()
+	vs.close()
+	return openTestVolumes(t, vs.dir)
+}
+
+func TestCompactionKeepsTombstoneOfOlderRecord(t *testing.T) {
+	vs := openTestVolumes(t, t.TempDir())
+
+	mustPut(t, vs, "deleted", []byte("old data")) // volume 1
+	mustPut(t, vs, "live", []byte("kept"))        // volume 2
+	if err := vs.Delete("deleted"); err != nil {  // tombstone in volume 3
+		t.Fatalf("Delete: %v", err)
+	}
+	mustPut(t, vs, "other", []byte("active"))        // volume 4
+	mustPut(t, vs, "deleted-too", []byte("gone"))    // volume 5
+	if err := vs.Delete("deleted-too"); err != nil { // tombstone in volume 6
+		t.Fatalf("Delete: %v", err)
+	}
+	mustPut(t, vs, "tail", []byte("active"))
+
+	// Volume 3 holds nothing but the tombstone, which still hides the
+	// record in volume 1, so it must survive
+	if err := vs.compactVolume(3); err != nil {
+		t.Fatalf("compactVolume: %v", err)
+	}
+	// Volume 5 goes first this time, so the tombstone in 6 is obsolete
+	if err := vs.compactVolume(5); err != nil {
+		t.Fatalf("compactVolume: %v", err)
+	}
+	if err := vs.compactVolume(6); err != nil {
+		t.Fatalf("compactVolume: %v", err)
+	}
+	if _, ok := vs.volumes[6]; ok {
+		t.Error("volume 6 was kept although its tombstone hides nothing")
+	}
+
+	vs = reopenVolumes(t, vs)
+	defer vs.close()
+
+	for _, id := range []string{"deleted", "deleted-too"} {
+		if vs.Has(id) {
+			t.Errorf("%s came back after compaction and reopen", id)
+		}
+	}
+	for id, want := range map[string]string{"live": "kept", "other": "active", "tail": "active"} {
+		got, err := vs.Get(id)
+		if err != nil {
+			t.Errorf("Get(%s): %v", id, err)
+		} else if string(got) != want {
+			t.Errorf("Get(%s) = %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestCompactionMovesLiveRecords(t *testing.T) {
+	vs, err := openVolumeStore(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("openVolumeStore: %v", err)
+	}
+
+	payload := bytes.Repeat([]byte("x"), 100)
+	mustPut(t, vs, "a", payload)
+	mustPut(t, vs, "b", []byte("b"))
+	mustPut(t, vs, "a", []byte("replaced"))
+	if err := vs.Delete("b"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	mustPut(t, vs, "c", []byte("c"))
+
+	// Everything sits in volume 1; roll so it can be compacted
+	vs.mu.Lock()
+	if err := vs.roll(); err != nil {
+		t.Fatalf("roll: %v", err)
+	}
+	vs.mu.Unlock()
+	vs.Compact(0.5)
+	if _, ok := vs.volumes[1]; ok {
+		t.Fatal("volume 1 was not compacted")
+	}
+
+	mustPut(t, vs, "d", []byte("after"))
+	vs = reopenVolumes(t, vs)
+	defer vs.close()
+
+	if vs.Has("b") {
+		t.Error("deleted object came back after reopen")
+	}
+	for id, want := range map[string]string{"a": "replaced", "c": "c", "d": "after"} {
+		got, err := vs.Get(id)
+		if err != nil {
+			t.Errorf("Get(%s): %v", id, err)
+		} else if string(got) != want {
+			t.Errorf("Get(%s) = %q, want %q", id, got, want)
+		}
+	}
+}
+
+func mustPut(t *testing.T, vs *volumeStore, id string, data []byte) {
+	t.Helper()
+	if err := vs.Put(id, data); err != nil {
+		t.Fatalf("Put(%s): %v", id, err)
+	}
+}
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxFileNameBytes is the longest sanitized filename, in bytes of UTF-8
+const MaxFileNameBytes = 255
+
+// maxKeptExtension is the longest extension preserved when a name is
+// truncated; longer ones are truncated with the rest of the name
+const maxKeptExtension = 32
+
+// reservedNames are device names that cannot be used as filenames on
+// Windows, with or without an extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFileName makes a client-supplied filename safe to store and to
+// hand back to other clients. The name is normalized to NFC; path
+// separators, traversal sequences and characters reserved on common
+// filesystems become "_"; control, bidirectional override and other
+// invisible formatting characters are removed; reserved device names are
+// prefixed with "_"; and the result is truncated to MaxFileNameBytes on a
+// rune boundary, keeping the extension.
+func SanitizeFileName(filename string) string {
+	filename = strings.ToValidUTF8(filename, "_")
+	filename = norm.NFC.String(filename)
+
+	var b strings.Builder
+	for _, r := range filename {
+		switch {
+		case r == '/' || r == '\\' || r == '~':
+			b.WriteRune('_')
+		case strings.ContainsRune(`<>:"|?*`, r):
+			b.WriteRune('_')
+		case unicode.IsControl(r) || isInvisibleFormat(r):
+			// dropped
+		case unicode.IsSpace(r):
+			b.WriteRune(' ')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	filename = strings.ReplaceAll(b.String(), "..", "_")
+
+	// Windows drops trailing dots and spaces, and leading spaces are
+	// rarely intended
+	filename = strings.TrimLeft(filename, " ")
+	filename = strings.TrimRight(filename, " .")
+
+	stem := filename
+	if i := strings.IndexByte(stem, '.'); i >= 0 {
+		stem = stem[:i]
+	}
+	if reservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		filename = "_" + filename
+	}
+
+	return truncateFileName(filename, MaxFileNameBytes)
+}
+
+// isInvisibleFormat reports whether r is a format character that can
+// disguise a name, such as a bidirectional override that makes
+// "txt.exe" display as "exe.txt". Joiners used by emoji sequences are kept.
+func isInvisibleFormat(r rune) bool {
+	switch r {
+	case '\u200c', '\u200d':
+		return false
+	}
+	return unicode.Is(unicode.Cf, r)
+}
+
+// truncateFileName shortens name to at most max bytes without splitting a
+// rune, preserving a reasonably short extension
+func truncateFileName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	ext := filepath.Ext(name)
+	if len(ext) > maxKeptExtension || len(ext) >= max {
+		ext = ""
+	}
+	return truncateUTF8(strings.TrimSuffix(name, ext), max-len(ext)) + ext
+}
+
+// truncateUTF8 returns the longest prefix of s of at most max bytes that
+// ends on a rune boundary
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+// ContentDisposition formats a Content-Disposition header value as
+// described in RFC 6266. The quoted filename parameter carries an ASCII
+// approximation of name for old clients, and filename* carries the exact
+// name percent-encoded as UTF-8 (RFC 5987) whenever the two differ.
+func ContentDisposition(disposition, name string) string {
+	if name == "" {
+		return disposition
+	}
+
+	fallback := asciiFileName(name)
+	value := disposition + `; filename="` + fallback + `"`
+	if fallback != name {
+		value += "; filename*=UTF-8''" + encodeRFC5987(name)
+	}
+	return value
+}
+
+// asciiFileName approximates name in printable ASCII, stripping accents
+// and replacing anything else, including quotes and backslashes, with "_"
+func asciiFileName(name string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(name) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// accent split off by NFD
+		case r == '"' || r == '\\' || r == '%':
+			b.WriteByte('_')
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// encodeRFC5987 percent-encodes every byte of s outside attr-char
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isAttrChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+	return b.String()
+}
+
+// isAttrChar reports whether c may appear unencoded in an RFC 5987 value
+func isAttrChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"../../etc/passwd", "____etc_passwd"},
+		{`dir\file.txt`, "dir_file.txt"},
+		{"evil\r\nSet-Cookie: x.txt", "evilSet-Cookie_ x.txt"},
+		{"tab\there.txt", "tabhere.txt"},
+		{"no-break\u00a0space.txt", "no-break space.txt"},
+		{"nul\x00byte.txt", "nulbyte.txt"},
+		{`quote".txt`, "quote_.txt"},
+		// U+202E makes "invoice\u202etxt.exe" display as "invoiceexe.txt"
+		{"invoice\u202etxt.exe", "invoicetxt.exe"},
+		{"\u2066hidden\u2069.txt", "hidden.txt"},
+		// Zero-width joiners hold emoji sequences together
+		{"family\U0001F468\u200d\U0001F469.png", "family\U0001F468\u200d\U0001F469.png"},
+		// NFD input is stored as NFC
+		{"cafe\u0301.txt", "caf\u00e9.txt"},
+		{"CON", "_CON"},
+		{"con.txt", "_con.txt"},
+		{"LPT1.log", "_LPT1.log"},
+		{"console.txt", "console.txt"},
+		{"trailing. . ", "trailing"},
+		{"  leading.txt", "leading.txt"},
+		{"bad\xffutf8.txt", "bad_utf8.txt"},
+	}
+	for _, tt := range tests {
+		if got := SanitizeFileName(tt.name); got != tt.want {
+			t.Errorf("SanitizeFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeFileNameTruncates(t *testing.T) {
+	// Three-byte runes never line up with the limit
+	long := strings.Repeat("é中", 100) + ".txt"
+	got := SanitizeFileName(long)
+	if len(got) > MaxFileNameBytes {
+		t.Errorf("sanitized name has %d bytes, want at most %d", len(got), MaxFileNameBytes)
+	}
+	if !utf8.ValidString(got) {
+		t.Errorf("truncation split a rune: %q", got)
+	}
+	if !strings.HasSuffix(got, ".txt") {
+		t.Errorf("truncation dropped the extension: %q", got)
+	}
+
+	// An overlong extension is truncated with the rest of the name
+	got = SanitizeFileName("a." + strings.Repeat("x", 300))
+	if len(got) > MaxFileNameBytes || !strings.HasPrefix(got, "a.") {
+		t.Errorf("name with a long extension sanitized to %d bytes: %q", len(got), got)
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		disposition, name, want string
+	}{
+		{"inline", "", "inline"},
+		{"inline", "report.pdf", `inline; filename="report.pdf"`},
+		{"attachment", "café.txt", `attachment; filename="cafe.txt"; filename*=UTF-8''caf%C3%A9.txt`},
+		{"attachment", "中文.txt", `attachment; filename="__.txt"; filename*=UTF-8''%E4%B8%AD%E6%96%87.txt`},
+		{"inline", `a"b\c%d.txt`, `inline; filename="a_b_c_d.txt"; filename*=UTF-8''a%22b%5Cc%25d.txt`},
+		{"inline", "two words.txt", `inline; filename="two words.txt"`},
+	}
+	for _, tt := range tests {
+		if got := ContentDisposition(tt.disposition, tt.name); got != tt.want {
+			t.Errorf("ContentDisposition(%q, %q) = %s, want %s", tt.disposition, tt.name, got, tt.want)
+		}
+	}
+
+	// Whatever the name, the header value stays on one line
+	value := ContentDisposition("inline", "a\r\nSet-Cookie: x")
+	if strings.ContainsAny(value, "\r\n") {
+		t.Errorf("header value contains a line break: %q", value)
+	}
+}
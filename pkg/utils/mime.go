@@ -1,111 +1,372 @@
 package utils
 
 import (
+	"bufio"
+	"encoding/json"
+	"fmt"
 	"mime"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
+// builtinTypes seeds the MIME registry. The first extension of each type is
+// the one files of that type are stored with.
+var builtinTypes = map[string][]string{
+	"text/plain":                    {".txt", ".text", ".log"},
+	"text/html":                     {".html", ".htm"},
+	"text/css":                      {".css"},
+	"text/csv":                      {".csv"},
+	"text/markdown":                 {".md", ".markdown"},
+	"application/javascript":        {".js", ".mjs"},
+	"application/json":              {".json"},
+	"application/xml":               {".xml"},
+	"application/pdf":               {".pdf"},
+	"application/zip":               {".zip"},
+	"application/x-tar":             {".tar"},
+	"application/gzip":              {".gz", ".tgz"},
+	"application/x-bzip2":           {".bz2"},
+	"application/x-xz":              {".xz"},
+	"application/zstd":              {".zst"},
+	"application/x-7z-compressed":   {".7z"},
+	"application/vnd.rar":           {".rar"},
+	"application/wasm":              {".wasm"},
+	"image/png":                     {".png"},
+	"image/jpeg":                    {".jpg", ".jpeg", ".jpe"},
+	"image/gif":                     {".gif"},
+	"image/webp":                    {".webp"},
+	"image/svg+xml":                 {".svg", ".svgz"},
+	"image/tiff":                    {".tif", ".tiff"},
+	"image/bmp":                     {".bmp"},
+	"image/x-icon":                  {".ico"},
+	"image/heic":                    {".heic"},
+	"image/avif":                    {".avif"},
+	"video/mp4":                     {".mp4", ".m4v"},
+	"video/webm":                    {".webm"},
+	"video/x-matroska":              {".mkv"},
+	"video/x-msvideo":               {".avi"},
+	"video/quicktime":               {".mov", ".qt"},
+	"audio/mpeg":                    {".mp3"},
+	"audio/mp4":                     {".m4a"},
+	"audio/wav":                     {".wav"},
+	"audio/flac":                    {".flac"},
+	"audio/ogg":                     {".ogg", ".oga", ".opus"},
+	"application/msword":            {".doc"},
+	"application/vnd.ms-excel":      {".xls"},
+	"application/vnd.ms-powerpoint": {".ppt"},
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   {".docx"},
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         {".xlsx"},
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": {".pptx"},
+	"application/vnd.oasis.opendocument.text":                                   {".odt"},
+	"application/vnd.oasis.opendocument.spreadsheet":                            {".ods"},
+	"application/vnd.oasis.opendocument.presentation":                           {".odp"},
+	"application/epub+zip":     {".epub"},
+	"application/octet-stream": {".bin"},
+}
+
+// builtinAliases maps non-standard or legacy type names to the type they
+// stand for
+var builtinAliases = map[string]string{
+	"image/jpg":                    "image/jpeg",
+	"image/pjpeg":                  "image/jpeg",
+	"image/vnd.microsoft.icon":     "image/x-icon",
+	"text/javascript":              "application/javascript",
+	"application/x-javascript":     "application/javascript",
+	"text/xml":                     "application/xml",
+	"text/x-markdown":              "text/markdown",
+	"application/x-gzip":           "application/gzip",
+	"application/x-zip-compressed": "application/zip",
+	"application/x-rar-compressed": "application/vnd.rar",
+	"audio/mp3":                    "audio/mpeg",
+	"audio/x-wav":                  "audio/wav",
+	"audio/wave":                   "audio/wav",
+	"audio/x-flac":                 "audio/flac",
+	"video/avi":                    "video/x-msvideo",
+}
+
+// systemMIMEFiles are the mime.types locations consulted at startup, the
+// same ones the standard library reads
+var systemMIMEFiles = []string{
+	"/etc/mime.types",
+	"/etc/apache2/mime.types",
+	"/etc/apache/mime.types",
+	"/etc/httpd/conf/mime.types",
+}
+
+// MIMERegistry maps content types to file extensions and back. Each type
+// has a preferred extension, and aliases resolve to a canonical type.
+type MIMERegistry struct {
+	mu      sync.RWMutex
+	exts    map[string][]string // canonical type -> extensions, preferred first
+	types   map[string]string   // extension -> canonical type
+	aliases map[string]string   // alias -> canonical type
+}
+
+// NewMIMERegistry creates an empty registry
+func NewMIMERegistry() *MIMERegistry {
+	return &MIMERegistry{
+		exts:    make(map[string][]string),
+		types:   make(map[string]string),
+		aliases: make(map[string]string),
+	}
+}
+
+// mimeRegistry is the process-wide registry, seeded from the built-ins and
+// the system mime.types files
+var mimeRegistry = newDefaultMIMERegistry()
+
+func newDefaultMIMERegistry() *MIMERegistry {
+	r := NewMIMERegistry()
+	for contentType, exts := range builtinTypes {
+		r.AddType(contentType, exts...)
+	}
+	for alias, contentType := range builtinAliases {
+		r.AddAlias(alias, contentType)
+	}
+	for _, path := range systemMIMEFiles {
+		r.loadMIMETypesFile(path)
+	}
+	return r
+}
+
+// MIME returns the process-wide MIME registry
+func MIME() *MIMERegistry {
+	return mimeRegistry
+}
+
+// AddType registers extensions for a content type. The first extension
+// becomes the preferred one, and the extensions now map to this type.
+func (r *MIMERegistry) AddType(contentType string, exts ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	contentType = r.canonical(contentType)
+	var normalized []string
+	for _, ext := range exts {
+		if ext = normalizeExt(ext); ext != "" {
+			if previous, ok := r.types[ext]; ok && previous != contentType {
+				r.exts[previous] = removeString(r.exts[previous], ext)
+			}
+			normalized = append(normalized, ext)
+			r.types[ext] = contentType
+		}
+	}
+
+	// Keep previously known extensions after the new ones
+	for _, ext := range r.exts[contentType] {
+		if !containsString(normalized, ext) {
+			normalized = append(normalized, ext)
+		}
+	}
+	r.exts[contentType] = normalized
+}
+
+// AddAlias makes alias resolve to contentType
+func (r *MIMERegistry) AddAlias(alias, contentType string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	alias = normalizeType(alias)
+	contentType = r.canonical(contentType)
+	if alias != contentType {
+		r.aliases[alias] = contentType
+	}
+}
+
+// Canonical returns the canonical form of a content type: lower-cased,
+// without parameters and with aliases resolved
+func (r *MIMERegistry) Canonical(contentType string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.canonical(contentType)
+}
+
+func (r *MIMERegistry) canonical(contentType string) string {
+	contentType = normalizeType(contentType)
+	if target, ok := r.aliases[contentType]; ok {
+		return target
+	}
+	return contentType
+}
+
+// TypeByExtension returns the content type for an extension such as ".jpg"
+func (r *MIMERegistry) TypeByExtension(ext string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	contentType, ok := r.types[normalizeExt(ext)]
+	return contentType, ok
+}
+
+// ExtensionByType returns the preferred extension for a content type
+func (r *MIMERegistry) ExtensionByType(contentType string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	exts := r.exts[r.canonical(contentType)]
+	if len(exts) == 0 {
+		return "", false
+	}
+	return exts[0], true
+}
+
+// loadMIMETypesFile adds the entries of a mime.types file for types and
+// extensions the registry does not know yet. Missing files are ignored.
+func (r *MIMERegistry) loadMIMETypesFile(path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		var exts []string
+		for _, ext := range fields[1:] {
+			if strings.HasPrefix(ext, "#") {
+				break
+			}
+			exts = append(exts, ext)
+		}
+		r.addFallback(fields[0], exts)
+	}
+}
+
+// addFallback registers extensions that are not known yet. The preferred
+// extension of a known type never changes.
+func (r *MIMERegistry) addFallback(contentType string, exts []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	contentType = r.canonical(contentType)
+	for _, ext := range exts {
+		ext = normalizeExt(ext)
+		if _, known := r.types[ext]; ext == "" || known {
+			continue
+		}
+		r.types[ext] = contentType
+		r.exts[contentType] = append(r.exts[contentType], ext)
+	}
+}
+
+// mimeConfig is the format of the file passed to LoadMIMEConfig
+type mimeConfig struct {
+	// Types maps content types to extensions, preferred first
+	Types map[string][]string `json:"types"`
+	// Aliases maps alternative names to content types
+	Aliases map[string]string `json:"aliases"`
+}
+
+// LoadMIMEConfig extends the process-wide registry from a JSON file such as
+//
+//	{"types": {"application/x-foo": [".foo", ".fo"]}, "aliases": {"application/foo": "application/x-foo"}}
+//
+// Entries override the built-ins, so the file can also change the preferred
+// extension of a known type.
+func LoadMIMEConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var cfg mimeConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("invalid MIME config %s: %w", path, err)
+	}
+
+	// Aliases first, so types registered under an alias land on the
+	// canonical name
+	for alias, contentType := range cfg.Aliases {
+		mimeRegistry.AddAlias(alias, contentType)
+	}
+	for contentType, exts := range cfg.Types {
+		mimeRegistry.AddType(contentType, exts...)
+	}
+	return nil
+}
+
 // GetContentTypeFromExtension returns MIME type based on file extension
 func GetContentTypeFromExtension(filename string) string {
-	ext := strings.ToLower(filepath.Ext(filename))
-	
-	// Common extensions mapping
-	extToMime := map[string]string{
-		".txt":  "text/plain",
-		".html": "text/html",
-		".css":  "text/css",
-		".js":   "application/javascript",
-		".json": "application/json",
-		".xml":  "application/xml",
-		".pdf":  "application/pdf",
-		".zip":  "application/zip",
-		".tar":  "application/x-tar",
-		".gz":   "application/gzip",
-		".png":  "image/png",
-		".jpg":  "image/jpeg",
-		".jpeg": "image/jpeg",
-		".gif":  "image/gif",
-		".svg":  "image/svg+xml",
-		".mp4":  "video/mp4",
-		".mp3":  "audio/mpeg",
-		".wav":  "audio/wav",
-		".avi":  "video/x-msvideo",
-		".mov":  "video/quicktime",
-		".doc":  "application/msword",
-		".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
-		".xls":  "application/vnd.ms-excel",
-		".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
-		".ppt":  "application/vnd.ms-powerpoint",
-		".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
-	}
-	
-	if mimeType, exists := extToMime[ext]; exists {
-		return mimeType
-	}
-	
-	// Fallback to Go's built-in MIME type detection
-	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
-		return mimeType
-	}
-	
+	ext := filepath.Ext(filename)
+	if contentType, ok := mimeRegistry.TypeByExtension(ext); ok {
+		return contentType
+	}
+
+	// Fall back to the platform's own tables
+	if contentType := mime.TypeByExtension(strings.ToLower(ext)); contentType != "" {
+		return mimeRegistry.Canonical(contentType)
+	}
+
 	// Default to binary if unknown
 	return "application/octet-stream"
 }
 
 // GetExtensionFromContentType returns file extension based on MIME type
 func GetExtensionFromContentType(contentType string) string {
-	// Remove charset and other parameters
-	contentType = strings.Split(contentType, ";")[0]
-	
-	mimeToExt := map[string]string{
-		"text/plain":                      ".txt",
-		"text/html":                       ".html",
-		"text/css":                        ".css",
-		"application/javascript":          ".js",
-		"application/json":                ".json",
-		"application/xml":                 ".xml",
-		"application/pdf":                 ".pdf",
-		"application/zip":                 ".zip",
-		"application/x-tar":               ".tar",
-		"application/gzip":                ".gz",
-		"image/png":                       ".png",
-		"image/jpeg":                      ".jpg",
-		"image/gif":                       ".gif",
-		"image/svg+xml":                   ".svg",
-		"video/mp4":                       ".mp4",
-		"audio/mpeg":                      ".mp3",
-		"audio/wav":                       ".wav",
-		"video/x-msvideo":                 ".avi",
-		"video/quicktime":                 ".mov",
-		"application/msword":              ".doc",
-		"application/vnd.openxmlformats-officedocument.wordprocessingml.document": ".docx",
-		"application/vnd.ms-excel":        ".xls",
-		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": ".xlsx",
-		"application/vnd.ms-powerpoint":   ".ppt",
-		"application/vnd.openxmlformats-officedocument.presentationml.presentation": ".pptx",
-	}
-	
-	if ext, exists := mimeToExt[contentType]; exists {
+	if ext, ok := mimeRegistry.ExtensionByType(contentType); ok {
 		return ext
 	}
-	
+
 	// Default extension for unknown types
 	return ".bin"
 }
 
-// SanitizeFileName removes dangerous characters from filename
-func SanitizeFileName(filename string) string {
-	// Remove path separators and other dangerous characters
-	filename = strings.ReplaceAll(filename, "/", "_")
-	filename = strings.ReplaceAll(filename, "\\", "_")
-	filename = strings.ReplaceAll(filename, "..", "_")
-	filename = strings.ReplaceAll(filename, "~", "_")
-	
-	// Limit length
-	if len(filename) > 255 {
-		filename = filename[:255]
-	}
-	
-	return filename
+// ExtensionFor picks the extension a file is stored with: the preferred
+// extension of its content type, else the extension of its name, else
+// ".bin". Generic binary content keeps the extension of its name.
+func ExtensionFor(contentType, filename string) string {
+	if contentType != "" && mimeRegistry.Canonical(contentType) != "application/octet-stream" {
+		if ext, ok := mimeRegistry.ExtensionByType(contentType); ok {
+			return ext
+		}
+	}
+	if ext := normalizeExt(filepath.Ext(filename)); ext != "" {
+		return ext
+	}
+	return ".bin"
+}
+
+// normalizeType lower-cases a content type and strips its parameters
+func normalizeType(contentType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+}
+
+// normalizeExt lower-cases an extension and adds the leading dot. Anything
+// that is not a short alphanumeric extension yields "".
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+	if ext == "" || len(ext) > 16 {
+		return ""
+	}
+	for _, c := range ext {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '+') {
+			return ""
+		}
+	}
+	return "." + ext
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	kept := list[:0]
+	for _, item := range list {
+		if item != s {
+			kept = append(kept, item)
+		}
+	}
+	return kept
 }
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withDefaultRegistry gives the test a fresh process-wide registry and
+// restores the previous one when it ends
+func withDefaultRegistry(t *testing.T) {
+	t.Helper()
+	saved := mimeRegistry
+	mimeRegistry = newDefaultMIMERegistry()
+	t.Cleanup(func() { mimeRegistry = saved })
+}
+
+func TestMIMERegistry(t *testing.T) {
+	withDefaultRegistry(t)
+
+	tests := []struct {
+		contentType, ext string
+	}{
+		{"image/jpeg", ".jpg"},
+		{"image/jpg", ".jpg"},
+		{"image/pjpeg", ".jpg"},
+		{"IMAGE/JPEG; charset=binary", ".jpg"},
+		{"text/plain", ".txt"},
+		{"text/xml", ".xml"},
+		{"application/x-zip-compressed", ".zip"},
+	}
+	for _, tt := range tests {
+		if got := GetExtensionFromContentType(tt.contentType); got != tt.ext {
+			t.Errorf("GetExtensionFromContentType(%q) = %q, want %q", tt.contentType, got, tt.ext)
+		}
+	}
+
+	// Every extension of a type leads back to its canonical name
+	for name, want := range map[string]string{
+		"photo.jpg":  "image/jpeg",
+		"photo.JPEG": "image/jpeg",
+		"notes.log":  "text/plain",
+		"a.tgz":      "application/gzip",
+		"noext":      "application/octet-stream",
+	} {
+		if got := GetContentTypeFromExtension(name); got != want {
+			t.Errorf("GetContentTypeFromExtension(%q) = %q, want %q", name, got, want)
+		}
+	}
+	if got := MIME().Canonical("audio/mp3"); got != "audio/mpeg" {
+		t.Errorf("Canonical(audio/mp3) = %q, want audio/mpeg", got)
+	}
+}
+
+func TestExtensionFor(t *testing.T) {
+	withDefaultRegistry(t)
+
+	tests := []struct {
+		contentType, filename, want string
+	}{
+		{"image/jpeg", "photo.jpeg", ".jpg"},
+		{"image/jpeg", "photo", ".jpg"},
+		// Unknown types keep the extension of the uploaded name
+		{"application/x-unknown-thing", "model.STEP", ".step"},
+		{"application/octet-stream", "firmware.img", ".img"},
+		{"", "archive.7z", ".7z"},
+		{"application/x-unknown-thing", "noext", ".bin"},
+		{"application/x-unknown-thing", "bad.ext with space", ".bin"},
+	}
+	for _, tt := range tests {
+		if got := ExtensionFor(tt.contentType, tt.filename); got != tt.want {
+			t.Errorf("ExtensionFor(%q, %q) = %q, want %q", tt.contentType, tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestLoadMIMEConfig(t *testing.T) {
+	withDefaultRegistry(t)
+
+	path := filepath.Join(t.TempDir(), "mime.json")
+	config := `{
+		"types": {
+			"application/x-dvfs-model": [".dvm", ".dvmodel"],
+			"image/jpeg": [".jpeg"],
+			"application/vnd.dvfs.plan": [".plan"]
+		},
+		"aliases": {"application/dvfs-model": "application/x-dvfs-model", "application/x-plan": "application/vnd.dvfs.plan"}
+	}`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadMIMEConfig(path); err != nil {
+		t.Fatalf("LoadMIMEConfig: %v", err)
+	}
+
+	tests := []struct {
+		contentType, ext string
+	}{
+		{"application/x-dvfs-model", ".dvm"},
+		{"application/dvfs-model", ".dvm"},
+		{"application/x-plan", ".plan"},
+		// The file overrides the preferred extension of a built-in type
+		{"image/jpeg", ".jpeg"},
+		{"image/jpg", ".jpeg"},
+	}
+	for _, tt := range tests {
+		if got := GetExtensionFromContentType(tt.contentType); got != tt.ext {
+			t.Errorf("GetExtensionFromContentType(%q) = %q, want %q", tt.contentType, got, tt.ext)
+		}
+	}
+	if got := GetContentTypeFromExtension("a.dvmodel"); got != "application/x-dvfs-model" {
+		t.Errorf("GetContentTypeFromExtension(a.dvmodel) = %q", got)
+	}
+	// The previous preferred extension still maps to the type
+	if got := GetContentTypeFromExtension("a.jpg"); got != "image/jpeg" {
+		t.Errorf("GetContentTypeFromExtension(a.jpg) = %q", got)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadMIMEConfig(path); err == nil {
+		t.Error("LoadMIMEConfig accepted invalid JSON")
+	}
+}
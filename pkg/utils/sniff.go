@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"strings"
+)
+
+// SniffLen is the number of leading bytes DetectContentType looks at
+const SniffLen = 8192
+
+// signature identifies a format by the bytes at a fixed offset
+type signature struct {
+	offset      int
+	magic       []byte
+	contentType string
+}
+
+// signatures covers formats http.DetectContentType does not know or
+// reports only generically. More specific entries come first.
+var signatures = []signature{
+	{0, []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}, "application/x-ole-storage"},
+	{0, []byte("7z\xBC\xAF\x27\x1C"), "application/x-7z-compressed"},
+	{0, []byte("Rar!\x1A\x07"), "application/vnd.rar"},
+	{0, []byte{0x1F, 0x8B}, "application/gzip"},
+	{0, []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}, "application/x-xz"},
+	{0, []byte{0x28, 0xB5, 0x2F, 0xFD}, "application/zstd"},
+	{257, []byte("ustar"), "application/x-tar"},
+	{0, []byte("%PDF-"), "application/pdf"},
+	{0, []byte("fLaC"), "audio/flac"},
+	{0, []byte("ID3"), "audio/mpeg"},
+	{0, []byte("II*\x00"), "image/tiff"},
+	{0, []byte("MM\x00*"), "image/tiff"},
+	{0, []byte("8BPS"), "image/vnd.adobe.photoshop"},
+	{0, []byte("SQLite format 3\x00"), "application/vnd.sqlite3"},
+	{0, []byte("\x00asm"), "application/wasm"},
+	{0, []byte("\x7FELF"), "application/x-executable"},
+}
+
+// ftypBrands maps ISO base media brands to content types
+var ftypBrands = map[string]string{
+	"qt  ": "video/quicktime",
+	"M4A ": "audio/mp4",
+	"M4V ": "video/mp4",
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"mif1": "image/heif",
+	"avif": "image/avif",
+	"3gp4": "video/3gpp",
+	"3gp5": "video/3gpp",
+}
+
+// zipContainers maps the marker entries of zip-based formats to content
+// types. The first entries of such archives appear within the sniffed
+// prefix.
+var zipContainers = []struct {
+	marker      string
+	contentType string
+}{
+	{"word/", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	{"xl/", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	{"ppt/", "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+	{"mimetypeapplication/vnd.oasis.opendocument.text", "application/vnd.oasis.opendocument.text"},
+	{"mimetypeapplication/vnd.oasis.opendocument.spreadsheet", "application/vnd.oasis.opendocument.spreadsheet"},
+	{"mimetypeapplication/vnd.oasis.opendocument.presentation", "application/vnd.oasis.opendocument.presentation"},
+	{"mimetypeapplication/epub+zip", "application/epub+zip"},
+	{"META-INF/MANIFEST.MF", "application/java-archive"},
+	{"AndroidManifest.xml", "application/vnd.android.package-archive"},
+}
+
+// DetectContentType identifies the format of data from its leading bytes.
+// It extends http.DetectContentType with office documents, archives and
+// more media formats, and returns "application/octet-stream" when nothing
+// matches.
+func DetectContentType(data []byte) string {
+	if len(data) == 0 {
+		return "application/octet-stream"
+	}
+	if len(data) > SniffLen {
+		data = data[:SniffLen]
+	}
+
+	for _, sig := range signatures {
+		if len(data) >= sig.offset+len(sig.magic) && bytes.Equal(data[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+			return sig.contentType
+		}
+	}
+
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" {
+		if contentType, ok := ftypBrands[string(data[8:12])]; ok {
+			return contentType
+		}
+	}
+
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		for _, container := range zipContainers {
+			if bytes.Contains(data, []byte(container.marker)) {
+				return container.contentType
+			}
+		}
+		return "application/zip"
+	}
+
+	// bzip2 headers carry the block size digit after the magic
+	if len(data) >= 4 && bytes.HasPrefix(data, []byte("BZh")) && data[3] >= '1' && data[3] <= '9' {
+		return "application/x-bzip2"
+	}
+
+	// Windows executables start with an MZ stub pointing at a PE header
+	if len(data) >= 0x40 && bytes.HasPrefix(data, []byte("MZ")) {
+		pe := int(binary.LittleEndian.Uint32(data[0x3C:0x40]))
+		if pe >= 0x40 && pe+4 <= len(data) && string(data[pe:pe+4]) == "PE\x00\x00" {
+			return "application/vnd.microsoft.portable-executable"
+		}
+	}
+
+	if bytes.HasPrefix(data, []byte{0x1A, 0x45, 0xDF, 0xA3}) {
+		if bytes.Contains(data, []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	}
+
+	detected := http.DetectContentType(data)
+	return strings.TrimSpace(strings.Split(detected, ";")[0])
+}
+
+// contentFamilies groups declared types that a generic sniffed type is
+// consistent with
+var contentFamilies = map[string][]string{
+	"text/html":       {"text/", "application/xhtml+xml"},
+	"application/xml": {"text/", "image/svg+xml", "application/rss+xml", "application/atom+xml", "application/xhtml+xml"},
+	"application/zip": {
+		"application/zip", "application/vnd.openxmlformats-officedocument.", "application/vnd.oasis.opendocument.",
+		"application/epub+zip", "application/java-archive", "application/vnd.android.package-archive",
+	},
+	"application/x-ole-storage": {
+		"application/msword", "application/vnd.ms-excel", "application/vnd.ms-powerpoint",
+		"application/vnd.ms-outlook", "application/x-msi",
+	},
+	"application/gzip":         {"application/x-tar"},
+	"video/mp4":                {"video/mp4", "audio/mp4", "video/quicktime"},
+	"video/x-matroska":         {"video/x-matroska", "audio/x-matroska", "video/webm"},
+	"application/ogg":          {"application/ogg", "audio/ogg", "video/ogg", "audio/opus"},
+	"application/x-bzip2":      {"application/x-bzip2", "application/x-bzip"},
+	"application/x-executable": {"application/x-executable", "application/x-elf", "application/x-sharedlib"},
+}
+
+// textualTypes lists application types whose content is plain text, beyond
+// those recognized by their +json, +xml or +yaml suffix
+var textualTypes = map[string]bool{
+	"application/json": true, "application/x-ndjson": true, "application/ndjson": true,
+	"application/jsonl": true, "application/json-seq": true, "application/javascript": true,
+	"application/ecmascript": true, "application/x-javascript": true, "application/xml": true,
+	"application/x-yaml": true, "application/yaml": true, "application/toml": true,
+	"application/csv": true, "application/x-csv": true, "application/sql": true,
+	"application/graphql": true, "application/x-sh": true, "application/x-httpd-php": true,
+	"application/x-www-form-urlencoded": true, "application/x-tex": true, "application/x-latex": true,
+	"application/x-subrip": true, "application/rtf": true,
+	"application/mbox": true, "application/pgp-signature": true, "application/pem-certificate-chain": true,
+}
+
+// textual reports whether a canonical content type denotes text, which
+// content sniffed as text/plain may always be labelled
+func textual(contentType string) bool {
+	if strings.HasPrefix(contentType, "text/") || textualTypes[contentType] {
+		return true
+	}
+	for _, suffix := range []string{"+json", "+xml", "+yaml"} {
+		if strings.HasSuffix(contentType, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContentTypeMatches reports whether content sniffed as detected may
+// legitimately be labelled declared. Aliases such as image/jpg are
+// resolved first. Unrecognized content matches anything, since the
+// sniffer cannot prove a mismatch.
+func ContentTypeMatches(declared, detected string) bool {
+	declared = mimeRegistry.Canonical(declared)
+	detected = mimeRegistry.Canonical(detected)
+
+	if declared == "" || declared == "application/octet-stream" || detected == "" || detected == "application/octet-stream" || declared == detected {
+		return true
+	}
+	if detected == "text/plain" && textual(declared) {
+		return true
+	}
+	for _, prefix := range contentFamilies[detected] {
+		if strings.HasPrefix(declared, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package utils
+
+import "testing"
+
+func TestContentTypeMatches(t *testing.T) {
+	tests := []struct {
+		declared, detected string
+		want               bool
+	}{
+		{"text/plain", "text/plain", true},
+		{"text/csv", "text/plain", true},
+		{"text/csv; charset=utf-8", "text/plain", true},
+		{"text/x-csv", "text/plain", true},
+		{"application/csv", "text/plain", true},
+		{"application/x-ndjson", "text/plain", true},
+		{"application/ndjson", "text/plain", true},
+		{"application/json", "text/plain", true},
+		{"application/geo+json", "text/plain", true},
+		{"application/atom+xml", "text/plain", true},
+		{"image/svg+xml", "text/plain", true},
+		{"application/x-yaml", "text/plain", true},
+		{"image/png", "text/plain", false},
+		{"application/pdf", "text/plain", false},
+		{"application/zip", "text/plain", false},
+		{"text/plain", "image/png", false},
+		{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", "application/zip", true},
+		{"application/x-ndjson", "application/zip", false},
+		{"", "image/png", true},
+		{"image/png", "application/octet-stream", true},
+	}
+	for _, tt := range tests {
+		if got := ContentTypeMatches(tt.declared, tt.detected); got != tt.want {
+			t.Errorf("ContentTypeMatches(%q, %q) = %v, want %v", tt.declared, tt.detected, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,647 @@
+package webhook
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Headers sent with every delivery
+const (
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	TimestampHeader = "X-Webhook-Timestamp"
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the
+	// timestamp, a dot and the body, keyed with the subscription secret
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// Delivery outcomes recorded in the delivery log
+const (
+	StatusDelivered = "delivered"
+	StatusRetrying  = "retrying"
+	StatusFailed    = "failed"
+)
+
+const (
+	// pollInterval is how often the outbox is checked for deliveries that
+	// are due for another attempt
+	pollInterval = time.Second
+	// retryBase is the wait after the first failed attempt; it doubles
+	// with every further failure up to retryMax
+	retryBase = 5 * time.Second
+	retryMax  = time.Hour
+	// deliveryWorkers bounds the deliveries in flight
+	deliveryWorkers = 4
+	// deliveryLogKeep is the number of attempts kept per subscription once
+	// its log grows past deliveryLogMaxBytes
+	deliveryLogKeep     = 1000
+	deliveryLogMaxBytes = 1 << 20
+	// maxResponseError is how much of a failed response is recorded
+	maxResponseError = 256
+)
+
+// Payload is the JSON body of a delivery
+type Payload struct {
+	ID    string      `json:"id"`
+	Event string      `json:"event"`
+	Time  time.Time   `json:"time"`
+	File  interface{} `json:"file"`
+}
+
+// delivery is an outbox entry: one payload for one subscription
+type delivery struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscription_id"`
+	Event          string          `json:"event"`
+	Body           json.RawMessage `json:"body"`
+	Attempts       int             `json:"attempts"`
+	NextAttempt    time.Time       `json:"next_attempt"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// Attempt is a delivery log entry
+type Attempt struct {
+	DeliveryID string    `json:"delivery_id"`
+	Event      string    `json:"event"`
+	Attempt    int       `json:"attempt"`
+	Status     string    `json:"status"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	Time       time.Time `json:"time"`
+	// NextAttempt is when a delivery that is being retried goes out again
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+}
+
+// Dispatcher holds the subscriptions and delivers events to them
+type Dispatcher struct {
+	dir         string
+	client      *http.Client
+	maxAttempts int
+
+	mu   sync.RWMutex
+	subs map[string]*Subscription
+
+	inflightMu sync.Mutex
+	inflight   map[string]bool
+	logMu      sync.Mutex
+
+	slots     chan struct{}
+	wake      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewDispatcher keeps its outbox, delivery logs and API subscriptions in
+// dir and loads the static subscriptions in subscriptionsFile, if set.
+// Deliveries still in the outbox from a previous run are resumed.
+func NewDispatcher(dir, subscriptionsFile string, timeout time.Duration, maxAttempts int) (*Dispatcher, error) {
+	for _, sub := range []string{"outbox", "deliveries"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create webhook directory: %w", err)
+		}
+	}
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	d := &Dispatcher{
+		dir:         dir,
+		client:      &http.Client{Timeout: timeout},
+		maxAttempts: maxAttempts,
+		subs:        make(map[string]*Subscription),
+		inflight:    make(map[string]bool),
+		slots:       make(chan struct{}, deliveryWorkers),
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+
+	saved, err := loadSubscriptions(d.subscriptionsPath())
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range saved {
+		sub.Static = false
+		d.subs[sub.ID] = sub
+	}
+
+	if subscriptionsFile != "" {
+		static, err := loadSubscriptions(subscriptionsFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, sub := range static {
+			if _, ok := d.subs[sub.ID]; ok {
+				return nil, fmt.Errorf("subscription %q in %s: %w", sub.ID, subscriptionsFile, ErrExists)
+			}
+			sub.Static = true
+			if sub.CreatedAt.IsZero() {
+				sub.CreatedAt = time.Now().UTC()
+			}
+			d.subs[sub.ID] = sub
+		}
+	}
+
+	d.wg.Add(1)
+	go d.run()
+	return d, nil
+}
+
+// Close stops dispatching once deliveries in flight have finished.
+// Undelivered events stay in the outbox for the next start.
+func (d *Dispatcher) Close() {
+	d.closeOnce.Do(func() {
+		close(d.done)
+	})
+	d.wg.Wait()
+}
+
+// Subscriptions returns every subscription ordered by ID, without secrets
+func (d *Dispatcher) Subscriptions() []*Subscription {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	subs := make([]*Subscription, 0, len(d.subs))
+	for _, sub := range d.subs {
+		subs = append(subs, sub.Redacted())
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].ID < subs[j].ID })
+	return subs
+}
+
+// Subscription returns one subscription without its secret
+func (d *Dispatcher) Subscription(id string) (*Subscription, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	sub, ok := d.subs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return sub.Redacted(), nil
+}
+
+// Subscribe adds a subscription through the API. A missing ID or secret is
+// generated. The returned subscription includes the secret, which is not
+// shown again.
+func (d *Dispatcher) Subscribe(sub *Subscription) (*Subscription, error) {
+	created := *sub
+	created.Static = false
+	created.CreatedAt = time.Now().UTC()
+	if created.ID == "" {
+		created.ID = uuid.New().String()
+	}
+	if created.Secret == "" {
+		secret, err := newSecret()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate secret: %w", err)
+		}
+		created.Secret = secret
+	}
+	if err := created.validate(); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.subs[created.ID]; ok {
+		return nil, ErrExists
+	}
+	d.subs[created.ID] = &created
+	if err := d.saveLocked(); err != nil {
+		delete(d.subs, created.ID)
+		return nil, fmt.Errorf("failed to save subscriptions: %w", err)
+	}
+	return &created, nil
+}
+
+// Unsubscribe removes a subscription added through the API, along with its
+// delivery log. Its pending deliveries are dropped.
+func (d *Dispatcher) Unsubscribe(id string) error {
+	d.mu.Lock()
+	sub, ok := d.subs[id]
+	if !ok {
+		d.mu.Unlock()
+		return ErrNotFound
+	}
+	if sub.Static {
+		d.mu.Unlock()
+		return ErrStatic
+	}
+	delete(d.subs, id)
+	if err := d.saveLocked(); err != nil {
+		d.subs[id] = sub
+		d.mu.Unlock()
+		return fmt.Errorf("failed to save subscriptions: %w", err)
+	}
+	d.mu.Unlock()
+
+	// Attempts finishing after this see the subscription gone and are not
+	// recorded, so the log is not recreated
+	d.logMu.Lock()
+	os.Remove(d.logPath(id))
+	d.logMu.Unlock()
+	return nil
+}
+
+// saveLocked persists the API subscriptions; d.mu must be held
+func (d *Dispatcher) saveLocked() error {
+	var subs []*Subscription
+	for _, sub := range d.subs {
+		if !sub.Static {
+			subs = append(subs, sub)
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].ID < subs[j].ID })
+	return saveSubscriptions(d.subscriptionsPath(), subs)
+}
+
+// Emit queues event for every subscription that selects it. The file is
+// encoded into the payload as it is now.
+func (d *Dispatcher) Emit(event string, file interface{}) error {
+	d.mu.RLock()
+	var targets []string
+	for _, sub := range d.subs {
+		if sub.Wants(event) {
+			targets = append(targets, sub.ID)
+		}
+	}
+	d.mu.RUnlock()
+	if len(targets) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	body, err := json.Marshal(&Payload{
+		ID:    uuid.New().String(),
+		Event: event,
+		Time:  now,
+		File:  file,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range targets {
+		dl := &delivery{
+			ID:             uuid.New().String(),
+			SubscriptionID: id,
+			Event:          event,
+			Body:           body,
+			NextAttempt:    now,
+			CreatedAt:      now,
+		}
+		if err := d.saveDelivery(dl); err != nil {
+			return fmt.Errorf("failed to queue webhook delivery: %w", err)
+		}
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Deliveries returns up to limit of the most recent delivery attempts for a
+// subscription, newest first
+func (d *Dispatcher) Deliveries(id string, limit int) ([]Attempt, error) {
+	if _, err := d.Subscription(id); err != nil {
+		return nil, err
+	}
+
+	d.logMu.Lock()
+	attempts, err := readAttempts(d.logPath(id))
+	d.logMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(attempts)-1; i < j; i, j = i+1, j-1 {
+		attempts[i], attempts[j] = attempts[j], attempts[i]
+	}
+	if limit > 0 && len(attempts) > limit {
+		attempts = attempts[:limit]
+	}
+	return attempts, nil
+}
+
+// run dispatches due deliveries until Close is called
+func (d *Dispatcher) run() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.dispatchDue()
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// dispatchDue starts every outbox delivery whose next attempt is due
+func (d *Dispatcher) dispatchDue() {
+	entries, err := os.ReadDir(d.outboxPath())
+	if err != nil {
+		log.Printf("Failed to list webhook outbox: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		id := strings.TrimSuffix(name, ".json")
+
+		d.inflightMu.Lock()
+		busy := d.inflight[id]
+		d.inflightMu.Unlock()
+		if busy {
+			continue
+		}
+
+		// A worker may have finished the delivery since the listing
+		dl, err := d.loadDelivery(id)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Dropping unreadable webhook delivery %s: %v", id, err)
+			os.Remove(d.deliveryPath(id))
+			continue
+		}
+		if dl.NextAttempt.After(now) {
+			continue
+		}
+
+		select {
+		case d.slots <- struct{}{}:
+		case <-d.done:
+			return
+		}
+		d.inflightMu.Lock()
+		d.inflight[id] = true
+		d.inflightMu.Unlock()
+
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.deliver(dl)
+
+			d.inflightMu.Lock()
+			delete(d.inflight, dl.ID)
+			d.inflightMu.Unlock()
+			<-d.slots
+		}()
+	}
+}
+
+// deliver makes one attempt at a delivery and records the outcome
+func (d *Dispatcher) deliver(dl *delivery) {
+	d.mu.RLock()
+	sub, ok := d.subs[dl.SubscriptionID]
+	var target Subscription
+	if ok {
+		target = *sub
+	}
+	d.mu.RUnlock()
+	if !ok {
+		os.Remove(d.deliveryPath(dl.ID))
+		return
+	}
+
+	dl.Attempts++
+	start := time.Now()
+	statusCode, err := d.post(&target, dl)
+	attempt := Attempt{
+		DeliveryID: dl.ID,
+		Event:      dl.Event,
+		Attempt:    dl.Attempts,
+		StatusCode: statusCode,
+		DurationMs: time.Since(start).Milliseconds(),
+		Time:       start.UTC(),
+	}
+
+	switch {
+	case err == nil:
+		attempt.Status = StatusDelivered
+		os.Remove(d.deliveryPath(dl.ID))
+	case dl.Attempts >= d.maxAttempts:
+		attempt.Status = StatusFailed
+		attempt.Error = err.Error()
+		log.Printf("Giving up on webhook delivery %s to %s after %d attempts: %v", dl.ID, target.ID, dl.Attempts, err)
+		os.Remove(d.deliveryPath(dl.ID))
+	default:
+		attempt.Status = StatusRetrying
+		attempt.Error = err.Error()
+		dl.NextAttempt = time.Now().Add(backoff(dl.Attempts)).UTC()
+		attempt.NextAttempt = &dl.NextAttempt
+		if err := d.saveDelivery(dl); err != nil {
+			log.Printf("Failed to reschedule webhook delivery %s: %v", dl.ID, err)
+		}
+	}
+
+	d.recordAttempt(target.ID, &attempt)
+}
+
+// post sends a delivery, returning the response status and an error for
+// anything but a 2xx response
+func (d *Dispatcher) post(sub *Subscription, dl *delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(dl.Body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dvfs-storage-node-webhook")
+	req.Header.Set(EventHeader, dl.Event)
+	req.Header.Set(DeliveryHeader, dl.ID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(sub.Secret, timestamp, dl.Body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseError))
+		message := strings.TrimSpace(string(excerpt))
+		if message == "" {
+			message = http.StatusText(resp.StatusCode)
+		}
+		return resp.StatusCode, fmt.Errorf("endpoint returned %d: %s", resp.StatusCode, message)
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseError))
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex HMAC-SHA256 that receivers recompute to verify a
+// delivery: the secret keys a hash of the timestamp header, a dot and the
+// raw body
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff returns the wait after the given number of failed attempts, with
+// up to 20% jitter so retries to one endpoint spread out
+func backoff(attempts int) time.Duration {
+	wait := retryMax
+	if attempts < 20 {
+		if doubled := retryBase << (attempts - 1); doubled < retryMax {
+			wait = doubled
+		}
+	}
+	return wait + time.Duration(rand.Int63n(int64(wait)/5+1))
+}
+
+// recordAttempt appends an attempt to the delivery log of a subscription,
+// trimming the log to its most recent attempts once it grows too large
+func (d *Dispatcher) recordAttempt(id string, attempt *Attempt) {
+	line, err := json.Marshal(attempt)
+	if err != nil {
+		return
+	}
+
+	d.logMu.Lock()
+	defer d.logMu.Unlock()
+
+	// The subscription may have been removed while the attempt was running
+	if _, err := d.Subscription(id); err != nil {
+		return
+	}
+
+	path := d.logPath(id)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Failed to record webhook delivery: %v", err)
+		return
+	}
+	file.Write(append(line, '\n'))
+	info, err := file.Stat()
+	file.Close()
+	if err != nil || info.Size() <= deliveryLogMaxBytes {
+		return
+	}
+
+	attempts, err := readAttempts(path)
+	if err != nil || len(attempts) <= deliveryLogKeep {
+		return
+	}
+	var buf bytes.Buffer
+	for _, a := range attempts[len(attempts)-deliveryLogKeep:] {
+		data, _ := json.Marshal(a)
+		buf.Write(append(data, '\n'))
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err == nil {
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+		}
+	}
+}
+
+// readAttempts reads a delivery log, oldest first
+func readAttempts(path string) ([]Attempt, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var attempts []Attempt
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var attempt Attempt
+		if json.Unmarshal(scanner.Bytes(), &attempt) == nil {
+			attempts = append(attempts, attempt)
+		}
+	}
+	return attempts, scanner.Err()
+}
+
+// saveDelivery writes an outbox entry through a temporary file
+func (d *Dispatcher) saveDelivery(dl *delivery) error {
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	path := d.deliveryPath(dl.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// loadDelivery reads an outbox entry
+func (d *Dispatcher) loadDelivery(id string) (*delivery, error) {
+	data, err := os.ReadFile(d.deliveryPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var dl delivery
+	if err := json.Unmarshal(data, &dl); err != nil {
+		return nil, err
+	}
+	return &dl, nil
+}
+
+// subscriptionsPath returns the record of API subscriptions
+func (d *Dispatcher) subscriptionsPath() string {
+	return filepath.Join(d.dir, "subscriptions.json")
+}
+
+// outboxPath returns the directory of pending deliveries
+func (d *Dispatcher) outboxPath() string {
+	return filepath.Join(d.dir, "outbox")
+}
+
+// deliveryPath returns the outbox entry of a delivery
+func (d *Dispatcher) deliveryPath(id string) string {
+	return filepath.Join(d.outboxPath(), id+".json")
+}
+
+// logPath returns the delivery log of a subscription
+func (d *Dispatcher) logPath(id string) string {
+	return filepath.Join(d.dir, "deliveries", id+".log")
+}
@@ -0,0 +1,200 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":"1","event":"upload"}`)
+	signature := Sign("secret", "1700000000", body)
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	if want := hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("Sign = %s, want %s", signature, want)
+	}
+
+	for name, other := range map[string]string{
+		"secret":    Sign("other", "1700000000", body),
+		"timestamp": Sign("secret", "1700000001", body),
+		"body":      Sign("secret", "1700000000", append(body, ' ')),
+	} {
+		if other == signature {
+			t.Errorf("changing the %s does not change the signature", name)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	want := retryBase
+	for attempts := 1; attempts <= 30; attempts++ {
+		wait := backoff(attempts)
+		if wait < want || wait > want+want/5 {
+			t.Errorf("backoff(%d) = %v, want %v plus at most 20%%", attempts, wait, want)
+		}
+		if want *= 2; want > retryMax {
+			want = retryMax
+		}
+	}
+}
+
+// newTestDispatcher opens a dispatcher in dir and closes it when the test
+// ends
+func newTestDispatcher(t *testing.T, dir string, maxAttempts int) *Dispatcher {
+	t.Helper()
+	d, err := NewDispatcher(dir, "", 5*time.Second, maxAttempts)
+	if err != nil {
+		t.Fatalf("NewDispatcher: %v", err)
+	}
+	t.Cleanup(d.Close)
+	return d
+}
+
+// outbox returns the deliveries waiting in the outbox of d
+func outbox(t *testing.T, d *Dispatcher) []*delivery {
+	t.Helper()
+	entries, err := os.ReadDir(d.outboxPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deliveries []*delivery
+	for _, entry := range entries {
+		dl, err := d.loadDelivery(entry.Name()[:len(entry.Name())-len(".json")])
+		if err != nil {
+			t.Fatal(err)
+		}
+		deliveries = append(deliveries, dl)
+	}
+	return deliveries
+}
+
+func TestRetryIsScheduled(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	// A closed dispatcher still queues but no longer delivers on its own
+	d := newTestDispatcher(t, t.TempDir(), 2)
+	d.Close()
+	sub, err := d.Subscribe(&Subscription{ID: "hook", URL: server.URL})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := d.Emit(EventUpload, map[string]string{"id": "file"}); err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+
+	queued := outbox(t, d)
+	if len(queued) != 1 {
+		t.Fatalf("outbox holds %d deliveries, want 1", len(queued))
+	}
+	before := time.Now()
+	d.deliver(queued[0])
+
+	queued = outbox(t, d)
+	if len(queued) != 1 || queued[0].Attempts != 1 {
+		t.Fatalf("outbox after a failure = %+v, want one delivery with one attempt", queued)
+	}
+	if wait := queued[0].NextAttempt.Sub(before); wait < retryBase || wait > retryBase+retryBase/5+time.Second {
+		t.Errorf("retry scheduled after %v, want about %v", wait, retryBase)
+	}
+
+	// The last allowed attempt gives up and empties the outbox
+	d.deliver(queued[0])
+	if queued := outbox(t, d); len(queued) != 0 {
+		t.Errorf("outbox holds %d deliveries after the last attempt", len(queued))
+	}
+
+	attempts, err := d.Deliveries(sub.ID, 0)
+	if err != nil {
+		t.Fatalf("Deliveries: %v", err)
+	}
+	if len(attempts) != 2 || attempts[0].Status != StatusFailed || attempts[1].Status != StatusRetrying {
+		t.Errorf("delivery log = %+v, want failed after retrying", attempts)
+	}
+	if attempts[1].NextAttempt == nil || attempts[1].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("retrying attempt = %+v, want status 503 and a next attempt", attempts[1])
+	}
+}
+
+func TestOutboxResumesAfterRestart(t *testing.T) {
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	received := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- request{r.Header.Clone(), body}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	d := newTestDispatcher(t, dir, 3)
+	sub, err := d.Subscribe(&Subscription{ID: "hook", URL: server.URL, Secret: "s3cret", Events: []string{EventDelete}})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// Queue events while the dispatcher is down
+	d.Close()
+	if err := d.Emit(EventUpload, "ignored"); err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+	if err := d.Emit(EventDelete, map[string]string{"id": "file"}); err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+	if queued := outbox(t, d); len(queued) != 1 {
+		t.Fatalf("outbox holds %d deliveries, want only the subscribed event", len(queued))
+	}
+
+	restarted := newTestDispatcher(t, dir, 3)
+	var req request
+	select {
+	case req = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued delivery was not sent after the restart")
+	}
+
+	if got := req.header.Get(EventHeader); got != EventDelete {
+		t.Errorf("%s = %q, want %q", EventHeader, got, EventDelete)
+	}
+	want := "sha256=" + Sign("s3cret", req.header.Get(TimestampHeader), req.body)
+	if got := req.header.Get(SignatureHeader); got != want {
+		t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+	}
+	var payload Payload
+	if err := json.Unmarshal(req.body, &payload); err != nil || payload.Event != EventDelete {
+		t.Errorf("payload = %s, want a delete event", req.body)
+	}
+
+	// Wait for the outcome to be recorded
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		attempts, err := restarted.Deliveries(sub.ID, 0)
+		if err != nil {
+			t.Fatalf("Deliveries: %v", err)
+		}
+		if len(attempts) == 1 && attempts[0].Status == StatusDelivered {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("delivery log = %+v, want one delivered attempt", attempts)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if queued := outbox(t, restarted); len(queued) != 0 {
+		t.Errorf("outbox holds %d deliveries after delivery", len(queued))
+	}
+}
@@ -0,0 +1,179 @@
+// Package webhook notifies subscribed HTTP endpoints of file lifecycle
+// events. Deliveries are signed, queued in a persistent outbox and retried
+// with exponential backoff until they succeed or run out of attempts.
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Events sent to subscribers
+const (
+	EventUpload = "upload"
+	EventUpdate = "update"
+	EventDelete = "delete"
+	EventExpire = "expire"
+)
+
+// Events lists every event a subscription can select
+var Events = []string{EventUpload, EventUpdate, EventDelete, EventExpire}
+
+// maxIDLength is the longest subscription ID
+const maxIDLength = 64
+
+// Errors returned for subscriptions
+var (
+	ErrNotFound         = errors.New("webhook subscription not found")
+	ErrStatic           = errors.New("webhook subscription is defined in the subscriptions file")
+	ErrExists           = errors.New("webhook subscription ID is already in use")
+	ErrInvalidURL       = errors.New("webhook URL must be an absolute http or https URL")
+	ErrInvalidEvent     = errors.New("unknown webhook event")
+	ErrInvalidID        = errors.New("webhook ID must be 1 to 64 letters, digits, '-' or '_'")
+	ErrSecretRequired   = errors.New("webhook secret is required")
+	errNullSubscription = errors.New("subscriptions must be objects")
+)
+
+// Subscription sends the selected events to URL, signed with Secret
+type Subscription struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Events selects the events to send; empty means all of them
+	Events []string `json:"events,omitempty"`
+	Secret string   `json:"secret,omitempty"`
+	// Static subscriptions come from the subscriptions file and cannot be
+	// changed through the API
+	Static    bool      `json:"static,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Wants reports whether the subscription selects event
+func (s *Subscription) Wants(event string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacted returns a copy of the subscription without its secret
+func (s *Subscription) Redacted() *Subscription {
+	redacted := *s
+	redacted.Secret = ""
+	return &redacted
+}
+
+// validate checks a subscription before it is accepted
+func (s *Subscription) validate() error {
+	if !validID(s.ID) {
+		return ErrInvalidID
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidURL
+	}
+	for _, event := range s.Events {
+		if !knownEvent(event) {
+			return fmt.Errorf("%w: %q", ErrInvalidEvent, event)
+		}
+	}
+	if s.Secret == "" {
+		return ErrSecretRequired
+	}
+	return nil
+}
+
+// subscriptionsFile is the format of the subscriptions file and of the
+// record of subscriptions created through the API
+type subscriptionsFile struct {
+	Subscriptions []*Subscription `json:"subscriptions"`
+}
+
+// loadSubscriptions reads the subscriptions in path. A missing file holds
+// no subscriptions.
+func loadSubscriptions(path string) ([]*Subscription, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var file subscriptionsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid subscriptions file %s: %w", path, err)
+	}
+	for _, sub := range file.Subscriptions {
+		if sub == nil {
+			return nil, fmt.Errorf("invalid subscriptions file %s: %w", path, errNullSubscription)
+		}
+		if err := sub.validate(); err != nil {
+			return nil, fmt.Errorf("subscription %q in %s: %w", sub.ID, path, err)
+		}
+	}
+	return file.Subscriptions, nil
+}
+
+// saveSubscriptions writes subs to path through a temporary file
+func saveSubscriptions(path string, subs []*Subscription) error {
+	data, err := json.MarshalIndent(&subscriptionsFile{Subscriptions: subs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// knownEvent reports whether event is one of Events
+func knownEvent(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// validID reports whether id is usable as a subscription ID, which also
+// names its delivery log file
+func validID(id string) bool {
+	if id == "" || len(id) > maxIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newSecret returns a random signing secret
+func newSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}